@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	_ "github.com/lib/pq"           // support postgres targets
+	_ "github.com/mattn/go-sqlite3" // support sqlite3 targets
+	"github.com/tanema/binlog-parser/src/sink"
+)
+
+// applyBinlogFiles executes the row changes of the binlogs against the target
+// database, one transaction per binlog transaction
+func applyBinlogFiles(binlogFilenames []string, dbDsn string) error {
+	if *targetDsnFlag == "" {
+		return fmt.Errorf("apply needs a -target_dsn")
+	}
+	schemas, err := parseRenames(*renameSchemasFlag)
+	if err != nil {
+		return err
+	}
+	tables, err := parseRenames(*renameTablesFlag)
+	if err != nil {
+		return err
+	}
+	conflict := sink.ConflictPolicy(*conflictFlag)
+	switch conflict {
+	case sink.ConflictError, sink.ConflictSkip, sink.ConflictOverwrite:
+	default:
+		return fmt.Errorf("unknown conflict policy %q", *conflictFlag)
+	}
+
+	apply, err := sink.OpenApply(*targetDsnFlag)
+	if err != nil {
+		return err
+	}
+	apply.Conflict = conflict
+	apply.Schemas = schemas
+	apply.Tables = tables
+	apply.BatchSize = *insertBatchSizeFlag
+	return runParser(binlogFilenames, dbDsn, apply, parseOptions{})
+}
+
+// parseRenames parses a comma-separated list of from:to names
+func parseRenames(list string) (map[string]string, error) {
+	renames := map[string]string{}
+	if list == "" {
+		return renames, nil
+	}
+	for _, rename := range strings.Split(list, ",") {
+		names := strings.SplitN(rename, ":", 2)
+		if len(names) != 2 || names[0] == "" {
+			return nil, fmt.Errorf("invalid rename %q, expected from:to", rename)
+		}
+		renames[names[0]] = names[1]
+	}
+	return renames, nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/tanema/binlog-parser/src/stats"
+)
+
+// diffBinlogFiles compares the transactions of the binlogs before the --
+// argument with the ones after it
+func diffBinlogFiles(binlogFilenames []string, dbDsn string) error {
+	split := -1
+	for i, name := range binlogFilenames {
+		if name == "--" {
+			split = i
+			break
+		}
+	}
+	if split <= 0 || split == len(binlogFilenames)-1 {
+		return errors.New("diff needs two binlog sets separated by --")
+	}
+
+	left, right := stats.NewTransactionSet(), stats.NewTransactionSet()
+	if err := runParser(binlogFilenames[:split], dbDsn, left, parseOptions{}); err != nil {
+		return err
+	}
+	if err := runParser(binlogFilenames[split+1:], dbDsn, right, parseOptions{}); err != nil {
+		return err
+	}
+	report, err := stats.Compare(left, right, *diffByFlag)
+	if err != nil {
+		return err
+	}
+	switch *statsFormatFlag {
+	case "table":
+		err = report.WriteTable(os.Stdout)
+	case "json":
+		err = writeJSON(report)
+	default:
+		err = fmt.Errorf("unknown stats format %q", *statsFormatFlag)
+	}
+	if err == nil && report.Differs() {
+		err = errors.New("the binlogs differ")
+	}
+	return err
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"os"
+
+	"github.com/tanema/binlog-parser/src/format"
+	"github.com/tanema/binlog-parser/src/parser"
+)
+
+// flashbackBinlogFiles prints the SQL undoing the row changes of the binlogs.
+// The undo transactions are collected and printed newest first, so they can
+// be applied in the printed order.
+func flashbackBinlogFiles(binlogFilenames []string, dbDsn string) error {
+	transactions := &transactionCollector{formatter: format.NewFlashback()}
+	if err := runParser(binlogFilenames, dbDsn, transactions, parseOptions{}); err != nil {
+		return err
+	}
+	for i := len(transactions.output) - 1; i >= 0; i-- {
+		if _, err := os.Stdout.Write(append(transactions.output[i], '\n')); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// transactionCollector is a sink keeping the output of each transaction
+type transactionCollector struct {
+	formatter format.TransactionFormatter
+	output    [][]byte
+}
+
+func (c *transactionCollector) Write(message parser.Message) error {
+	_, err := c.formatter.Format(message)
+	return err
+}
+
+func (c *transactionCollector) Commit(xid uint64) error {
+	data, err := c.formatter.Commit(xid)
+	if data != nil {
+		c.output = append(c.output, data)
+	}
+	return err
+}
+
+func (c *transactionCollector) Flush() error {
+	return nil
+}
+
+func (c *transactionCollector) Close() error {
+	return nil
+}
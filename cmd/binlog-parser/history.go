@@ -0,0 +1,30 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/tanema/binlog-parser/src/stats"
+)
+
+// historyBinlogFiles prints every change of the binlogs to the row selected
+// by -table and -pk, with the originating statements if they were logged
+func historyBinlogFiles(binlogFilenames []string, dbDsn string) error {
+	if *tableFlag == "" || *pkFlag == "" {
+		return errors.New("history needs -table and -pk")
+	}
+	history := stats.NewHistory(*tableFlag, strings.Split(*pkFlag, ","))
+	*rowsQueryFlag = true
+	if err := runParser(binlogFilenames, dbDsn, history, parseOptions{}); err != nil {
+		return err
+	}
+	switch *statsFormatFlag {
+	case "table":
+		return history.WriteTable(os.Stdout)
+	case "json":
+		return writeJSON(history.Changes)
+	}
+	return fmt.Errorf("unknown stats format %q", *statsFormatFlag)
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/tanema/binlog-parser/src/stats"
+)
+
+// hotRowsBinlogFiles prints the rows of the binlogs that are updated and
+// deleted most often
+func hotRowsBinlogFiles(binlogFilenames []string, dbDsn string) error {
+	hot := stats.NewHotRows()
+	hot.Top, hot.Counters = *hotTopFlag, *hotCountersFlag
+	if err := runParser(binlogFilenames, dbDsn, hot, parseOptions{}); err != nil {
+		return err
+	}
+	report := hot.Report()
+	switch *statsFormatFlag {
+	case "table":
+		return report.WriteTable(os.Stdout)
+	case "json":
+		return writeJSON(report)
+	}
+	return fmt.Errorf("unknown stats format %q", *statsFormatFlag)
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/tanema/binlog-parser/src/stats"
+)
+
+// largeTransactionsBinlogFiles prints the transactions of the binlogs that
+// are above the thresholds of the command line
+func largeTransactionsBinlogFiles(binlogFilenames []string, dbDsn string) error {
+	detector := stats.NewLargeTransactions(stats.Thresholds{
+		Rows:     *largeRowsFlag,
+		Bytes:    *largeBytesFlag,
+		Duration: *largeDurationFlag,
+		Tables:   *largeTablesFlag,
+	})
+	*rowsQueryFlag = true
+	if err := runParser(binlogFilenames, dbDsn, detector, parseOptions{}); err != nil {
+		return err
+	}
+	switch *statsFormatFlag {
+	case "table":
+		return detector.WriteTable(os.Stdout)
+	case "json":
+		return writeJSON(detector.Found)
+	}
+	return fmt.Errorf("unknown stats format %q", *statsFormatFlag)
+}
+
+// writeJSON prints the value as JSON, pretty printed with -prettyprint
+func writeJSON(value interface{}) error {
+	var data []byte
+	var err error
+	if *prettyPrintJSONFlag {
+		data, err = json.MarshalIndent(value, "", "    ")
+	} else {
+		data, err = json.Marshal(value)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(append(data, '\n'))
+	return err
+}
@@ -1,29 +1,131 @@
 package main
 
 import (
-	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"os"
+	"os/signal"
 	"path"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/tanema/binlog-parser/src/database"
+	"github.com/tanema/binlog-parser/src/format"
 	"github.com/tanema/binlog-parser/src/parser"
+	"github.com/tanema/binlog-parser/src/restore"
+	"github.com/tanema/binlog-parser/src/sink"
+	"github.com/tanema/binlog-parser/src/stats"
 )
 
 var prettyPrintJSONFlag = flag.Bool("prettyprint", false, "Pretty print json")
 var includeTablesFlag = flag.String("include_tables", "", "comma-separated list of tables to include")
 var includeSchemasFlag = flag.String("include_schemas", "", "comma-separated list of schemas to include")
+var rowsQueryFlag = flag.Bool("rows_query", false, "Attach the original statement from ROWS_QUERY events to row messages")
+var includeQueriesFlag = flag.String("include_queries", "", "comma-separated list of substrings the originating statement must contain")
+var outputFlag = flag.String("output", "stdout://", "Output URI, stdout://, file:///path/changes-%Y%m%d.ndjson or tables:///path/changes-%Y%m%d.ndjson for a file per table, kafka://broker:9092 or https://host/webhook, files take the parameters max_size and gzip")
+var formatFlag = flag.String("format", "json", "Output format, one of json, debezium, maxwell, sql, csv, tsv, avro, parquet, protobuf")
+var serverNameFlag = flag.String("server_name", "binlog-parser", "Logical server name used by the debezium format")
+var debeziumSchemaFlag = flag.Bool("debezium_schema", false, "Include the Kafka Connect schema in debezium output")
+var debeziumSnapshotFlag = flag.Bool("debezium_snapshot", false, "Mark inserts as snapshot reads (op r) in debezium output, for backfilling initial state")
+var outputDirFlag = flag.String("output_dir", ".", "Directory the csv, tsv, avro and parquet formats write their files into")
+var csvUpdatesFlag = flag.String("csv_updates", "pairs", "How the csv and tsv formats write updates, pairs of before and after rows or only the after row, one of pairs, after")
+var avroCodecFlag = flag.String("avro_codec", format.AvroCodecDeflate, "Block compression of the avro format, one of null, deflate")
+var parquetFileSizeFlag = flag.Int64("parquet_file_size", format.ParquetDefaultFileSize, "Size in bytes at which the parquet format starts a new file")
+var schemaVersionFlag = flag.Int("schema_version", parser.SchemaVersion1, "Output schema version, 2 adds server id, positions, event size and type, binlog file and row index")
+var startDatetimeFlag = flag.String("start_datetime", "", "Only include events at or after this UTC time, formatted as 2006-01-02 15:04:05")
+var stopDatetimeFlag = flag.String("stop_datetime", "", "Only include events before this UTC time, formatted as 2006-01-02 15:04:05")
+var startPositionFlag = flag.Uint("start_position", 0, "Only include events starting at or after this position of a single binlog")
+var stopPositionFlag = flag.String("stop_position", "", "Only include events starting before this position of a single binlog, as binlog:position stop at the first transaction starting at or after the position of that binlog")
+var resumeFlag = flag.String("resume", "", "Checkpoint file of the kafka output, parsing resumes after the transaction it names if it exists")
+var targetDsnFlag = flag.String("target_dsn", "", "Database the apply command writes to, a MySQL DSN or mysql://, postgres:// or sqlite3:// URI")
+var conflictFlag = flag.String("conflict", string(sink.ConflictError), "How apply handles inserts of existing and changes of missing rows, one of error, skip, overwrite")
+var renameSchemasFlag = flag.String("rename_schemas", "", "comma-separated list of from:to schema names for apply, an empty name leaves table names unqualified")
+var renameTablesFlag = flag.String("rename_tables", "", "comma-separated list of from:to table names for apply, as table or schema.table")
+var statsFormatFlag = flag.String("stats_format", "table", "Output of the stats, large_transactions, hot_rows, history, diff and verify commands, one of table, json")
+var largeRowsFlag = flag.Int("large_rows", 10000, "Row count above which large_transactions reports a transaction, 0 is off")
+var largeBytesFlag = flag.Uint64("large_bytes", 100<<20, "Size in bytes above which large_transactions reports a transaction, 0 is off")
+var largeDurationFlag = flag.Duration("large_duration", time.Minute, "Time between BEGIN and XID above which large_transactions reports a transaction, 0 is off")
+var largeTablesFlag = flag.Int("large_tables", 0, "Number of tables above which large_transactions reports a transaction, 0 is off")
+var hotTopFlag = flag.Int("hot_top", stats.DefaultHotTop, "Number of rows hot_rows prints")
+var hotCountersFlag = flag.Int("hot_counters", stats.DefaultHotCounters, "Number of rows hot_rows counts at a time, bounds its memory")
+var tableFlag = flag.String("table", "", "Table of the row history prints, as schema.table or table, or the table restore rebuilds, as schema.table")
+var pkFlag = flag.String("pk", "", "comma-separated primary key values of the row history prints, in key order")
+var snapshotFlag = flag.String("snapshot", "", "Snapshot of the table restore starts from, a CSV, NDJSON or mysqldump file")
+var snapshotFormatFlag = flag.String("snapshot_format", "", "Format of the -snapshot, one of csv, ndjson, sql, guessed from the file extension by default")
+var storeFlag = flag.String("store", "", "SQLite file restore keeps the table in, a temporary file by default")
+var restoreFormatFlag = flag.String("restore_format", restore.FormatNDJSON, "Output of the restore command, one of csv, ndjson")
+var diffByFlag = flag.String("diff_by", stats.DiffByGTID, "How diff aligns transactions, one of gtid, xid, hash")
+var verifyChecksumFlag = flag.Bool("verify_checksum", false, "Check the CRC32 of each event of binlogs written with binlog_checksum=CRC32")
+var skipCorruptFlag = flag.Bool("skip_corrupt", false, "Skip corrupt events and resynchronize at the next valid event header instead of stopping")
+var insertBatchSizeFlag = flag.Int("insert_batch_size", sink.ApplyDefaultBatchSize, "Number of consecutive inserts into a table apply executes as one statement")
+
+const datetimeFlagLayout = "2006-01-02 15:04:05"
+
+// commands are the modes selected by the first argument, without one the
+// binlogs are parsed to the selected output format
+var commands = map[string]func(binlogFilenames []string, dbDsn string) error{
+	"flashback":          flashbackBinlogFiles,
+	"apply":              applyBinlogFiles,
+	"stats":              statsBinlogFiles,
+	"large_transactions": largeTransactionsBinlogFiles,
+	"hot_rows":           hotRowsBinlogFiles,
+	"history":            historyBinlogFiles,
+	"restore":            restoreBinlogFiles,
+	"diff":               diffBinlogFiles,
+	"verify":             verifyBinlogFiles,
+}
+
+// offlineCommands work without a database, the connection string is
+// optional if the first argument is a binlog file
+var offlineCommands = map[string]bool{
+	"stats":              true,
+	"large_transactions": true,
+	"hot_rows":           true,
+	"history":            true,
+	"diff":               true,
+	"verify":             true,
+}
+
+// eventSink is a sink that also counts the binlog events
+type eventSink interface {
+	sink.Sink
+	Event(event parser.EventMetadata, timestamp time.Time) error
+}
+
+// transactionSummarySink is a sink that also gets the summary of each
+// transaction
+type transactionSummarySink interface {
+	sink.Sink
+	Transaction(transaction parser.Transaction) error
+}
 
 func main() {
 	flag.Usage = printUsage
-	flag.Parse()
-	if flag.NArg() != 2 {
+	args := os.Args[1:]
+	command, name := parseBinlogFiles, ""
+	if len(args) > 0 {
+		if c, ok := commands[args[0]]; ok {
+			command, name = c, args[0]
+			args = args[1:]
+		}
+	}
+	flag.CommandLine.Parse(args)
+	dbDsn, binlogFilenames := flag.Arg(0), flag.Args()
+	if len(binlogFilenames) > 0 {
+		binlogFilenames = binlogFilenames[1:]
+	}
+	if info, err := os.Stat(dbDsn); (dbDsn == parser.Stdin || err == nil && !info.IsDir()) && offlineCommands[name] {
+		dbDsn, binlogFilenames = "", flag.Args()
+	}
+	if len(binlogFilenames) == 0 {
 		printUsage()
 		os.Exit(1)
 	}
-	if err := parseBinlogFile(flag.Arg(1), flag.Arg(0)); err != nil {
+	if err := command(binlogFilenames, dbDsn); err != nil {
 		fmt.Fprintf(os.Stderr, "Got error: %s\n", err)
 		os.Exit(1)
 	}
@@ -31,43 +133,269 @@ func main() {
 
 func printUsage() {
 	binName := path.Base(os.Args[0])
-	usage := "Parse a binlog file, dump JSON to stdout. Includes options to filter by schema and table.\n" +
+	usage := "Parse binlog files, dump JSON to stdout. Includes options to filter by schema and table.\n" +
 		"Reads from information_schema database to find out the field names for a row event.\n\n" +
-		"Usage:\t%s [options ...] connectionString binlog\n\n" +
+		"Usage:\t%s [options ...] connectionString binlog ...\n" +
+		"\t%s flashback [options ...] connectionString binlog ...\n" +
+		"\t%s apply -target_dsn dsn [options ...] connectionString binlog ...\n" +
+		"\t%s stats [options ...] [connectionString] binlog ...\n" +
+		"\t%s large_transactions [options ...] [connectionString] binlog ...\n" +
+		"\t%s hot_rows [options ...] [connectionString] binlog ...\n" +
+		"\t%s history -table schema.table -pk value [options ...] [connectionString] binlog ...\n" +
+		"\t%s restore -table schema.table -snapshot file [options ...] connectionString binlog ...\n" +
+		"\t%s diff [options ...] [connectionString] binlog ... -- binlog ...\n" +
+		"\t%s verify [options ...] binlog ...\n\n" +
+		"Commands are:\n\n" +
+		"  flashback\n\tprint SQL that undoes the row changes, newest transaction first\n" +
+		"  apply\n\texecute the row changes against the -target_dsn database\n" +
+		"  stats\n\tprint events, rows per table, the largest transactions and writes per minute, works without database\n" +
+		"  large_transactions\n\tprint the transactions above the -large_* thresholds, works without database\n" +
+		"  hot_rows\n\tprint the most updated and deleted rows by primary key and the changes per table, works without database\n" +
+		"  history\n\tprint every insert, update and delete of one row with time, position and statement, works without database\n" +
+		"  restore\n\tprint the rows of a table rebuilt from a snapshot and the row changes up to the stop filters\n" +
+		"  diff\n\tprint the transactions missing, extra or different in the second binlog set, works without database\n" +
+		"  verify\n\tcheck the checksum, size and position of every event and print the offset of the first corrupt one\n\n" +
 		"Options are:\n\n"
-	fmt.Fprintf(os.Stderr, usage, binName)
+	fmt.Fprintf(os.Stderr, usage, binName, binName, binName, binName, binName, binName, binName, binName, binName, binName)
 	flag.PrintDefaults()
 }
 
-func parseBinlogFile(binlogFilename, dbDsn string) error {
-	db, err := database.GetDatabaseInstance(dbDsn)
+func parseBinlogFiles(binlogFilenames []string, dbDsn string) error {
+	formatter, err := newFormatter(*formatFlag)
+	if err != nil {
+		return err
+	}
+	out, err := sink.Open(*outputFlag, formatter)
 	if err != nil {
 		return err
 	}
+	return runParser(binlogFilenames, dbDsn, out, parseOptions{})
+}
+
+var errPositionBinlogs = errors.New("-start_position and -stop_position apply to a single binlog, unless -stop_position names it as binlog:position")
+
+// parseOptions are the settings of a command for runParser on top of the
+// flags
+type parseOptions struct {
+	// schema and table, if set, select the table instead of -include_schemas
+	// and -include_tables
+	schema, table string
+	// wholeTransactions makes the stop filters end parsing at the first
+	// transaction starting at or after them, instead of leaving out the
+	// events after them
+	wholeTransactions bool
+}
+
+// runParser parses the binlogs in order with the filters of the command line
+// into the sink. The sink is flushed at the end of every transaction and
+// closed at the end, also when parsing is stopped by an interrupt or
+// termination signal.
+func runParser(binlogFilenames []string, dbDsn string, out sink.Sink, options parseOptions) (err error) {
+	defer func() {
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+
+	db := database.Offline()
+	if dbDsn != "" {
+		if db, err = database.GetDatabaseInstance(dbDsn); err != nil {
+			return err
+		}
+	}
 	defer db.Close()
 
-	if _, err := os.Stat(binlogFilename); os.IsNotExist(err) {
+	// positions are offsets within a binlog file, they can't be told apart
+	// across several binlogs
+	stopFile, stopPosition, err := parsePositionFlag(*stopPositionFlag)
+	if err != nil {
 		return err
 	}
+	positions := *startPositionFlag != 0 || stopFile == "" && stopPosition != 0
+	if positions && len(binlogFilenames) > 1 {
+		return errPositionBinlogs
+	}
+	for _, binlogFilename := range binlogFilenames {
+		if _, err := os.Stat(binlogFilename); os.IsNotExist(err) && binlogFilename != parser.Stdin {
+			return err
+		}
+	}
 
-	p := parser.New(db, consume)
-	p.IncludeTables(strings.Split(*includeTablesFlag, ","))
-	p.IncludeSchemas(strings.Split(*includeSchemasFlag, ","))
-	return p.ParseFile(binlogFilename, 0)
+	interrupted := make(chan os.Signal, 1)
+	signal.Notify(interrupted, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(interrupted)
+
+	p := parser.New(db, stopOnSignal(interrupted, out.Write))
+	p.OnCommit(commit(out))
+	if events, ok := out.(eventSink); ok {
+		p.OnEvent(events.Event)
+	}
+	if err := applyFilters(&p, options); err != nil {
+		return err
+	}
+	if transactions, ok := out.(transactionSummarySink); ok {
+		p.OnTransaction(transactions.Transaction)
+	}
+	parsed := 0
+	parseReader := func(binlogFile string, r io.Reader) error {
+		// an archive or directory holds several binlogs
+		if parsed++; positions && parsed > 1 {
+			return errPositionBinlogs
+		}
+		return p.ParseReader(binlogFile, r)
+	}
+	for _, binlogFilename := range binlogFilenames {
+		if err := parser.OpenBinlogs(binlogFilename, parseReader); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func consume(message parser.Message) error {
-	json, err := marshalMessage(message)
+func applyFilters(p *parser.Parser, options parseOptions) error {
+	if err := p.SetSchemaVersion(*schemaVersionFlag); err != nil {
+		return err
+	}
+	if options.table != "" {
+		p.IncludeTables([]string{options.table})
+		p.IncludeSchemas([]string{options.schema})
+	} else {
+		p.IncludeTables(strings.Split(*includeTablesFlag, ","))
+		p.IncludeSchemas(strings.Split(*includeSchemasFlag, ","))
+	}
+	p.CaptureRowsQuery(*rowsQueryFlag)
+	p.VerifyChecksum(*verifyChecksumFlag)
+	if *skipCorruptFlag {
+		p.SkipCorrupt(func(err *parser.CorruptEventError) error {
+			fmt.Fprintf(os.Stderr, "Skipped %s\n", err)
+			return nil
+		})
+	}
+	p.IncludeQueries(strings.Split(*includeQueriesFlag, ","))
+
+	start, err := parseDatetimeFlag(*startDatetimeFlag)
 	if err != nil {
 		return err
 	}
-	_, err = os.Stdout.Write([]byte(fmt.Sprintf("%s\n", json)))
-	return err
+	stop, err := parseDatetimeFlag(*stopDatetimeFlag)
+	if err != nil {
+		return err
+	}
+	stopFile, stopPosition, err := parsePositionFlag(*stopPositionFlag)
+	if err != nil {
+		return err
+	}
+	if options.wholeTransactions || stopFile != "" {
+		stopTime := time.Time{}
+		if options.wholeTransactions {
+			stopTime, stop = stop, time.Time{}
+		}
+		p.StopAt(stopFile, stopPosition, stopTime)
+		stopPosition = 0
+	}
+	if *resumeFlag != "" {
+		checkpoint, err := sink.ReadCheckpoint(*resumeFlag)
+		if err == nil {
+			p.StartAt(checkpoint.BinlogFile, checkpoint.BinlogPosition)
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+	}
+	p.IncludeTimeRange(start, stop)
+	p.IncludePositionRange(uint32(*startPositionFlag), stopPosition)
+	return nil
+}
+
+// parsePositionFlag parses a binlog position, optionally preceded by the
+// binlog file as binlog:position
+func parsePositionFlag(value string) (string, uint32, error) {
+	if value == "" {
+		return "", 0, nil
+	}
+	binlogFile, position := "", value
+	if i := strings.LastIndexByte(value, ':'); i >= 0 {
+		binlogFile, position = value[:i], value[i+1:]
+	}
+	offset, err := strconv.ParseUint(position, 10, 32)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid binlog position %q", value)
+	}
+	return binlogFile, uint32(offset), nil
+}
+
+func parseDatetimeFlag(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	return time.ParseInLocation(datetimeFlagLayout, value, time.UTC)
+}
+
+func newFormatter(name string) (format.Formatter, error) {
+	switch name {
+	case "json":
+		return format.JSON{PrettyPrint: *prettyPrintJSONFlag}, nil
+	case "debezium":
+		debezium := format.NewDebezium(*serverNameFlag)
+		debezium.IncludeSchema = *debeziumSchemaFlag
+		debezium.Snapshot = *debeziumSnapshotFlag
+		debezium.PrettyPrint = *prettyPrintJSONFlag
+		return debezium, nil
+	case "maxwell":
+		maxwell := format.NewMaxwell()
+		maxwell.PrettyPrint = *prettyPrintJSONFlag
+		return maxwell, nil
+	case "sql":
+		return format.NewSQL(), nil
+	case "csv", "tsv":
+		csv := format.NewCSV(*outputDirFlag)
+		if name == "tsv" {
+			csv = format.NewTSV(*outputDirFlag)
+		}
+		switch *csvUpdatesFlag {
+		case "pairs":
+		case "after":
+			csv.UpdatePairs = false
+		default:
+			return nil, fmt.Errorf("unknown csv update mode %q", *csvUpdatesFlag)
+		}
+		return csv, nil
+	case "avro":
+		avro := format.NewAvro(*outputDirFlag)
+		switch *avroCodecFlag {
+		case format.AvroCodecNull, format.AvroCodecDeflate:
+			avro.Codec = *avroCodecFlag
+		default:
+			return nil, fmt.Errorf("unknown avro codec %q", *avroCodecFlag)
+		}
+		return avro, nil
+	case "protobuf":
+		return format.Protobuf{}, nil
+	case "parquet":
+		parquet := format.NewParquet(*outputDirFlag)
+		parquet.MaxFileSize = *parquetFileSizeFlag
+		return parquet, nil
+	}
+	return nil, fmt.Errorf("unknown output format %q", name)
+}
+
+// stopOnSignal stops parsing with an error once a signal was received
+func stopOnSignal(signals <-chan os.Signal, consumer parser.ConsumerFunc) parser.ConsumerFunc {
+	return func(message parser.Message) error {
+		select {
+		case sig := <-signals:
+			return fmt.Errorf("stopped by %s", sig)
+		default:
+			return consumer(message)
+		}
+	}
 }
 
-func marshalMessage(message parser.Message) ([]byte, error) {
-	if *prettyPrintJSONFlag {
-		return json.MarshalIndent(message, "", "    ")
+// commit ends the transaction in the sink
+func commit(out sink.Sink) parser.CommitFunc {
+	return func(xid uint64) error {
+		if transactionSink, ok := out.(sink.TransactionSink); ok {
+			return transactionSink.Commit(xid)
+		}
+		return out.Flush()
 	}
-	return json.Marshal(message)
 }
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/tanema/binlog-parser/src/database"
+	"github.com/tanema/binlog-parser/src/restore"
+	"github.com/tanema/binlog-parser/src/sink"
+)
+
+// restoreBinlogFiles rebuilds the -table from the -snapshot and the row
+// changes of the binlogs up to the stop filters, and prints the resulting
+// rows. The state is kept in the SQLite -store on disk.
+func restoreBinlogFiles(binlogFilenames []string, dbDsn string) (err error) {
+	schema, table := "", *tableFlag
+	if i := strings.IndexByte(table, '.'); i >= 0 {
+		schema, table = table[:i], table[i+1:]
+	}
+	if schema == "" || table == "" || *snapshotFlag == "" {
+		return errors.New("restore needs -table schema.table and -snapshot")
+	}
+	if *includeSchemasFlag != "" || *includeTablesFlag != "" {
+		return errors.New("restore takes the table from -table, -include_schemas and -include_tables don't apply")
+	}
+	if err := restore.CheckDriver(); err != nil {
+		return err
+	}
+	conflict := sink.ConflictPolicy(*conflictFlag)
+	switch conflict {
+	case sink.ConflictError, sink.ConflictSkip, sink.ConflictOverwrite:
+	default:
+		return fmt.Errorf("unknown conflict policy %q", *conflictFlag)
+	}
+
+	db, err := database.GetDatabaseInstance(dbDsn)
+	if err != nil {
+		return err
+	}
+	columns, err := db.Columns(schema, table)
+	db.Close()
+	if err != nil {
+		return err
+	}
+
+	storePath := *storeFlag
+	if storePath == "" {
+		file, err := ioutil.TempFile("", "binlog-parser-restore")
+		if err != nil {
+			return err
+		}
+		file.Close()
+		storePath = file.Name()
+		defer os.Remove(storePath)
+	}
+	store, err := restore.CreateStore(storePath, schema, table, columns)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := store.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+
+	snapshot, err := os.Open(*snapshotFlag)
+	if err != nil {
+		return err
+	}
+	snapshotFormat := *snapshotFormatFlag
+	if snapshotFormat == "" {
+		snapshotFormat = restore.SnapshotFormat(*snapshotFlag)
+	}
+	_, err = store.Load(snapshot, snapshotFormat)
+	snapshot.Close()
+	if err != nil {
+		return err
+	}
+
+	apply, err := store.Sink()
+	if err != nil {
+		return err
+	}
+	apply.Conflict = conflict
+	apply.BatchSize = *insertBatchSizeFlag
+	if err := runParser(binlogFilenames, dbDsn, apply, parseOptions{schema: schema, table: table, wholeTransactions: true}); err != nil {
+		return err
+	}
+	return store.Export(os.Stdout, *restoreFormatFlag)
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/tanema/binlog-parser/src/stats"
+)
+
+// statsBinlogFiles prints a summary of the binlogs. Without a connection
+// string tables are named after the TABLE_MAP events.
+func statsBinlogFiles(binlogFilenames []string, dbDsn string) error {
+	collector := stats.New()
+	if err := runParser(binlogFilenames, dbDsn, collector, parseOptions{}); err != nil {
+		return err
+	}
+	report := collector.Report()
+	switch *statsFormatFlag {
+	case "table":
+		return report.WriteTable(os.Stdout)
+	case "json":
+		return writeJSON(report)
+	}
+	return fmt.Errorf("unknown stats format %q", *statsFormatFlag)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/tanema/binlog-parser/src/parser"
+)
+
+// verifyBinlogFiles checks every event of the binlogs and prints the first
+// corrupt event of each, or all of them with -skip_corrupt
+func verifyBinlogFiles(binlogFilenames []string, dbDsn string) error {
+	if dbDsn != "" {
+		// verify takes no connection string, the first argument is a binlog
+		binlogFilenames = append([]string{dbDsn}, binlogFilenames...)
+	}
+	var verifications []parser.Verification
+	corrupt := 0
+	for _, binlogFilename := range binlogFilenames {
+		err := parser.OpenBinlogs(binlogFilename, func(binlogFile string, r io.Reader) error {
+			verification, err := parser.Verify(binlogFile, r, *skipCorruptFlag)
+			if err != nil {
+				return err
+			}
+			if !verification.OK() {
+				corrupt++
+			}
+			verifications = append(verifications, verification)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	var err error
+	switch *statsFormatFlag {
+	case "table":
+		err = writeVerifications(verifications)
+	case "json":
+		err = writeJSON(verifications)
+	default:
+		err = fmt.Errorf("unknown stats format %q", *statsFormatFlag)
+	}
+	if err == nil && corrupt > 0 {
+		err = fmt.Errorf("%d of %d binlogs are corrupt", corrupt, len(verifications))
+	}
+	return err
+}
+
+// writeVerifications prints a line per binlog and one per corrupt event
+func writeVerifications(verifications []parser.Verification) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "Binlog\tEvents\tBytes\tChecksum\tStatus\n")
+	for _, verification := range verifications {
+		status := "ok"
+		if !verification.OK() {
+			status = fmt.Sprintf("%d corrupt events, %d bytes skipped", len(verification.Corrupt), verification.Skipped)
+			if !*skipCorruptFlag {
+				status = "corrupt"
+			}
+		}
+		fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%s\n", verification.BinlogFile, verification.Events, verification.Bytes, verification.Checksum, status)
+		for _, corrupt := range verification.Corrupt {
+			fmt.Fprintf(w, "\t\t\t\t  offset %d: %s\n", corrupt.Offset, corrupt.Reason)
+		}
+	}
+	return w.Flush()
+}
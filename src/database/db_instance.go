@@ -2,8 +2,11 @@ package database
 
 import (
 	"database/sql"
-	_ "github.com/go-sql-driver/mysql" // support mysql
+	"errors"
+	"fmt"
 	"strings"
+
+	_ "github.com/go-sql-driver/mysql" // support mysql
 )
 
 // DB keeps the db connection and a map of table information
@@ -34,6 +37,32 @@ func GetDatabaseInstance(connectionString string) (*DB, error) {
 	}, nil
 }
 
+// Offline creates a DB without connection, tables are only known by the
+// schema and table names of the TABLE_MAP events, without columns
+func Offline() *DB {
+	return &DB{Map: newTableMap(nil)}
+}
+
+// Close closes the connection, if there is one
+func (db *DB) Close() error {
+	if db.DB == nil {
+		return nil
+	}
+	return db.DB.Close()
+}
+
+// Columns looks up the columns of a table, it needs a connection
+func (db *DB) Columns(schema, table string) ([]Column, error) {
+	if db.DB == nil {
+		return nil, errors.New("looking up columns needs a database connection")
+	}
+	columns, err := getColumnsFromDb(db.DB, schema, table)
+	if err == nil && len(columns) == 0 {
+		err = fmt.Errorf("table %s.%s not found", schema, table)
+	}
+	return columns, err
+}
+
 func populateTableMap(db *sql.DB) (*TableMap, error) {
 	tableInfo, err := getTableInfo(db)
 	if err != nil {
@@ -71,19 +100,26 @@ func getTableInfo(db *sql.DB) (map[string]uint64, error) {
 	return tableIDMap, nil
 }
 
-func getFieldsFromDb(db *sql.DB, schema string, table string) ([]string, error) {
-	rows, err := db.Query("SELECT COLUMN_NAME FROM INFORMATION_SCHEMA.COLUMNS WHERE TABLE_SCHEMA = ? AND TABLE_NAME = ? ORDER BY ORDINAL_POSITION", schema, table)
+func getColumnsFromDb(db *sql.DB, schema string, table string) ([]Column, error) {
+	rows, err := db.Query("SELECT COLUMN_NAME, DATA_TYPE, COLUMN_TYPE, IS_NULLABLE, COLUMN_KEY, NUMERIC_PRECISION, NUMERIC_SCALE FROM INFORMATION_SCHEMA.COLUMNS WHERE TABLE_SCHEMA = ? AND TABLE_NAME = ? ORDER BY ORDINAL_POSITION", schema, table)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	fields := []string{}
-	var columnName string
+	columns := []Column{}
 	for rows.Next() {
-		if err := rows.Scan(&columnName); err != nil {
-			return fields, err
+		var column Column
+		var isNullable, columnKey string
+		var precision, scale sql.NullInt64
+		if err := rows.Scan(&column.Name, &column.DataType, &column.ColumnType, &isNullable, &columnKey, &precision, &scale); err != nil {
+			return columns, err
 		}
-		fields = append(fields, columnName)
+		column.DataType = strings.ToLower(column.DataType)
+		column.Nullable = isNullable == "YES"
+		column.PrimaryKey = columnKey == "PRI"
+		column.Precision = int(precision.Int64)
+		column.Scale = int(scale.Int64)
+		columns = append(columns, column)
 	}
-	return fields, nil
+	return columns, nil
 }
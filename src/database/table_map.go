@@ -2,14 +2,43 @@ package database
 
 import (
 	"database/sql"
+	"strings"
 )
 
 // TableMetadata encapsulates the column data for a table
 type TableMetadata struct {
-	ID     uint64
-	Schema string
-	Table  string
-	Fields []string
+	ID      uint64
+	Schema  string
+	Table   string
+	Fields  []string
+	Columns []Column
+}
+
+// Column describes a table column as found in the information schema
+type Column struct {
+	Name       string
+	DataType   string
+	ColumnType string
+	Nullable   bool
+	PrimaryKey bool
+	Precision  int
+	Scale      int
+}
+
+// Unsigned returns true for unsigned numeric columns
+func (c Column) Unsigned() bool {
+	return strings.Contains(strings.ToLower(c.ColumnType), "unsigned")
+}
+
+// PrimaryKey returns the names of the primary key columns of the table
+func (t TableMetadata) PrimaryKey() []string {
+	var names []string
+	for _, column := range t.Columns {
+		if column.PrimaryKey {
+			names = append(names, column.Name)
+		}
+	}
+	return names
 }
 
 // TableMap keeps track of the table metadata for all tables in the database
@@ -27,18 +56,27 @@ func newTableMap(db *sql.DB) *TableMap {
 	}
 }
 
-// Add will add the metadata for this table into the database map
+// Add will add the metadata for this table into the database map, without
+// a connection the table has no columns
 func (m *TableMap) Add(id uint64, schema, table string) error {
-	fields, err := getFieldsFromDb(m.db, schema, table)
-	if err != nil {
-		return err
+	var columns []Column
+	if m.db != nil {
+		var err error
+		if columns, err = getColumnsFromDb(m.db, schema, table); err != nil {
+			return err
+		}
+	}
+	fields := make([]string, len(columns))
+	for i, column := range columns {
+		fields[i] = column.Name
 	}
 	name := schema + "/" + table
 	m.nameMap[name] = TableMetadata{
-		ID:     id,
-		Schema: schema,
-		Table:  table,
-		Fields: fields,
+		ID:      id,
+		Schema:  schema,
+		Table:   table,
+		Fields:  fields,
+		Columns: columns,
 	}
 	m.idMap[id] = name
 	return nil
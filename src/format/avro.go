@@ -0,0 +1,467 @@
+package format
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/tanema/binlog-parser/src/database"
+	"github.com/tanema/binlog-parser/src/parser"
+)
+
+const (
+	// AvroCodecNull writes uncompressed blocks
+	AvroCodecNull = "null"
+	// AvroCodecDeflate compresses blocks with deflate
+	AvroCodecDeflate = "deflate"
+
+	avroBlockSize = 1 << 20
+)
+
+var avroMagic = []byte{'O', 'b', 'j', 1}
+
+// Avro writes row messages as change records into one Avro object container
+// file per table in a directory. A record has the fields op, before, after and
+// source, where before and after follow a record schema generated from the
+// table columns. When the generated schema of a table changes a new schema
+// version is started in a new file named <schema>.<table>.v<version>.avro.
+// Query messages are skipped.
+type Avro struct {
+	Dir   string
+	Codec string
+	files map[string]*avroFile
+}
+
+type avroFile struct {
+	file    *os.File
+	codec   string
+	sync    [16]byte
+	version int
+	schema  string
+	columns []avroColumn
+	block   bytes.Buffer
+	count   int64
+}
+
+// avroColumn is a field of the row record and how its values are encoded
+type avroColumn struct {
+	column   database.Column
+	name     string
+	kind     valueKind
+	nullable bool
+}
+
+// NewAvro creates an Avro writer into the directory
+func NewAvro(dir string) *Avro {
+	return &Avro{Dir: dir, Codec: AvroCodecDeflate, files: map[string]*avroFile{}}
+}
+
+// Format appends the change record of the message to the file of its table,
+// it never returns output of its own
+func (w *Avro) Format(message parser.Message) ([]byte, error) {
+	header := message.GetHeader()
+	var op string
+	var before, after *parser.MessageRowData
+	switch m := message.(type) {
+	case parser.InsertMessage:
+		op, after = debeziumOpCreate, &m.Data
+	case parser.UpdateMessage:
+		op, before, after = debeziumOpUpdate, &m.OldData, &m.NewData
+	case parser.DeleteMessage:
+		op, before = debeziumOpDelete, &m.Data
+	default:
+		return nil, nil
+	}
+
+	f, err := w.tableFile(header, avroColumns(header, before, after))
+	if err != nil {
+		return nil, err
+	}
+	if err := f.writeRecord(header, op, before, after); err != nil {
+		return nil, err
+	}
+	if f.block.Len() >= avroBlockSize {
+		return nil, f.flush()
+	}
+	return nil, nil
+}
+
+// Commit writes the records of the transaction as blocks
+func (w *Avro) Commit(xid uint64) ([]byte, error) {
+	return nil, w.Flush()
+}
+
+// Flush writes the buffered records of all files as blocks
+func (w *Avro) Flush() error {
+	for _, f := range w.files {
+		if err := f.flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close flushes and closes all files
+func (w *Avro) Close() error {
+	var firstErr error
+	for name, f := range w.files {
+		if err := f.close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		delete(w.files, name)
+	}
+	return firstErr
+}
+
+// tableFile returns the file of the table for the columns, a file of a new
+// schema version is started if the schema differs from the current one
+func (w *Avro) tableFile(header parser.MessageHeader, columns []avroColumn) (*avroFile, error) {
+	name := header.Schema + "." + header.Table
+	schema, err := json.Marshal(avroEnvelopeSchema(header, columns))
+	if err != nil {
+		return nil, err
+	}
+
+	version := 1
+	if f, ok := w.files[name]; ok {
+		if f.schema == string(schema) {
+			return f, nil
+		}
+		version = f.version + 1
+		delete(w.files, name)
+		if err := f.close(); err != nil {
+			return nil, err
+		}
+	}
+
+	file, err := os.Create(filepath.Join(w.Dir, fmt.Sprintf("%s.v%d.avro", name, version)))
+	if err != nil {
+		return nil, err
+	}
+	codec := w.Codec
+	if codec == "" {
+		codec = AvroCodecNull
+	}
+	f := &avroFile{file: file, codec: codec, version: version, schema: string(schema), columns: columns}
+	w.files[name] = f
+	if _, err := rand.Read(f.sync[:]); err != nil {
+		return nil, err
+	}
+	return f, f.writeHeader()
+}
+
+func (f *avroFile) writeHeader() error {
+	var buf bytes.Buffer
+	buf.Write(avroMagic)
+	writeAvroLong(&buf, 2)
+	writeAvroString(&buf, "avro.schema")
+	writeAvroBytes(&buf, []byte(f.schema))
+	writeAvroString(&buf, "avro.codec")
+	writeAvroBytes(&buf, []byte(f.codec))
+	writeAvroLong(&buf, 0)
+	buf.Write(f.sync[:])
+	_, err := f.file.Write(buf.Bytes())
+	return err
+}
+
+// writeRecord encodes the change record into the current block
+func (f *avroFile) writeRecord(header parser.MessageHeader, op string, before, after *parser.MessageRowData) error {
+	writeAvroString(&f.block, op)
+	for _, data := range []*parser.MessageRowData{before, after} {
+		if data == nil {
+			writeAvroLong(&f.block, 0)
+			continue
+		}
+		writeAvroLong(&f.block, 1)
+		for _, column := range f.columns {
+			if err := column.encode(&f.block, data.Row[column.column.Name]); err != nil {
+				return fmt.Errorf("can not encode %s.%s.%s as avro: %s", header.Schema, header.Table, column.column.Name, err)
+			}
+		}
+	}
+	writeAvroSource(&f.block, header)
+	f.count++
+	return nil
+}
+
+// flush writes the buffered records as a block
+func (f *avroFile) flush() error {
+	if f.count == 0 {
+		return nil
+	}
+	data := f.block.Bytes()
+	if f.codec == AvroCodecDeflate {
+		var compressed bytes.Buffer
+		writer, err := flate.NewWriter(&compressed, flate.DefaultCompression)
+		if err != nil {
+			return err
+		}
+		if _, err := writer.Write(data); err != nil {
+			return err
+		}
+		if err := writer.Close(); err != nil {
+			return err
+		}
+		data = compressed.Bytes()
+	}
+
+	var buf bytes.Buffer
+	writeAvroLong(&buf, f.count)
+	writeAvroBytes(&buf, data)
+	buf.Write(f.sync[:])
+	if _, err := f.file.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	f.block.Reset()
+	f.count = 0
+	return nil
+}
+
+func (f *avroFile) close() error {
+	err := f.flush()
+	if closeErr := f.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// avroColumns derives the row record fields from the table columns. Rows that
+// could not be mapped to the columns get nullable string fields in sorted
+// order.
+func avroColumns(header parser.MessageHeader, rows ...*parser.MessageRowData) []avroColumn {
+	var data []parser.MessageRowData
+	for _, row := range rows {
+		if row != nil {
+			data = append(data, *row)
+		}
+	}
+	mapped := len(header.Columns) > 0
+	for _, row := range data {
+		if row.MappingNotice != "" {
+			mapped = false
+		}
+	}
+
+	var columns []avroColumn
+	if !mapped {
+		for _, field := range rowFieldsSchema(nil, data) {
+			columns = append(columns, avroColumn{
+				column:   database.Column{Name: field.Field},
+				name:     avroName(field.Field),
+				kind:     kindString,
+				nullable: true,
+			})
+		}
+		return columns
+	}
+	for _, column := range header.Columns {
+		columns = append(columns, newAvroColumn(column))
+	}
+	return columns
+}
+
+func newAvroColumn(column database.Column) avroColumn {
+	return avroColumn{column: column, name: avroName(column.Name), kind: columnKind(column), nullable: column.Nullable}
+}
+
+type avroRecordSchema struct {
+	Type      string            `json:"type"`
+	Name      string            `json:"name"`
+	Namespace string            `json:"namespace,omitempty"`
+	Fields    []avroFieldSchema `json:"fields"`
+}
+
+type avroFieldSchema struct {
+	Name    string          `json:"name"`
+	Type    interface{}     `json:"type"`
+	Default json.RawMessage `json:"default,omitempty"`
+}
+
+type avroLogicalSchema struct {
+	Type        string `json:"type"`
+	LogicalType string `json:"logicalType"`
+	Precision   int    `json:"precision,omitempty"`
+	Scale       int    `json:"scale,omitempty"`
+}
+
+var avroNullDefault = json.RawMessage("null")
+
+func avroEnvelopeSchema(header parser.MessageHeader, columns []avroColumn) avroRecordSchema {
+	value := avroRecordSchema{Type: "record", Name: "Value"}
+	for _, column := range columns {
+		value.Fields = append(value.Fields, column.schema())
+	}
+	optionalString := func(name string) avroFieldSchema {
+		return avroFieldSchema{Name: name, Type: []interface{}{"null", "string"}, Default: avroNullDefault}
+	}
+	return avroRecordSchema{
+		Type:      "record",
+		Name:      "Envelope",
+		Namespace: avroName(header.Schema) + "." + avroName(header.Table),
+		Fields: []avroFieldSchema{
+			{Name: "op", Type: "string"},
+			{Name: "before", Type: []interface{}{"null", value}, Default: avroNullDefault},
+			{Name: "after", Type: []interface{}{"null", "Value"}, Default: avroNullDefault},
+			{Name: "source", Type: avroRecordSchema{
+				Type: "record",
+				Name: "Source",
+				Fields: []avroFieldSchema{
+					{Name: "db", Type: "string"},
+					{Name: "table", Type: "string"},
+					{Name: "server_id", Type: "long"},
+					optionalString("gtid"),
+					{Name: "file", Type: "string"},
+					{Name: "pos", Type: "long"},
+					{Name: "row", Type: "int"},
+					{Name: "xid", Type: "long"},
+					{Name: "ts_ms", Type: "long"},
+					optionalString("query"),
+				},
+			}},
+		},
+	}
+}
+
+func (c avroColumn) schema() avroFieldSchema {
+	var t interface{}
+	switch c.kind {
+	case kindInt32:
+		t = "int"
+	case kindInt64:
+		t = "long"
+	case kindUint64:
+		t = avroLogicalSchema{Type: "bytes", LogicalType: "decimal", Precision: 20}
+	case kindFloat:
+		t = "float"
+	case kindDouble:
+		t = "double"
+	case kindDecimal:
+		t = avroLogicalSchema{Type: "bytes", LogicalType: "decimal", Precision: decimalPrecision(c.column), Scale: c.column.Scale}
+	case kindDate:
+		t = avroLogicalSchema{Type: "int", LogicalType: "date"}
+	case kindTimestamp:
+		t = avroLogicalSchema{Type: "long", LogicalType: "timestamp-micros"}
+	case kindTime:
+		t = avroLogicalSchema{Type: "long", LogicalType: "time-micros"}
+	case kindBytes:
+		t = "bytes"
+	default:
+		t = "string"
+	}
+	if !c.nullable {
+		return avroFieldSchema{Name: c.name, Type: t}
+	}
+	return avroFieldSchema{Name: c.name, Type: []interface{}{"null", t}, Default: avroNullDefault}
+}
+
+// encode writes the binlog value of the column, nullable columns are encoded
+// as union of null and the column type
+func (c avroColumn) encode(buf *bytes.Buffer, value interface{}) error {
+	datum, err := typedValue(c.column, c.kind, value)
+	if err != nil {
+		return err
+	}
+	if datum == nil && c.nullable {
+		writeAvroLong(buf, 0)
+		return nil
+	}
+	if datum == nil {
+		switch c.kind {
+		case kindDate, kindTimestamp, kindTime:
+			// zero dates can't be represented, like Debezium they become the
+			// epoch in columns that are not nullable
+			datum = int64(0)
+		default:
+			return fmt.Errorf("null value in not nullable column")
+		}
+	}
+	if c.nullable {
+		writeAvroLong(buf, 1)
+	}
+
+	switch v := datum.(type) {
+	case int64:
+		writeAvroLong(buf, v)
+	case uint64:
+		writeAvroBytes(buf, twosComplement(new(big.Int).SetUint64(v)))
+	case *big.Int:
+		writeAvroBytes(buf, twosComplement(v))
+	case float32:
+		binary.Write(buf, binary.LittleEndian, math.Float32bits(v))
+	case float64:
+		binary.Write(buf, binary.LittleEndian, math.Float64bits(v))
+	case []byte:
+		writeAvroBytes(buf, v)
+	case string:
+		writeAvroString(buf, v)
+	}
+	return nil
+}
+
+func writeAvroSource(buf *bytes.Buffer, header parser.MessageHeader) {
+	writeAvroString(buf, header.Schema)
+	writeAvroString(buf, header.Table)
+	event := parser.EventMetadata{StartPosition: header.BinlogPosition}
+	if header.Event != nil {
+		event = *header.Event
+	}
+	writeAvroLong(buf, int64(event.ServerID))
+	writeAvroOptionalString(buf, event.GTID)
+	writeAvroString(buf, event.BinlogFile)
+	writeAvroLong(buf, int64(event.StartPosition))
+	writeAvroLong(buf, int64(event.RowIndex))
+	writeAvroLong(buf, int64(header.XID))
+	var tsMs int64
+	if t := messageTime(header); !t.IsZero() {
+		tsMs = t.Unix() * 1000
+	}
+	writeAvroLong(buf, tsMs)
+	writeAvroOptionalString(buf, string(header.RowsQuery))
+}
+
+// writeAvroLong writes a zig-zag encoded variable length integer, int and
+// long share the encoding
+func writeAvroLong(buf *bytes.Buffer, n int64) {
+	var b [binary.MaxVarintLen64]byte
+	buf.Write(b[:binary.PutVarint(b[:], n)])
+}
+
+func writeAvroBytes(buf *bytes.Buffer, b []byte) {
+	writeAvroLong(buf, int64(len(b)))
+	buf.Write(b)
+}
+
+func writeAvroString(buf *bytes.Buffer, s string) {
+	writeAvroLong(buf, int64(len(s)))
+	buf.WriteString(s)
+}
+
+// writeAvroOptionalString writes a union of null and string, empty strings
+// are written as null
+func writeAvroOptionalString(buf *bytes.Buffer, s string) {
+	if s == "" {
+		writeAvroLong(buf, 0)
+		return
+	}
+	writeAvroLong(buf, 1)
+	writeAvroString(buf, s)
+}
+
+var invalidAvroNameChars = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// avroName replaces the characters that are not allowed in Avro names
+func avroName(name string) string {
+	name = invalidAvroNameChars.ReplaceAllString(name, "_")
+	if name == "" || (name[0] >= '0' && name[0] <= '9') {
+		name = "_" + name
+	}
+	return name
+}
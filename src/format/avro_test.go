@@ -0,0 +1,184 @@
+package format
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/tanema/binlog-parser/src/database"
+	"github.com/tanema/binlog-parser/src/parser"
+)
+
+func TestAvroColumnEncode(t *testing.T) {
+	testCases := []struct {
+		column   database.Column
+		value    interface{}
+		expected []byte
+	}{
+		{database.Column{DataType: "int", ColumnType: "int(11)"}, int32(1), []byte{0x02}},
+		{database.Column{DataType: "int", ColumnType: "int(10) unsigned"}, int32(-1), []byte{0xfe, 0xff, 0xff, 0xff, 0x1f}},
+		{database.Column{DataType: "varchar", Nullable: true}, nil, []byte{0x00}},
+		{database.Column{DataType: "varchar", Nullable: true}, "ab", []byte{0x02, 0x04, 'a', 'b'}},
+		{database.Column{DataType: "enum", ColumnType: "enum('M','F')"}, int64(2), []byte{0x02, 'F'}},
+		{database.Column{DataType: "decimal", Precision: 5, Scale: 2}, float64(123.45), []byte{0x04, 0x30, 0x39}},
+		{database.Column{DataType: "decimal", Precision: 5, Scale: 2}, float64(-1.5), []byte{0x04, 0xff, 0x6a}},
+		{database.Column{DataType: "decimal", Precision: 5, Scale: 2}, "1.005", []byte{0x02, 0x65}},
+		{database.Column{DataType: "float"}, float32(1.5), []byte{0x00, 0x00, 0xc0, 0x3f}},
+		{database.Column{DataType: "date"}, "1970-01-02", []byte{0x02}},
+		{database.Column{DataType: "datetime"}, "1970-01-01 00:00:01.5", []byte{0xc0, 0x8d, 0xb7, 0x01}},
+		{database.Column{DataType: "datetime"}, "0000-00-00 00:00:00", []byte{0x00}},
+		{database.Column{DataType: "datetime", Nullable: true}, "0000-00-00 00:00:00", []byte{0x00}},
+		{database.Column{DataType: "blob"}, "\xca\xfe", []byte{0x04, 0xca, 0xfe}},
+	}
+
+	for _, tc := range testCases {
+		var buf bytes.Buffer
+		if err := newAvroColumn(tc.column).encode(&buf, tc.value); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf.Bytes(), tc.expected) {
+			t.Fatal(fmt.Sprintf("Wrong encoding of %v as %s, expected %x got %x", tc.value, tc.column.DataType, tc.expected, buf.Bytes()))
+		}
+	}
+
+	if err := newAvroColumn(database.Column{DataType: "int"}).encode(&bytes.Buffer{}, nil); err == nil {
+		t.Fatal("Expected error for null value in not nullable column")
+	}
+}
+
+func TestAvroSchema(t *testing.T) {
+	header := parser.NewMessageHeader("test_db", "employees", time.Unix(1492070524, 0), 635, 8)
+	header.Columns = []database.Column{
+		{Name: "emp_no", DataType: "int", ColumnType: "int(11)", PrimaryKey: true},
+		{Name: "salary", DataType: "decimal", ColumnType: "decimal(10,2)", Precision: 10, Scale: 2, Nullable: true},
+		{Name: "hired-at", DataType: "timestamp", ColumnType: "timestamp"},
+	}
+	data := parser.MessageRowData{Row: parser.MessageRow{"emp_no": int32(1)}}
+
+	schema, _ := json.Marshal(avroEnvelopeSchema(header, avroColumns(header, &data)))
+	var envelope struct {
+		Namespace string
+		Fields    []struct {
+			Name string
+			Type json.RawMessage
+		}
+	}
+	json.Unmarshal(schema, &envelope)
+
+	expectedValue := `["null",{"type":"record","name":"Value","fields":[` +
+		`{"name":"emp_no","type":"int"},` +
+		`{"name":"salary","type":["null",{"type":"bytes","logicalType":"decimal","precision":10,"scale":2}],"default":null},` +
+		`{"name":"hired_at","type":{"type":"long","logicalType":"timestamp-micros"}}]}]`
+	if envelope.Namespace != "test_db.employees" || len(envelope.Fields) != 4 || string(envelope.Fields[1].Type) != expectedValue {
+		t.Fatal(fmt.Sprintf("Wrong schema %s", schema))
+	}
+
+	data.MappingNotice = "column names array is missing field(s), will map them as unknown_*"
+	schema, _ = json.Marshal(avroEnvelopeSchema(header, avroColumns(header, &data)))
+	json.Unmarshal(schema, &envelope)
+	expectedValue = `["null",{"type":"record","name":"Value","fields":[{"name":"emp_no","type":["null","string"],"default":null}]}]`
+	if string(envelope.Fields[1].Type) != expectedValue {
+		t.Fatal(fmt.Sprintf("Wrong schema for unmapped row %s", schema))
+	}
+}
+
+func TestAvroWriter(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "avro")
+	defer os.RemoveAll(dir)
+
+	header := parser.NewMessageHeader("test_db", "employees", time.Unix(1492070524, 0), 635, 8)
+	header.Columns = []database.Column{{Name: "emp_no", DataType: "int", ColumnType: "int(11)"}}
+	altered := header
+	altered.Columns = append(header.Columns, database.Column{Name: "first_name", DataType: "varchar", Nullable: true})
+	data := parser.MessageRowData{Row: parser.MessageRow{"emp_no": int32(1), "first_name": "Max"}}
+
+	w := NewAvro(dir)
+	w.Codec = AvroCodecNull
+	messages := []parser.Message{
+		parser.NewInsertMessage(header, data),
+		parser.NewDeleteMessage(header, data),
+		parser.NewQueryMessage(header, "ALTER TABLE employees ADD first_name varchar(14)"),
+		parser.NewUpdateMessage(altered, data, data),
+	}
+	for _, message := range messages {
+		if output, err := w.Format(message); err != nil || output != nil {
+			t.Fatal(fmt.Sprintf("Unexpected output %s, %v", output, err))
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		file    string
+		fields  int
+		records int64
+		op      byte
+	}{
+		{"test_db.employees.v1.avro", 1, 2, 'c'},
+		{"test_db.employees.v2.avro", 2, 1, 'u'},
+	}
+	for _, tc := range testCases {
+		content, err := ioutil.ReadFile(filepath.Join(dir, tc.file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		schema, records, block := readAvroFile(t, content)
+		if records != tc.records {
+			t.Fatal(fmt.Sprintf("Expected %d records in %s, got %d", tc.records, tc.file, records))
+		}
+		if !bytes.Contains(schema, []byte(`"name":"Value","fields":[{"name":"emp_no","type":"int"}`)) ||
+			bytes.Count(schema, []byte(`"name":"first_name"`)) != tc.fields-1 {
+			t.Fatal(fmt.Sprintf("Wrong schema in %s: %s", tc.file, schema))
+		}
+		if block[0] != 0x02 || block[1] != tc.op {
+			t.Fatal(fmt.Sprintf("Wrong first record in %s: %x", tc.file, block))
+		}
+	}
+}
+
+// readAvroFile reads the schema and the single block of an uncompressed
+// object container file
+func readAvroFile(t *testing.T, content []byte) ([]byte, int64, []byte) {
+	r := bytes.NewReader(content)
+	magic := make([]byte, 4)
+	io.ReadFull(r, magic)
+	if !bytes.Equal(magic, avroMagic) {
+		t.Fatal(fmt.Sprintf("Wrong magic %x", magic))
+	}
+	readBytes := func() []byte {
+		n, _ := binary.ReadVarint(r)
+		b := make([]byte, n)
+		io.ReadFull(r, b)
+		return b
+	}
+
+	meta := map[string]string{}
+	for n, _ := binary.ReadVarint(r); n > 0; n, _ = binary.ReadVarint(r) {
+		for i := int64(0); i < n; i++ {
+			key := readBytes()
+			meta[string(key)] = string(readBytes())
+		}
+	}
+	if meta["avro.codec"] != AvroCodecNull {
+		t.Fatal(fmt.Sprintf("Wrong codec %q", meta["avro.codec"]))
+	}
+	sync := make([]byte, 16)
+	io.ReadFull(r, sync)
+
+	records, _ := binary.ReadVarint(r)
+	block := readBytes()
+	blockSync := make([]byte, 16)
+	io.ReadFull(r, blockSync)
+	if !bytes.Equal(sync, blockSync) || r.Len() != 0 {
+		t.Fatal("Expected a single block ending with the sync marker")
+	}
+	return []byte(meta["avro.schema"]), records, block
+}
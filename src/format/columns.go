@@ -0,0 +1,384 @@
+package format
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tanema/binlog-parser/src/database"
+	"github.com/tanema/binlog-parser/src/parser"
+)
+
+// orderedRow is a row that marshals its columns in table order instead of
+// the sorted key order of a map
+type orderedRow struct {
+	names  []string
+	values map[string]interface{}
+}
+
+// newOrderedRow orders the row by the table columns, names that are not
+// columns of the table follow in sorted order. Values of known columns are
+// passed through convert.
+func newOrderedRow(columns []database.Column, row parser.MessageRow, convert func(database.Column, interface{}) interface{}) orderedRow {
+	ordered := orderedRow{values: make(map[string]interface{}, len(row))}
+	for _, column := range columns {
+		if value, ok := row[column.Name]; ok {
+			ordered.names = append(ordered.names, column.Name)
+			ordered.values[column.Name] = convert(column, value)
+		}
+	}
+	var unknown []string
+	for name, value := range row {
+		if _, ok := ordered.values[name]; !ok {
+			unknown = append(unknown, name)
+			ordered.values[name] = value
+		}
+	}
+	sort.Strings(unknown)
+	ordered.names = append(ordered.names, unknown...)
+	return ordered
+}
+
+func (r orderedRow) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, name := range r.names {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, err := json.Marshal(name)
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(r.values[name])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// lookupColumn finds the column with the given name in the table columns
+func lookupColumn(columns []database.Column, name string) (database.Column, bool) {
+	for _, column := range columns {
+		if column.Name == name {
+			return column, true
+		}
+	}
+	return database.Column{}, false
+}
+
+// NormalizeValue corrects the raw binlog value with the column type: the binlog
+// has no signedness so unsigned integers are reinterpreted, and enum and set
+// values are stored as index and bitmask so they are mapped to their labels.
+func NormalizeValue(column database.Column, value interface{}) interface{} {
+	if value == nil {
+		return nil
+	}
+	switch column.DataType {
+	case "tinyint", "smallint", "mediumint", "int", "integer", "bigint":
+		if column.Unsigned() {
+			return toUnsigned(column.DataType, value)
+		}
+	case "enum":
+		if index, ok := toInt64(value); ok {
+			labels := enumLabels(column.ColumnType)
+			if index > 0 && int(index) <= len(labels) {
+				return labels[index-1]
+			}
+			return ""
+		}
+	case "set":
+		if bitmask, ok := toInt64(value); ok {
+			var selected []string
+			for i, label := range enumLabels(column.ColumnType) {
+				if bitmask&(1<<uint(i)) != 0 {
+					selected = append(selected, label)
+				}
+			}
+			return strings.Join(selected, ",")
+		}
+	}
+	return value
+}
+
+// IsBinaryType tells if the values of the column type are bytes rather than
+// text, the binlog has both as []byte
+func IsBinaryType(dataType string) bool {
+	switch dataType {
+	case "binary", "varbinary", "tinyblob", "blob", "mediumblob", "longblob", "geometry", "point", "linestring", "polygon":
+		return true
+	}
+	return false
+}
+
+func toUnsigned(dataType string, value interface{}) interface{} {
+	switch v := value.(type) {
+	case int8:
+		return uint64(uint8(v))
+	case int16:
+		return uint64(uint16(v))
+	case int32:
+		if dataType == "mediumint" {
+			return uint64(uint32(v) & 0xFFFFFF)
+		}
+		return uint64(uint32(v))
+	case int64:
+		return uint64(v)
+	}
+	return value
+}
+
+func toInt64(value interface{}) (int64, bool) {
+	switch v := value.(type) {
+	case int8:
+		return int64(v), true
+	case int16:
+		return int64(v), true
+	case int32:
+		return int64(v), true
+	case int64:
+		return v, true
+	case int:
+		return int64(v), true
+	case uint64:
+		return int64(v), true
+	}
+	return 0, false
+}
+
+// enumLabels parses the labels of an enum('a','b') or set('a','b') column type
+func enumLabels(columnType string) []string {
+	start := strings.IndexByte(columnType, '(')
+	end := strings.LastIndexByte(columnType, ')')
+	if start < 0 || end < start {
+		return nil
+	}
+	var labels []string
+	var label strings.Builder
+	inQuote := false
+	list := columnType[start+1 : end]
+	for i := 0; i < len(list); i++ {
+		c := list[i]
+		switch {
+		case c == '\'' && inQuote && i+1 < len(list) && list[i+1] == '\'':
+			label.WriteByte(c)
+			i++
+		case c == '\'':
+			inQuote = !inQuote
+			if !inQuote {
+				labels = append(labels, label.String())
+				label.Reset()
+			}
+		case inQuote:
+			label.WriteByte(c)
+		}
+	}
+	return labels
+}
+
+// valueKind is the type a column's values are converted to for the binary
+// output formats
+type valueKind int
+
+const (
+	kindInt32 valueKind = iota
+	kindInt64
+	kindUint64
+	kindFloat
+	kindDouble
+	kindDecimal
+	kindDate
+	kindTimestamp
+	kindTime
+	kindBytes
+	kindString
+)
+
+// columnKind maps the MySQL column type to the kind of its values
+func columnKind(column database.Column) valueKind {
+	switch column.DataType {
+	case "tinyint", "smallint", "mediumint", "year":
+		return kindInt32
+	case "int", "integer":
+		if column.Unsigned() {
+			return kindInt64
+		}
+		return kindInt32
+	case "bigint":
+		if column.Unsigned() {
+			return kindUint64
+		}
+		return kindInt64
+	case "bit":
+		return kindInt64
+	case "float":
+		return kindFloat
+	case "double", "real":
+		return kindDouble
+	case "decimal", "numeric":
+		return kindDecimal
+	case "date":
+		return kindDate
+	case "datetime", "timestamp":
+		return kindTimestamp
+	case "time":
+		return kindTime
+	case "binary", "varbinary", "tinyblob", "blob", "mediumblob", "longblob", "geometry", "point", "linestring", "polygon":
+		return kindBytes
+	}
+	return kindString
+}
+
+// decimalPrecision is the precision of a decimal column, the MySQL maximum if
+// it is unknown
+func decimalPrecision(column database.Column) int {
+	if column.Precision == 0 {
+		return 65
+	}
+	return column.Precision
+}
+
+// typedValue converts the binlog value of the column to the Go type of the
+// kind: int64 for the integer, date (days), timestamp and time (microseconds)
+// kinds, uint64, float32, float64, the unscaled *big.Int of decimals, []byte
+// or string. nil is returned for NULL and for temporal values that can't be
+// represented, like zero dates.
+func typedValue(column database.Column, kind valueKind, value interface{}) (interface{}, error) {
+	value = NormalizeValue(column, value)
+	if value == nil {
+		return nil, nil
+	}
+
+	switch kind {
+	case kindInt32, kindInt64:
+		switch v := value.(type) {
+		case uint64:
+			return int64(v), nil
+		case float32:
+			return int64(v), nil
+		case float64:
+			return int64(v), nil
+		case string:
+			return strconv.ParseInt(v, 10, 64)
+		}
+		if i, ok := toInt64(value); ok {
+			return i, nil
+		}
+	case kindUint64:
+		switch v := value.(type) {
+		case uint64:
+			return v, nil
+		case string:
+			return strconv.ParseUint(v, 10, 64)
+		}
+		if i, ok := toInt64(value); ok {
+			return uint64(i), nil
+		}
+	case kindFloat:
+		switch v := value.(type) {
+		case float32:
+			return v, nil
+		case float64:
+			return float32(v), nil
+		}
+	case kindDouble:
+		switch v := value.(type) {
+		case float32:
+			return float64(v), nil
+		case float64:
+			return v, nil
+		}
+	case kindDecimal:
+		return unscaledDecimal(value, column.Scale)
+	case kindDate:
+		t, err := time.Parse("2006-01-02", fmt.Sprint(value))
+		if err != nil {
+			return nil, nil
+		}
+		return t.Unix() / 86400, nil
+	case kindTimestamp:
+		t, ok := value.(time.Time)
+		if !ok {
+			var err error
+			if t, err = parseDateTime(fmt.Sprint(value)); err != nil {
+				return nil, nil
+			}
+		}
+		return t.Unix()*1000000 + int64(t.Nanosecond()/1000), nil
+	case kindTime:
+		if micros, ok := parseTimeMicros(fmt.Sprint(value)); ok {
+			return micros, nil
+		}
+		return nil, nil
+	case kindBytes:
+		switch v := value.(type) {
+		case []byte:
+			return v, nil
+		case string:
+			return []byte(v), nil
+		}
+	case kindString:
+		if b, ok := value.([]byte); ok {
+			return string(b), nil
+		}
+		return fmt.Sprint(value), nil
+	}
+	return nil, fmt.Errorf("unexpected %T value", value)
+}
+
+// decimalString formats a decimal value with the scale of its column, the
+// float64 the binlog decodes decimals to would print large values with an
+// exponent
+func decimalString(value interface{}, scale int) string {
+	switch v := value.(type) {
+	case float32:
+		return strconv.FormatFloat(float64(v), 'f', scale, 32)
+	case float64:
+		return strconv.FormatFloat(v, 'f', scale, 64)
+	case []byte:
+		return string(v)
+	}
+	return fmt.Sprint(value)
+}
+
+// unscaledDecimal returns the value multiplied by 10^scale, rounded half away
+// from zero
+func unscaledDecimal(value interface{}, scale int) (*big.Int, error) {
+	s := decimalString(value, scale)
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return nil, fmt.Errorf("invalid decimal %q", s)
+	}
+	r.Mul(r, new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(scale)), nil)))
+
+	quotient, remainder := new(big.Int).QuoRem(r.Num(), r.Denom(), new(big.Int))
+	if remainder.Sign() != 0 && new(big.Int).Mul(new(big.Int).Abs(remainder), big.NewInt(2)).Cmp(r.Denom()) >= 0 {
+		quotient.Add(quotient, big.NewInt(int64(r.Sign())))
+	}
+	return quotient, nil
+}
+
+// twosComplement encodes the integer as big-endian two's complement in the
+// fewest bytes
+func twosComplement(n *big.Int) []byte {
+	if n.Sign() >= 0 {
+		b := n.Bytes()
+		if len(b) == 0 || b[0]&0x80 != 0 {
+			b = append([]byte{0}, b...)
+		}
+		return b
+	}
+	size := (new(big.Int).Sub(new(big.Int).Neg(n), big.NewInt(1)).BitLen() + 8) / 8
+	b := new(big.Int).Add(n, new(big.Int).Lsh(big.NewInt(1), uint(size*8))).Bytes()
+	return append(bytes.Repeat([]byte{0xff}, size-len(b)), b...)
+}
@@ -0,0 +1,35 @@
+package format
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/tanema/binlog-parser/src/database"
+)
+
+func TestNormalizeValue(t *testing.T) {
+	testCases := []struct {
+		column   database.Column
+		value    interface{}
+		expected interface{}
+	}{
+		{database.Column{DataType: "int", ColumnType: "int(10) unsigned"}, int32(-1), uint64(4294967295)},
+		{database.Column{DataType: "tinyint", ColumnType: "tinyint(3) unsigned"}, int8(-56), uint64(200)},
+		{database.Column{DataType: "mediumint", ColumnType: "mediumint(8) unsigned"}, int32(-1), uint64(16777215)},
+		{database.Column{DataType: "int", ColumnType: "int(11)"}, int32(-1), int32(-1)},
+		{database.Column{DataType: "enum", ColumnType: "enum('small','it''s big')"}, int64(2), "it's big"},
+		{database.Column{DataType: "enum", ColumnType: "enum('small','big')"}, int64(0), ""},
+		{database.Column{DataType: "set", ColumnType: "set('a','b','c')"}, int64(5), "a,c"},
+		{database.Column{DataType: "varchar", ColumnType: "varchar(10)"}, "value", "value"},
+		{database.Column{DataType: "int", ColumnType: "int(10) unsigned"}, nil, nil},
+	}
+
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("%s %v", tc.column.ColumnType, tc.value), func(t *testing.T) {
+			if actual := NormalizeValue(tc.column, tc.value); !reflect.DeepEqual(actual, tc.expected) {
+				t.Fatal(fmt.Sprintf("Expected %#v, got %#v", tc.expected, actual))
+			}
+		})
+	}
+}
@@ -0,0 +1,219 @@
+package format
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/tanema/binlog-parser/src/database"
+	"github.com/tanema/binlog-parser/src/parser"
+)
+
+// CSVNull is written for NULL values, an empty string is written as ""
+const CSVNull = `\N`
+
+var csvMetaColumns = []string{"_op", "_binlog_time", "_binlog_position", "_xid"}
+
+// CSV writes row messages into one delimited file per table in a directory,
+// named <schema>.<table>.csv (or .tsv). Every file starts with a header of the
+// meta columns _op, _binlog_time, _binlog_position and _xid followed by the
+// table columns in table order. Binary values are hex encoded. Query messages
+// are skipped.
+type CSV struct {
+	Dir       string
+	Delimiter byte
+	// UpdatePairs writes updates as an update_before and update_after row,
+	// otherwise only the new row is written as update
+	UpdatePairs bool
+	files       map[string]*csvFile
+}
+
+type csvFile struct {
+	file    *os.File
+	writer  *bufio.Writer
+	columns []database.Column
+}
+
+// NewCSV creates a comma separated writer into the directory
+func NewCSV(dir string) *CSV {
+	return &CSV{Dir: dir, Delimiter: ',', UpdatePairs: true, files: map[string]*csvFile{}}
+}
+
+// NewTSV creates a tab separated writer into the directory
+func NewTSV(dir string) *CSV {
+	w := NewCSV(dir)
+	w.Delimiter = '\t'
+	return w
+}
+
+// Format writes the rows of the message to the file of its table, it never
+// returns output of its own
+func (w *CSV) Format(message parser.Message) ([]byte, error) {
+	header := message.GetHeader()
+	switch m := message.(type) {
+	case parser.InsertMessage:
+		return nil, w.writeRow(header, "insert", m.Data)
+	case parser.UpdateMessage:
+		if !w.UpdatePairs {
+			return nil, w.writeRow(header, "update", m.NewData)
+		}
+		if err := w.writeRow(header, "update_before", m.OldData); err != nil {
+			return nil, err
+		}
+		return nil, w.writeRow(header, "update_after", m.NewData)
+	case parser.DeleteMessage:
+		return nil, w.writeRow(header, "delete", m.Data)
+	}
+	return nil, nil
+}
+
+// Commit flushes the files at the end of each transaction
+func (w *CSV) Commit(xid uint64) ([]byte, error) {
+	return nil, w.Flush()
+}
+
+// Flush writes the buffered rows of all files
+func (w *CSV) Flush() error {
+	for _, f := range w.files {
+		if err := f.writer.Flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close flushes and closes all files
+func (w *CSV) Close() error {
+	var firstErr error
+	for name, f := range w.files {
+		if err := f.writer.Flush(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		if err := f.file.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		delete(w.files, name)
+	}
+	return firstErr
+}
+
+func (w *CSV) writeRow(header parser.MessageHeader, op string, data parser.MessageRowData) error {
+	f, err := w.tableFile(header, data)
+	if err != nil {
+		return err
+	}
+	cells := textCells(op, header.BinlogMessageTime, strconv.FormatUint(uint64(header.BinlogPosition), 10), strconv.FormatUint(header.XID, 10))
+	for _, column := range f.columns {
+		cells = append(cells, csvValue(column, data.Row[column.Name]))
+	}
+	return w.writeLine(f.writer, cells)
+}
+
+func (w *CSV) tableFile(header parser.MessageHeader, data parser.MessageRowData) (*csvFile, error) {
+	name := header.Schema + "." + header.Table
+	if f, ok := w.files[name]; ok {
+		return f, nil
+	}
+
+	extension := ".csv"
+	if w.Delimiter == '\t' {
+		extension = ".tsv"
+	}
+	file, err := os.Create(filepath.Join(w.Dir, name+extension))
+	if err != nil {
+		return nil, err
+	}
+	f := &csvFile{file: file, writer: bufio.NewWriter(file), columns: csvColumns(header, data)}
+	w.files[name] = f
+
+	cells := textCells(csvMetaColumns...)
+	for _, column := range f.columns {
+		cells = append(cells, csvCell{value: column.Name})
+	}
+	return f, w.writeLine(f.writer, cells)
+}
+
+// csvColumns are the table columns, or the sorted row names if the row could
+// not be mapped to them
+func csvColumns(header parser.MessageHeader, data parser.MessageRowData) []database.Column {
+	if len(header.Columns) > 0 && data.MappingNotice == "" {
+		return header.Columns
+	}
+	names := make([]string, 0, len(data.Row))
+	for name := range data.Row {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	columns := make([]database.Column, len(names))
+	for i, name := range names {
+		columns[i] = database.Column{Name: name}
+	}
+	return columns
+}
+
+type csvCell struct {
+	value string
+	null  bool
+}
+
+func textCells(values ...string) []csvCell {
+	cells := make([]csvCell, len(values))
+	for i, value := range values {
+		cells[i] = csvCell{value: value}
+	}
+	return cells
+}
+
+func (w *CSV) writeLine(writer *bufio.Writer, cells []csvCell) error {
+	for i, cell := range cells {
+		if i > 0 {
+			writer.WriteByte(w.Delimiter)
+		}
+		if cell.null {
+			writer.WriteString(CSVNull)
+		} else {
+			writer.WriteString(w.quote(cell.value))
+		}
+	}
+	_, err := writer.WriteString("\n")
+	return err
+}
+
+// quote quotes fields that would otherwise be ambiguous: empty strings, the
+// NULL marker and fields containing the delimiter, quotes or line breaks
+func (w *CSV) quote(field string) string {
+	if field != "" && field != CSVNull && !strings.ContainsAny(field, string(w.Delimiter)+"\"\r\n") {
+		return field
+	}
+	return `"` + strings.Replace(field, `"`, `""`, -1) + `"`
+}
+
+// csvValue renders a binlog value for the column, binary columns and bytes of
+// columns of unknown type are hex encoded, text and json columns are text
+func csvValue(column database.Column, value interface{}) csvCell {
+	value = NormalizeValue(column, value)
+	if b, ok := value.([]byte); ok && column.DataType != "" && !IsBinaryType(column.DataType) {
+		value = string(b)
+	}
+	switch v := value.(type) {
+	case nil:
+		return csvCell{null: true}
+	case []byte:
+		return csvCell{value: hex.EncodeToString(v)}
+	case string:
+		if IsBinaryType(column.DataType) {
+			return csvCell{value: hex.EncodeToString([]byte(v))}
+		}
+		return csvCell{value: v}
+	case float32:
+		return csvCell{value: strconv.FormatFloat(float64(v), 'g', -1, 32)}
+	case float64:
+		return csvCell{value: strconv.FormatFloat(v, 'g', -1, 64)}
+	}
+	return csvCell{value: fmt.Sprint(value)}
+}
@@ -0,0 +1,102 @@
+package format
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/tanema/binlog-parser/src/database"
+	"github.com/tanema/binlog-parser/src/parser"
+)
+
+func TestCSVWriter(t *testing.T) {
+	header := parser.NewMessageHeader("test_db", "employees", time.Unix(1492070524, 0), 635, 8)
+	header.Columns = []database.Column{
+		{Name: "emp_no", DataType: "int", ColumnType: "int(11)"},
+		{Name: "first_name", DataType: "varchar", ColumnType: "varchar(14)"},
+		{Name: "photo", DataType: "blob", ColumnType: "blob"},
+	}
+	oldData := parser.MessageRowData{Row: parser.MessageRow{"emp_no": int32(1), "first_name": "", "photo": nil}}
+	newData := parser.MessageRowData{Row: parser.MessageRow{"emp_no": int32(1), "first_name": "Max, \"the\" \\N", "photo": []byte{0xca, 0xfe}}}
+	messages := []parser.Message{
+		parser.NewInsertMessage(header, oldData),
+		parser.NewUpdateMessage(header, oldData, newData),
+		parser.NewQueryMessage(header, "DROP TABLE employees"),
+	}
+
+	testCases := []struct {
+		name     string
+		writer   func(dir string) *CSV
+		file     string
+		expected string
+	}{
+		{
+			"CSV with update pairs",
+			NewCSV,
+			"test_db.employees.csv",
+			"_op,_binlog_time,_binlog_position,_xid,emp_no,first_name,photo\n" +
+				"insert,2017-04-13T08:02:04Z,635,8,1,\"\",\\N\n" +
+				"update_before,2017-04-13T08:02:04Z,635,8,1,\"\",\\N\n" +
+				"update_after,2017-04-13T08:02:04Z,635,8,1,\"Max, \"\"the\"\" \\N\",cafe\n",
+		},
+		{
+			"TSV with updates after only",
+			func(dir string) *CSV {
+				w := NewTSV(dir)
+				w.UpdatePairs = false
+				return w
+			},
+			"test_db.employees.tsv",
+			"_op\t_binlog_time\t_binlog_position\t_xid\temp_no\tfirst_name\tphoto\n" +
+				"insert\t2017-04-13T08:02:04Z\t635\t8\t1\t\"\"\t\\N\n" +
+				"update\t2017-04-13T08:02:04Z\t635\t8\t1\t\"Max, \"\"the\"\" \\N\"\tcafe\n",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir, _ := ioutil.TempDir("", "csv")
+			defer os.RemoveAll(dir)
+			w := tc.writer(dir)
+			for _, message := range messages {
+				if data, err := w.Format(message); err != nil || data != nil {
+					t.Fatal(fmt.Sprintf("Unexpected output %s, %v", data, err))
+				}
+			}
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+			actual, err := ioutil.ReadFile(filepath.Join(dir, tc.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(actual) != tc.expected {
+				t.Fatal(fmt.Sprintf("Wrong file content\nexpected %q\ngot      %q", tc.expected, actual))
+			}
+		})
+	}
+}
+
+func TestCSVValue(t *testing.T) {
+	testCases := []struct {
+		dataType string
+		value    interface{}
+		expected string
+	}{
+		{"text", []byte("Max"), "Max"},
+		{"json", []byte(`{"a":1}`), `{"a":1}`},
+		{"varchar", "Max", "Max"},
+		{"varbinary", []byte{0xca, 0xfe}, "cafe"},
+		{"binary", "\xca\xfe", "cafe"},
+		{"", []byte{0xca, 0xfe}, "cafe"},
+	}
+	for _, tc := range testCases {
+		cell := csvValue(database.Column{Name: "value", DataType: tc.dataType}, tc.value)
+		if cell.null || cell.value != tc.expected {
+			t.Fatal(fmt.Sprintf("Expected %s for %s, got %+v", tc.expected, tc.dataType, cell))
+		}
+	}
+}
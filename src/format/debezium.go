@@ -0,0 +1,367 @@
+package format
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tanema/binlog-parser/src/database"
+	"github.com/tanema/binlog-parser/src/parser"
+)
+
+const (
+	debeziumOpCreate = "c"
+	debeziumOpUpdate = "u"
+	debeziumOpDelete = "d"
+	debeziumOpRead   = "r"
+)
+
+// Debezium renders row messages as Debezium MySQL connector change events.
+// Decimals are rendered as strings (decimal.handling.mode=string) and
+// temporal columns use the io.debezium.time types. Query messages are skipped.
+type Debezium struct {
+	// ServerName is the logical server name used in the source and schema names
+	ServerName string
+	// IncludeSchema wraps the payload with the Kafka Connect schema
+	IncludeSchema bool
+	// Snapshot marks inserts as snapshot reads, for backfilling initial state
+	Snapshot    bool
+	PrettyPrint bool
+	now         func() time.Time
+}
+
+type debeziumEnvelope struct {
+	Schema  *connectSchema  `json:"schema"`
+	Payload debeziumPayload `json:"payload"`
+}
+
+type debeziumPayload struct {
+	Before map[string]interface{} `json:"before"`
+	After  map[string]interface{} `json:"after"`
+	Source debeziumSource         `json:"source"`
+	Op     string                 `json:"op"`
+	TsMs   int64                  `json:"ts_ms"`
+}
+
+type debeziumSource struct {
+	Connector string  `json:"connector"`
+	Name      string  `json:"name"`
+	TsMs      int64   `json:"ts_ms"`
+	Snapshot  string  `json:"snapshot"`
+	DB        string  `json:"db"`
+	Table     string  `json:"table"`
+	ServerID  uint32  `json:"server_id"`
+	GTID      *string `json:"gtid"`
+	File      string  `json:"file"`
+	Pos       uint32  `json:"pos"`
+	Row       int     `json:"row"`
+	Query     *string `json:"query"`
+}
+
+type connectSchema struct {
+	Type       string            `json:"type"`
+	Fields     []connectSchema   `json:"fields,omitempty"`
+	Optional   bool              `json:"optional"`
+	Name       string            `json:"name,omitempty"`
+	Parameters map[string]string `json:"parameters,omitempty"`
+	Field      string            `json:"field,omitempty"`
+}
+
+// NewDebezium creates a Debezium formatter for the logical server name
+func NewDebezium(serverName string) *Debezium {
+	return &Debezium{ServerName: serverName, now: time.Now}
+}
+
+// Format renders a row message as a Debezium change event
+func (f *Debezium) Format(message parser.Message) ([]byte, error) {
+	header := message.GetHeader()
+	payload := debeziumPayload{
+		Source: f.source(header),
+		TsMs:   f.now().UnixNano() / int64(time.Millisecond),
+	}
+
+	var rows []parser.MessageRowData
+	switch m := message.(type) {
+	case parser.InsertMessage:
+		payload.Op = debeziumOpCreate
+		if f.Snapshot {
+			payload.Op = debeziumOpRead
+		}
+		payload.After = f.row(header.Columns, m.Data)
+		rows = append(rows, m.Data)
+	case parser.UpdateMessage:
+		payload.Op = debeziumOpUpdate
+		payload.Before = f.row(header.Columns, m.OldData)
+		payload.After = f.row(header.Columns, m.NewData)
+		rows = append(rows, m.OldData, m.NewData)
+	case parser.DeleteMessage:
+		payload.Op = debeziumOpDelete
+		payload.Before = f.row(header.Columns, m.Data)
+		rows = append(rows, m.Data)
+	default:
+		return nil, nil
+	}
+
+	if !f.IncludeSchema {
+		return marshal(payload, f.PrettyPrint)
+	}
+	return marshal(debeziumEnvelope{Schema: f.schema(header, rows), Payload: payload}, f.PrettyPrint)
+}
+
+func (f *Debezium) source(header parser.MessageHeader) debeziumSource {
+	source := debeziumSource{
+		Connector: "mysql",
+		Name:      f.ServerName,
+		Snapshot:  "false",
+		DB:        header.Schema,
+		Table:     header.Table,
+		Pos:       header.BinlogPosition,
+	}
+	if f.Snapshot {
+		source.Snapshot = "true"
+	}
+	if t := messageTime(header); !t.IsZero() {
+		source.TsMs = t.Unix() * 1000
+	}
+	if header.Event != nil {
+		source.ServerID = header.Event.ServerID
+		source.File = header.Event.BinlogFile
+		source.Pos = header.Event.StartPosition
+		source.Row = header.Event.RowIndex
+		if header.Event.GTID != "" {
+			gtid := header.Event.GTID
+			source.GTID = &gtid
+		}
+	}
+	if header.RowsQuery != "" {
+		query := string(header.RowsQuery)
+		source.Query = &query
+	}
+	return source
+}
+
+func (f *Debezium) row(columns []database.Column, data parser.MessageRowData) map[string]interface{} {
+	row := make(map[string]interface{}, len(data.Row))
+	for name, value := range data.Row {
+		if column, ok := lookupColumn(columns, name); ok {
+			row[name] = debeziumValue(column, NormalizeValue(column, value))
+		} else {
+			row[name] = value
+		}
+	}
+	return row
+}
+
+func (f *Debezium) schema(header parser.MessageHeader, rows []parser.MessageRowData) *connectSchema {
+	prefix := strings.Join([]string{f.ServerName, header.Schema, header.Table}, ".")
+	rowSchema := func(field string) connectSchema {
+		return connectSchema{Type: "struct", Fields: rowFieldsSchema(header.Columns, rows), Optional: true, Name: prefix + ".Value", Field: field}
+	}
+	optionalString := func(field string) connectSchema {
+		return connectSchema{Type: "string", Optional: true, Field: field}
+	}
+	return &connectSchema{
+		Type: "struct",
+		Fields: []connectSchema{
+			rowSchema("before"),
+			rowSchema("after"),
+			{
+				Type: "struct",
+				Fields: []connectSchema{
+					{Type: "string", Field: "connector"},
+					{Type: "string", Field: "name"},
+					{Type: "int64", Field: "ts_ms"},
+					{Type: "string", Optional: true, Field: "snapshot"},
+					{Type: "string", Field: "db"},
+					optionalString("table"),
+					{Type: "int64", Field: "server_id"},
+					optionalString("gtid"),
+					{Type: "string", Field: "file"},
+					{Type: "int64", Field: "pos"},
+					{Type: "int32", Field: "row"},
+					optionalString("query"),
+				},
+				Name:  "io.debezium.connector.mysql.Source",
+				Field: "source",
+			},
+			{Type: "string", Field: "op"},
+			{Type: "int64", Optional: true, Field: "ts_ms"},
+		},
+		Name: prefix + ".Envelope",
+	}
+}
+
+// rowFieldsSchema builds the row schema in column order, rows that could not
+// be mapped to the columns get a schema derived from their values
+func rowFieldsSchema(columns []database.Column, rows []parser.MessageRowData) []connectSchema {
+	mapped := len(columns) > 0
+	for _, row := range rows {
+		if row.MappingNotice != "" {
+			mapped = false
+		}
+	}
+
+	var fields []connectSchema
+	if mapped {
+		for _, column := range columns {
+			fields = append(fields, debeziumColumnSchema(column))
+		}
+		return fields
+	}
+
+	values := map[string]interface{}{}
+	for _, row := range rows {
+		for name, value := range row.Row {
+			if value != nil || values[name] == nil {
+				values[name] = value
+			}
+		}
+	}
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fields = append(fields, connectSchema{Type: valueSchemaType(values[name]), Optional: true, Field: name})
+	}
+	return fields
+}
+
+func debeziumColumnSchema(column database.Column) connectSchema {
+	schema := connectSchema{Optional: column.Nullable, Field: column.Name}
+	switch column.DataType {
+	case "tinyint", "smallint":
+		schema.Type = "int16"
+		if column.Unsigned() && column.DataType == "smallint" {
+			schema.Type = "int32"
+		}
+	case "mediumint":
+		schema.Type = "int32"
+	case "int", "integer":
+		schema.Type = "int32"
+		if column.Unsigned() {
+			schema.Type = "int64"
+		}
+	case "bigint", "bit":
+		schema.Type = "int64"
+	case "float":
+		schema.Type = "float32"
+	case "double", "real":
+		schema.Type = "float64"
+	case "year":
+		schema.Type, schema.Name = "int32", "io.debezium.time.Year"
+	case "date":
+		schema.Type, schema.Name = "int32", "io.debezium.time.Date"
+	case "datetime":
+		schema.Type, schema.Name = "int64", "io.debezium.time.Timestamp"
+	case "timestamp":
+		schema.Type, schema.Name = "string", "io.debezium.time.ZonedTimestamp"
+	case "time":
+		schema.Type, schema.Name = "int64", "io.debezium.time.MicroTime"
+	case "enum", "set":
+		schema.Type, schema.Name = "string", "io.debezium.data.Enum"
+		if column.DataType == "set" {
+			schema.Name = "io.debezium.data.EnumSet"
+		}
+		schema.Parameters = map[string]string{"allowed": strings.Join(enumLabels(column.ColumnType), ",")}
+	case "json":
+		schema.Type, schema.Name = "string", "io.debezium.data.Json"
+	case "binary", "varbinary", "tinyblob", "blob", "mediumblob", "longblob", "geometry", "point", "linestring", "polygon":
+		schema.Type = "bytes"
+	default:
+		schema.Type = "string"
+	}
+	return schema
+}
+
+// debeziumValue converts a normalized binlog value to the representation of
+// the column's Debezium schema type
+func debeziumValue(column database.Column, value interface{}) interface{} {
+	if value == nil {
+		return nil
+	}
+	switch column.DataType {
+	case "date":
+		if t, err := time.Parse("2006-01-02", fmt.Sprint(value)); err == nil {
+			return t.Unix() / 86400
+		}
+	case "datetime":
+		if t, err := parseDateTime(fmt.Sprint(value)); err == nil {
+			return t.UnixNano() / int64(time.Millisecond)
+		}
+	case "timestamp":
+		if t, err := parseDateTime(fmt.Sprint(value)); err == nil {
+			return t.UTC().Format(time.RFC3339Nano)
+		}
+	case "time":
+		if micros, ok := parseTimeMicros(fmt.Sprint(value)); ok {
+			return micros
+		}
+	case "decimal", "numeric":
+		return decimalString(value, column.Scale)
+	case "json":
+		if b, ok := value.([]byte); ok {
+			return string(b)
+		}
+		return fmt.Sprint(value)
+	case "binary", "varbinary":
+		if s, ok := value.(string); ok {
+			return []byte(s)
+		}
+	}
+	// text columns are declared as strings, the binlog carries them as bytes
+	if b, ok := value.([]byte); ok && column.DataType != "" && !IsBinaryType(column.DataType) {
+		return string(b)
+	}
+	return value
+}
+
+func parseDateTime(value string) (time.Time, error) {
+	return time.Parse("2006-01-02 15:04:05.999999", value)
+}
+
+// parseTimeMicros parses a [-]HH:MM:SS[.ffffff] time value into microseconds
+func parseTimeMicros(value string) (int64, bool) {
+	sign := int64(1)
+	if strings.HasPrefix(value, "-") {
+		sign = -1
+		value = value[1:]
+	}
+	var hours, minutes, seconds int64
+	var fraction string
+	if dot := strings.IndexByte(value, '.'); dot >= 0 {
+		fraction = value[dot+1:]
+		value = value[:dot]
+	}
+	if n, err := fmt.Sscanf(value, "%d:%d:%d", &hours, &minutes, &seconds); err != nil || n != 3 {
+		return 0, false
+	}
+	micros := ((hours*60+minutes)*60 + seconds) * 1000000
+	if fraction != "" {
+		fraction = (fraction + "000000")[:6]
+		var f int64
+		fmt.Sscanf(fraction, "%d", &f)
+		micros += f
+	}
+	return sign * micros, true
+}
+
+func valueSchemaType(value interface{}) string {
+	switch value.(type) {
+	case int8, int16:
+		return "int16"
+	case int32, int:
+		return "int32"
+	case int64, uint64:
+		return "int64"
+	case float32:
+		return "float32"
+	case float64:
+		return "float64"
+	case []byte:
+		return "bytes"
+	}
+	return "string"
+}
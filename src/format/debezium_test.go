@@ -0,0 +1,130 @@
+package format
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/tanema/binlog-parser/src/database"
+	"github.com/tanema/binlog-parser/src/parser"
+)
+
+func TestDebeziumFormat(t *testing.T) {
+	header := parser.NewMessageHeader("test_db", "employees", time.Unix(1492070524, 0), 635, 8)
+	header.Event = &parser.EventMetadata{ServerID: 1, StartPosition: 563, BinlogFile: "mysql-bin.000001", RowIndex: 1, GTID: "3e11fa47-71ca-11e1-9e33-c80aa9429562:23"}
+	header.Columns = []database.Column{
+		{Name: "emp_no", DataType: "int", ColumnType: "int(10) unsigned", PrimaryKey: true},
+		{Name: "birth_date", DataType: "date", ColumnType: "date"},
+		{Name: "first_name", DataType: "varchar", ColumnType: "varchar(14)", Nullable: true},
+	}
+	oldData := parser.MessageRowData{Row: parser.MessageRow{"emp_no": int32(1), "birth_date": "1970-01-02", "first_name": "Max"}}
+	newData := parser.MessageRowData{Row: parser.MessageRow{"emp_no": int32(1), "birth_date": "1970-01-02", "first_name": nil}}
+
+	formatter := NewDebezium("dbserver1")
+	formatter.now = func() time.Time { return time.Unix(1500000000, 0) }
+
+	t.Run("Update payload", func(t *testing.T) {
+		data, err := formatter.Format(parser.NewUpdateMessage(header, oldData, newData))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var payload map[string]interface{}
+		json.Unmarshal(data, &payload)
+
+		if payload["op"] != "u" || payload["ts_ms"] != float64(1500000000000) {
+			t.Fatal(fmt.Sprintf("Wrong op or ts_ms in %s", data))
+		}
+		before := payload["before"].(map[string]interface{})
+		if !reflect.DeepEqual(before, map[string]interface{}{"emp_no": float64(1), "birth_date": float64(1), "first_name": "Max"}) {
+			t.Fatal(fmt.Sprintf("Wrong before image %v", before))
+		}
+		after := payload["after"].(map[string]interface{})
+		if after["first_name"] != nil {
+			t.Fatal(fmt.Sprintf("Wrong after image %v", after))
+		}
+		expectedSource := map[string]interface{}{
+			"connector": "mysql", "name": "dbserver1", "ts_ms": float64(1492070524000), "snapshot": "false",
+			"db": "test_db", "table": "employees", "server_id": float64(1), "gtid": "3e11fa47-71ca-11e1-9e33-c80aa9429562:23",
+			"file": "mysql-bin.000001", "pos": float64(563), "row": float64(1), "query": nil,
+		}
+		if !reflect.DeepEqual(payload["source"], expectedSource) {
+			t.Fatal(fmt.Sprintf("Wrong source %v", payload["source"]))
+		}
+	})
+
+	t.Run("Insert and delete op", func(t *testing.T) {
+		testCases := []struct {
+			message  parser.Message
+			snapshot bool
+			op       string
+		}{
+			{parser.NewInsertMessage(header, newData), false, "c"},
+			{parser.NewDeleteMessage(header, oldData), false, "d"},
+			{parser.NewInsertMessage(header, newData), true, "r"},
+			{parser.NewDeleteMessage(header, oldData), true, "d"},
+		}
+		for _, tc := range testCases {
+			formatter.Snapshot = tc.snapshot
+			data, _ := formatter.Format(tc.message)
+			var payload map[string]interface{}
+			json.Unmarshal(data, &payload)
+			source := payload["source"].(map[string]interface{})
+			if payload["op"] != tc.op || source["snapshot"] != fmt.Sprint(tc.snapshot) {
+				t.Fatal(fmt.Sprintf("Expected op %s and snapshot %v in %s", tc.op, tc.snapshot, data))
+			}
+		}
+		formatter.Snapshot = false
+	})
+
+	t.Run("Schema", func(t *testing.T) {
+		formatter.IncludeSchema = true
+		defer func() { formatter.IncludeSchema = false }()
+		data, err := formatter.Format(parser.NewInsertMessage(header, newData))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var envelope debeziumEnvelope
+		json.Unmarshal(data, &envelope)
+		if envelope.Schema == nil || envelope.Schema.Name != "dbserver1.test_db.employees.Envelope" {
+			t.Fatal(fmt.Sprintf("Wrong schema in %s", data))
+		}
+		expectedFields := []connectSchema{
+			{Type: "int64", Field: "emp_no"},
+			{Type: "int32", Name: "io.debezium.time.Date", Field: "birth_date"},
+			{Type: "string", Optional: true, Field: "first_name"},
+		}
+		if !reflect.DeepEqual(envelope.Schema.Fields[1].Fields, expectedFields) {
+			t.Fatal(fmt.Sprintf("Wrong after schema %+v", envelope.Schema.Fields[1].Fields))
+		}
+	})
+
+	t.Run("Text and decimal values", func(t *testing.T) {
+		header := parser.NewMessageHeader("test_db", "notes", time.Unix(1492070524, 0), 635, 8)
+		header.Columns = []database.Column{
+			{Name: "body", DataType: "text", ColumnType: "text"},
+			{Name: "doc", DataType: "json", ColumnType: "json"},
+			{Name: "price", DataType: "decimal", ColumnType: "decimal(10,2)", Precision: 10, Scale: 2},
+			{Name: "raw", DataType: "blob", ColumnType: "blob"},
+		}
+		row := parser.MessageRowData{Row: parser.MessageRow{"body": []byte("hello"), "doc": []byte(`{"a":1}`), "price": float64(1000000), "raw": []byte{0xff}}}
+		data, err := formatter.Format(parser.NewInsertMessage(header, row))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var payload map[string]interface{}
+		json.Unmarshal(data, &payload)
+		expected := map[string]interface{}{"body": "hello", "doc": `{"a":1}`, "price": "1000000.00", "raw": "/w=="}
+		if !reflect.DeepEqual(payload["after"], expected) {
+			t.Fatal(fmt.Sprintf("Expected after image %v, got %v", expected, payload["after"]))
+		}
+	})
+
+	t.Run("Query messages are skipped", func(t *testing.T) {
+		data, err := formatter.Format(parser.NewQueryMessage(header, "DROP TABLE employees"))
+		if err != nil || data != nil {
+			t.Fatal("Expected query message to be skipped")
+		}
+	})
+}
@@ -0,0 +1,58 @@
+package format
+
+import (
+	"strings"
+
+	"github.com/tanema/binlog-parser/src/parser"
+)
+
+// Flashback renders the SQL that undoes the row changes of each transaction.
+// Deletes become inserts, inserts become deletes and updates restore the old
+// row. The statements of a transaction are held back until its commit and
+// written in reverse order wrapped in BEGIN/COMMIT. Query messages are skipped
+// as they can't be undone.
+type Flashback struct {
+	statements []string
+}
+
+// NewFlashback creates a flashback formatter
+func NewFlashback() *Flashback {
+	return &Flashback{}
+}
+
+// Format holds back the undo statement of a row message
+func (f *Flashback) Format(message parser.Message) ([]byte, error) {
+	header := message.GetHeader()
+	var statement string
+	var err error
+	switch m := message.(type) {
+	case parser.InsertMessage:
+		statement, err = deleteStatement(header, m.Data)
+	case parser.UpdateMessage:
+		statement, err = updateStatement(header, m.NewData, m.OldData)
+	case parser.DeleteMessage:
+		statement, err = insertStatement(header, m.Data)
+	default:
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	f.statements = append(f.statements, statement)
+	return nil, nil
+}
+
+// Commit renders the undo statements of the transaction in reverse order
+func (f *Flashback) Commit(xid uint64) ([]byte, error) {
+	if len(f.statements) == 0 {
+		return nil, nil
+	}
+	lines := make([]string, 0, len(f.statements)+2)
+	lines = append(lines, "BEGIN;")
+	for i := len(f.statements) - 1; i >= 0; i-- {
+		lines = append(lines, f.statements[i])
+	}
+	lines = append(lines, "COMMIT;")
+	f.statements = nil
+	return []byte(strings.Join(lines, "\n")), nil
+}
@@ -0,0 +1,49 @@
+package format
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/tanema/binlog-parser/src/database"
+	"github.com/tanema/binlog-parser/src/parser"
+)
+
+func TestFlashbackFormat(t *testing.T) {
+	header := parser.NewMessageHeader("test_db", "employees", time.Now(), 100, 8)
+	header.Columns = []database.Column{
+		{Name: "emp_no", DataType: "int", ColumnType: "int(11)", PrimaryKey: true},
+		{Name: "first_name", DataType: "varchar", ColumnType: "varchar(14)"},
+	}
+	maxRow := parser.MessageRowData{Row: parser.MessageRow{"emp_no": int32(1), "first_name": "Max"}}
+	moritzRow := parser.MessageRowData{Row: parser.MessageRow{"emp_no": int32(1), "first_name": "Moritz"}}
+
+	formatter := NewFlashback()
+	for _, message := range []parser.Message{
+		parser.NewInsertMessage(header, maxRow),
+		parser.NewUpdateMessage(header, maxRow, moritzRow),
+		parser.NewDeleteMessage(header, moritzRow),
+		parser.NewQueryMessage(header, "DROP TABLE employees"),
+	} {
+		if data, err := formatter.Format(message); err != nil || data != nil {
+			t.Fatal("Expected statements to be held back until commit")
+		}
+	}
+
+	data, err := formatter.Commit(8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "BEGIN;\n" +
+		"INSERT INTO `test_db`.`employees` (`emp_no`, `first_name`) VALUES (1, 'Moritz');\n" +
+		"UPDATE `test_db`.`employees` SET `emp_no`=1, `first_name`='Max' WHERE `emp_no`=1;\n" +
+		"DELETE FROM `test_db`.`employees` WHERE `emp_no`=1;\n" +
+		"COMMIT;"
+	if string(data) != expected {
+		t.Fatal(fmt.Sprintf("Wrong flashback SQL\nexpected %s\ngot      %s", expected, data))
+	}
+
+	if data, _ = formatter.Commit(9); data != nil {
+		t.Fatal("Expected nothing to commit for empty transaction")
+	}
+}
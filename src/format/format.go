@@ -0,0 +1,55 @@
+package format
+
+import (
+	"encoding/json"
+
+	"github.com/tanema/binlog-parser/src/parser"
+)
+
+// Formatter renders a message in an output format. A nil result without an
+// error means the message has no representation in the format and is skipped.
+type Formatter interface {
+	Format(message parser.Message) ([]byte, error)
+}
+
+// TransactionFormatter is a Formatter that needs to know where transactions
+// end. Commit is called after the last message of each transaction and returns
+// the output held back until then.
+type TransactionFormatter interface {
+	Formatter
+	Commit(xid uint64) ([]byte, error)
+}
+
+// TableTransactionFormatter is a TransactionFormatter whose output can be
+// split by table within a transaction. EndTable returns the output held back
+// for the rows of a table that isn't the last of the transaction, without
+// what marks the end of the transaction. Formatters that aren't
+// TableTransactionFormatters are committed after the rows of each table.
+type TableTransactionFormatter interface {
+	TransactionFormatter
+	EndTable() ([]byte, error)
+}
+
+// BinaryFormatter is a Formatter whose output frames itself, it is written
+// without a line break after each message
+type BinaryFormatter interface {
+	Formatter
+	Binary()
+}
+
+// JSON renders messages as they are, this is the default output format
+type JSON struct {
+	PrettyPrint bool
+}
+
+// Format renders the message as JSON
+func (f JSON) Format(message parser.Message) ([]byte, error) {
+	return marshal(message, f.PrettyPrint)
+}
+
+func marshal(v interface{}, prettyPrint bool) ([]byte, error) {
+	if prettyPrint {
+		return json.MarshalIndent(v, "", "    ")
+	}
+	return json.Marshal(v)
+}
@@ -0,0 +1,198 @@
+package format
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"time"
+
+	"github.com/tanema/binlog-parser/src/database"
+	"github.com/tanema/binlog-parser/src/parser"
+)
+
+// Maxwell renders messages in the format of Maxwell's Daemon. The last row of
+// each transaction is held back until the commit so it can carry the commit
+// flag, DDL query messages are written as schema change records.
+type Maxwell struct {
+	PrettyPrint bool
+	pending     *maxwellRow
+}
+
+type maxwellRow struct {
+	Database string      `json:"database"`
+	Table    string      `json:"table"`
+	Query    string      `json:"query,omitempty"`
+	Type     string      `json:"type"`
+	Ts       int64       `json:"ts"`
+	XID      uint64      `json:"xid"`
+	Commit   bool        `json:"commit,omitempty"`
+	Data     orderedRow  `json:"data"`
+	Old      *orderedRow `json:"old,omitempty"`
+}
+
+type maxwellDDL struct {
+	Type     string `json:"type"`
+	Database string `json:"database"`
+	Table    string `json:"table,omitempty"`
+	Ts       int64  `json:"ts"`
+	SQL      string `json:"sql"`
+}
+
+// NewMaxwell creates a Maxwell formatter
+func NewMaxwell() *Maxwell {
+	return &Maxwell{}
+}
+
+// Format renders the previously held back row and holds back the row of this
+// message. Query messages that are no DDL statements are skipped.
+func (f *Maxwell) Format(message parser.Message) ([]byte, error) {
+	header := message.GetHeader()
+	if queryMessage, ok := message.(parser.QueryMessage); ok {
+		return f.formatDDL(header, queryMessage)
+	}
+
+	row := &maxwellRow{
+		Database: header.Schema,
+		Table:    header.Table,
+		Query:    string(header.RowsQuery),
+		Ts:       messageTime(header).Unix(),
+		XID:      header.XID,
+	}
+	switch m := message.(type) {
+	case parser.InsertMessage:
+		row.Type = "insert"
+		row.Data = newOrderedRow(header.Columns, m.Data.Row, maxwellValue)
+	case parser.UpdateMessage:
+		row.Type = "update"
+		row.Data = newOrderedRow(header.Columns, m.NewData.Row, maxwellValue)
+		old := newOrderedRow(header.Columns, changedColumns(m.OldData.Row, m.NewData.Row), maxwellValue)
+		row.Old = &old
+	case parser.DeleteMessage:
+		row.Type = "delete"
+		row.Data = newOrderedRow(header.Columns, m.Data.Row, maxwellValue)
+	default:
+		return nil, nil
+	}
+
+	previous := f.pending
+	f.pending = row
+	if previous == nil {
+		return nil, nil
+	}
+	return marshal(previous, f.PrettyPrint)
+}
+
+// Commit renders the held back last row of the transaction with the commit flag
+func (f *Maxwell) Commit(xid uint64) ([]byte, error) {
+	if f.pending == nil {
+		return nil, nil
+	}
+	row := f.pending
+	f.pending = nil
+	row.Commit = true
+	return marshal(row, f.PrettyPrint)
+}
+
+// EndTable returns the row held back without the commit flag, when the rows
+// of a transaction are written table by table
+func (f *Maxwell) EndTable() ([]byte, error) {
+	if f.pending == nil {
+		return nil, nil
+	}
+	row := f.pending
+	f.pending = nil
+	return marshal(row, f.PrettyPrint)
+}
+
+func (f *Maxwell) formatDDL(header parser.MessageHeader, message parser.QueryMessage) ([]byte, error) {
+	if message.DDL == nil {
+		return nil, nil
+	}
+	ts := messageTime(header).Unix()
+	var records [][]byte
+	add := func(ddlType, schema, table string) error {
+		data, err := marshal(maxwellDDL{Type: ddlType, Database: schema, Table: table, Ts: ts, SQL: string(message.Query)}, f.PrettyPrint)
+		records = append(records, data)
+		return err
+	}
+
+	ddl := message.DDL
+	switch ddl.Kind {
+	case parser.DDLCreateDatabase, parser.DDLAlterDatabase, parser.DDLDropDatabase:
+		ddlType := map[parser.DDLKind]string{
+			parser.DDLCreateDatabase: "database-create",
+			parser.DDLAlterDatabase:  "database-alter",
+			parser.DDLDropDatabase:   "database-drop",
+		}[ddl.Kind]
+		for _, schema := range ddl.Schemas {
+			if err := add(ddlType, schema, ""); err != nil {
+				return nil, err
+			}
+		}
+	case parser.DDLCreateTable:
+		if err := add("table-create", ddl.Tables[0].Schema, ddl.Tables[0].Table); err != nil {
+			return nil, err
+		}
+	case parser.DDLDropTable:
+		for _, table := range ddl.Tables {
+			if err := add("table-drop", table.Schema, table.Table); err != nil {
+				return nil, err
+			}
+		}
+	case parser.DDLAlterTable, parser.DDLCreateIndex, parser.DDLDropIndex:
+		if err := add("table-alter", ddl.Tables[0].Schema, ddl.Tables[0].Table); err != nil {
+			return nil, err
+		}
+	case parser.DDLRenameTable:
+		for i := 0; i+1 < len(ddl.Tables); i += 2 {
+			if err := add("table-alter", ddl.Tables[i].Schema, ddl.Tables[i].Table); err != nil {
+				return nil, err
+			}
+		}
+	default:
+		return nil, nil
+	}
+	return bytes.Join(records, []byte("\n")), nil
+}
+
+// changedColumns returns the old values of the columns that changed
+func changedColumns(oldRow, newRow parser.MessageRow) parser.MessageRow {
+	changed := parser.MessageRow{}
+	for name, oldValue := range oldRow {
+		if newValue, ok := newRow[name]; !ok || !reflect.DeepEqual(oldValue, newValue) {
+			changed[name] = oldValue
+		}
+	}
+	return changed
+}
+
+func maxwellValue(column database.Column, value interface{}) interface{} {
+	value = NormalizeValue(column, value)
+	switch column.DataType {
+	case "decimal", "numeric":
+		if value != nil {
+			number := json.Number(decimalString(value, column.Scale))
+			if _, err := number.Float64(); err == nil {
+				return number
+			}
+		}
+	case "json":
+		// JSON columns are embedded as JSON like maxwell does
+		if b, ok := value.([]byte); ok && json.Valid(b) {
+			return json.RawMessage(b)
+		}
+		if s, ok := value.(string); ok && json.Valid([]byte(s)) {
+			return json.RawMessage(s)
+		}
+	}
+	// the binlog carries text columns as bytes, which JSON would write as base64
+	if b, ok := value.([]byte); ok && column.DataType != "" && !IsBinaryType(column.DataType) {
+		return string(b)
+	}
+	return value
+}
+
+func messageTime(header parser.MessageHeader) time.Time {
+	t, _ := time.Parse(time.RFC3339, header.BinlogMessageTime)
+	return t
+}
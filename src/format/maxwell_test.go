@@ -0,0 +1,91 @@
+package format
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/tanema/binlog-parser/src/database"
+	"github.com/tanema/binlog-parser/src/parser"
+)
+
+func TestMaxwellFormat(t *testing.T) {
+	header := parser.NewMessageHeader("test_db", "employees", time.Unix(1492070524, 0), 635, 8)
+	header.Columns = []database.Column{
+		{Name: "emp_no", DataType: "int", ColumnType: "int(11)"},
+		{Name: "salary", DataType: "decimal", ColumnType: "decimal(10,2)"},
+		{Name: "first_name", DataType: "varchar", ColumnType: "varchar(14)"},
+	}
+	oldData := parser.MessageRowData{Row: parser.MessageRow{"emp_no": int32(1), "salary": "10.50", "first_name": "Max"}}
+	newData := parser.MessageRowData{Row: parser.MessageRow{"emp_no": int32(1), "salary": "10.50", "first_name": "Moritz"}}
+
+	t.Run("Last row of transaction carries commit", func(t *testing.T) {
+		formatter := NewMaxwell()
+		data, err := formatter.Format(parser.NewInsertMessage(header, oldData))
+		if err != nil || data != nil {
+			t.Fatal("Expected first row to be held back")
+		}
+
+		data, err = formatter.Format(parser.NewUpdateMessage(header, oldData, newData))
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := `{"database":"test_db","table":"employees","type":"insert","ts":1492070524,"xid":8,"data":{"emp_no":1,"salary":10.50,"first_name":"Max"}}`
+		if string(data) != expected {
+			t.Fatal(fmt.Sprintf("Wrong insert record\nexpected %s\ngot      %s", expected, data))
+		}
+
+		data, err = formatter.Commit(8)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected = `{"database":"test_db","table":"employees","type":"update","ts":1492070524,"xid":8,"commit":true,"data":{"emp_no":1,"salary":10.50,"first_name":"Moritz"},"old":{"first_name":"Max"}}`
+		if string(data) != expected {
+			t.Fatal(fmt.Sprintf("Wrong update record\nexpected %s\ngot      %s", expected, data))
+		}
+
+		if data, _ = formatter.Commit(9); data != nil {
+			t.Fatal("Expected nothing to commit for empty transaction")
+		}
+	})
+
+	t.Run("Text, JSON and decimal values", func(t *testing.T) {
+		header := parser.NewMessageHeader("test_db", "notes", time.Unix(1492070524, 0), 635, 8)
+		header.Columns = []database.Column{
+			{Name: "body", DataType: "text", ColumnType: "text"},
+			{Name: "doc", DataType: "json", ColumnType: "json"},
+			{Name: "price", DataType: "decimal", ColumnType: "decimal(10,2)", Precision: 10, Scale: 2},
+			{Name: "raw", DataType: "blob", ColumnType: "blob"},
+		}
+		row := parser.MessageRowData{Row: parser.MessageRow{"body": []byte("hello"), "doc": []byte(`{"a":[1,2]}`), "price": float64(1000000), "raw": []byte{0xff}}}
+		formatter := NewMaxwell()
+		formatter.Format(parser.NewInsertMessage(header, row))
+		data, err := formatter.Commit(8)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := `{"database":"test_db","table":"notes","type":"insert","ts":1492070524,"xid":8,"commit":true,"data":{"body":"hello","doc":{"a":[1,2]},"price":1000000.00,"raw":"/w=="}}`
+		if string(data) != expected {
+			t.Fatal(fmt.Sprintf("Wrong insert record\nexpected %s\ngot      %s", expected, data))
+		}
+	})
+
+	t.Run("DDL", func(t *testing.T) {
+		formatter := NewMaxwell()
+		query := parser.NewQueryMessage(header, "DROP TABLE a, b")
+		query.DDL, _ = parser.ParseDDL(string(query.Query), "test_db")
+		data, err := formatter.Format(query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := `{"type":"table-drop","database":"test_db","table":"a","ts":1492070524,"sql":"DROP TABLE a, b"}` + "\n" +
+			`{"type":"table-drop","database":"test_db","table":"b","ts":1492070524,"sql":"DROP TABLE a, b"}`
+		if string(data) != expected {
+			t.Fatal(fmt.Sprintf("Wrong DDL records\nexpected %s\ngot      %s", expected, data))
+		}
+
+		if data, _ := formatter.Format(parser.NewQueryMessage(header, "DELETE FROM a")); data != nil {
+			t.Fatal("Expected non DDL query to be skipped")
+		}
+	})
+}
@@ -0,0 +1,582 @@
+package format
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/big"
+	"os"
+	"path/filepath"
+
+	"github.com/tanema/binlog-parser/src/database"
+	"github.com/tanema/binlog-parser/src/parser"
+)
+
+// ParquetDefaultFileSize is the size files are rolled at by default
+const ParquetDefaultFileSize = 128 << 20
+
+const parquetRowGroupSize = 8 << 20
+
+var parquetMagic = []byte("PAR1")
+
+// parquet physical types
+const (
+	parquetInt32             = 1
+	parquetInt64             = 2
+	parquetFloat             = 4
+	parquetDouble            = 5
+	parquetByteArray         = 6
+	parquetFixedLenByteArray = 7
+)
+
+// parquet converted types, -1 for none
+const (
+	parquetNoConvertedType = -1
+	parquetUTF8            = 0
+	parquetDecimal         = 5
+	parquetDate            = 6
+	parquetTimeMicros      = 8
+	parquetTimestampMicros = 10
+	parquetUint64          = 14
+)
+
+const (
+	parquetRequired = 0
+	parquetOptional = 1
+
+	parquetEncodingPlain = 0
+	parquetEncodingRLE   = 3
+)
+
+// Parquet writes row messages into Parquet files partitioned by table and
+// binlog date, <dir>/<schema>/<table>/date=YYYY-MM-DD/part-N.parquet. Each row
+// has the meta columns _op, _binlog_time, _binlog_position and _xid followed by
+// the table columns, updates are written as their new row. Table columns are
+// optional since zero dates and minimal row images have no value. Files are
+// rolled once they reach MaxFileSize and Close must be called to write the
+// footers of the open files. Query messages are skipped.
+type Parquet struct {
+	Dir         string
+	MaxFileSize int64
+	files       map[string]*parquetFile
+}
+
+type parquetFile struct {
+	file      *os.File
+	date      string
+	columns   []*parquetColumn
+	offset    int64
+	rows      int64
+	rowGroups []parquetRowGroup
+}
+
+type parquetColumn struct {
+	name       string
+	column     database.Column
+	kind       valueKind
+	optional   bool
+	typeLength int
+	values     bytes.Buffer
+	levels     []byte
+}
+
+type parquetRowGroup struct {
+	chunks []parquetChunk
+	rows   int64
+	size   int64
+}
+
+type parquetChunk struct {
+	offset int64
+	size   int64
+}
+
+// NewParquet creates a Parquet writer into the directory
+func NewParquet(dir string) *Parquet {
+	return &Parquet{Dir: dir, MaxFileSize: ParquetDefaultFileSize, files: map[string]*parquetFile{}}
+}
+
+// Format appends the row of the message to the file of its table and date, it
+// never returns output of its own
+func (w *Parquet) Format(message parser.Message) ([]byte, error) {
+	header := message.GetHeader()
+	var op string
+	var data parser.MessageRowData
+	switch m := message.(type) {
+	case parser.InsertMessage:
+		op, data = "insert", m.Data
+	case parser.UpdateMessage:
+		op, data = "update", m.NewData
+	case parser.DeleteMessage:
+		op, data = "delete", m.Data
+	default:
+		return nil, nil
+	}
+
+	t := messageTime(header).UTC()
+	f, err := w.tableFile(header, t.Format("2006-01-02"), parquetColumns(header, data))
+	if err != nil {
+		return nil, err
+	}
+
+	var micros int64
+	if !t.IsZero() {
+		micros = t.UnixNano() / 1000
+	}
+	meta := []interface{}{op, micros, int64(header.BinlogPosition), int64(header.XID)}
+	for i, column := range f.columns {
+		var value interface{}
+		if i < len(meta) {
+			value = meta[i]
+		} else if value, err = typedValue(column.column, column.kind, data.Row[column.column.Name]); err != nil {
+			return nil, fmt.Errorf("can not encode %s.%s.%s as parquet: %s", header.Schema, header.Table, column.name, err)
+		}
+		if err := column.append(value); err != nil {
+			return nil, fmt.Errorf("can not encode %s.%s.%s as parquet: %s", header.Schema, header.Table, column.name, err)
+		}
+	}
+	f.rows++
+
+	if int64(f.bufferedSize()) >= w.rowGroupSize() {
+		return nil, w.flushRowGroup(header.Schema+"."+header.Table, f)
+	}
+	return nil, nil
+}
+
+// Close writes the buffered rows and footers and closes all files
+func (w *Parquet) Close() error {
+	var firstErr error
+	for name, f := range w.files {
+		if err := f.close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		delete(w.files, name)
+	}
+	return firstErr
+}
+
+func (w *Parquet) rowGroupSize() int64 {
+	if w.MaxFileSize > 0 && w.MaxFileSize < parquetRowGroupSize {
+		return w.MaxFileSize
+	}
+	return parquetRowGroupSize
+}
+
+// flushRowGroup writes the buffered rows and rolls the file once it is big
+// enough
+func (w *Parquet) flushRowGroup(name string, f *parquetFile) error {
+	if err := f.writeRowGroup(); err != nil {
+		return err
+	}
+	if w.MaxFileSize > 0 && f.offset >= w.MaxFileSize {
+		delete(w.files, name)
+		return f.close()
+	}
+	return nil
+}
+
+// tableFile returns the open file of the table, a new file is started for a
+// new date or when the columns of the table changed
+func (w *Parquet) tableFile(header parser.MessageHeader, date string, columns []*parquetColumn) (*parquetFile, error) {
+	name := header.Schema + "." + header.Table
+	if f, ok := w.files[name]; ok {
+		if f.date == date && sameParquetColumns(f.columns, columns) {
+			return f, nil
+		}
+		delete(w.files, name)
+		if err := f.close(); err != nil {
+			return nil, err
+		}
+	}
+
+	dir := filepath.Join(w.Dir, header.Schema, header.Table, "date="+date)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	var path string
+	for part := 0; ; part++ {
+		path = filepath.Join(dir, fmt.Sprintf("part-%d.parquet", part))
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			break
+		}
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	f := &parquetFile{file: file, date: date, columns: columns}
+	w.files[name] = f
+	n, err := file.Write(parquetMagic)
+	f.offset += int64(n)
+	return f, err
+}
+
+// parquetColumns are the meta columns followed by the table columns, rows that
+// could not be mapped to the columns get string columns in sorted order
+func parquetColumns(header parser.MessageHeader, data parser.MessageRowData) []*parquetColumn {
+	columns := []*parquetColumn{
+		{name: "_op", kind: kindString},
+		{name: "_binlog_time", kind: kindTimestamp},
+		{name: "_binlog_position", kind: kindInt64},
+		{name: "_xid", kind: kindInt64},
+	}
+	if len(header.Columns) == 0 || data.MappingNotice != "" {
+		for _, field := range rowFieldsSchema(nil, []parser.MessageRowData{data}) {
+			columns = append(columns, &parquetColumn{name: field.Field, column: database.Column{Name: field.Field}, kind: kindString, optional: true})
+		}
+		return columns
+	}
+	for _, column := range header.Columns {
+		c := &parquetColumn{name: column.Name, column: column, kind: columnKind(column), optional: true}
+		if c.kind == kindDecimal && decimalPrecision(column) > 18 {
+			c.typeLength = decimalBytes(decimalPrecision(column))
+		}
+		columns = append(columns, c)
+	}
+	return columns
+}
+
+func sameParquetColumns(a, b []*parquetColumn) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].name != b[i].name || a[i].kind != b[i].kind || a[i].typeLength != b[i].typeLength ||
+			a[i].column.Precision != b[i].column.Precision || a[i].column.Scale != b[i].column.Scale {
+			return false
+		}
+	}
+	return true
+}
+
+// decimalBytes is the size of the smallest two's complement that holds all
+// decimals of the precision
+func decimalBytes(precision int) int {
+	limit := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(precision)), nil)
+	n := 1
+	for new(big.Int).Lsh(big.NewInt(1), uint(8*n-1)).Cmp(limit) < 0 {
+		n++
+	}
+	return n
+}
+
+// physicalType returns the parquet type and converted type of the column
+func (c *parquetColumn) physicalType() (int32, int32) {
+	switch c.kind {
+	case kindInt32:
+		return parquetInt32, parquetNoConvertedType
+	case kindInt64:
+		return parquetInt64, parquetNoConvertedType
+	case kindUint64:
+		return parquetInt64, parquetUint64
+	case kindFloat:
+		return parquetFloat, parquetNoConvertedType
+	case kindDouble:
+		return parquetDouble, parquetNoConvertedType
+	case kindDecimal:
+		if c.typeLength > 0 {
+			return parquetFixedLenByteArray, parquetDecimal
+		}
+		return parquetInt64, parquetDecimal
+	case kindDate:
+		return parquetInt32, parquetDate
+	case kindTimestamp:
+		return parquetInt64, parquetTimestampMicros
+	case kindTime:
+		return parquetInt64, parquetTimeMicros
+	case kindBytes:
+		return parquetByteArray, parquetNoConvertedType
+	}
+	return parquetByteArray, parquetUTF8
+}
+
+// append adds the typed value with the plain encoding of the column
+func (c *parquetColumn) append(value interface{}) error {
+	if c.optional {
+		if value == nil {
+			c.levels = append(c.levels, 0)
+			return nil
+		}
+		c.levels = append(c.levels, 1)
+	} else if value == nil {
+		return fmt.Errorf("null value in required column")
+	}
+
+	physical, _ := c.physicalType()
+	switch v := value.(type) {
+	case int64:
+		if physical == parquetInt32 {
+			return binary.Write(&c.values, binary.LittleEndian, int32(v))
+		}
+		return binary.Write(&c.values, binary.LittleEndian, v)
+	case uint64:
+		return binary.Write(&c.values, binary.LittleEndian, v)
+	case float32:
+		return binary.Write(&c.values, binary.LittleEndian, math.Float32bits(v))
+	case float64:
+		return binary.Write(&c.values, binary.LittleEndian, math.Float64bits(v))
+	case *big.Int:
+		if c.typeLength == 0 {
+			if !v.IsInt64() {
+				return fmt.Errorf("decimal %s out of range", v)
+			}
+			return binary.Write(&c.values, binary.LittleEndian, v.Int64())
+		}
+		b := twosComplement(v)
+		if len(b) > c.typeLength {
+			return fmt.Errorf("decimal %s out of range", v)
+		}
+		pad := byte(0)
+		if v.Sign() < 0 {
+			pad = 0xff
+		}
+		c.values.Write(bytes.Repeat([]byte{pad}, c.typeLength-len(b)))
+		c.values.Write(b)
+	case []byte:
+		binary.Write(&c.values, binary.LittleEndian, uint32(len(v)))
+		c.values.Write(v)
+	case string:
+		binary.Write(&c.values, binary.LittleEndian, uint32(len(v)))
+		c.values.WriteString(v)
+	}
+	return nil
+}
+
+func (f *parquetFile) bufferedSize() int {
+	size := 0
+	for _, column := range f.columns {
+		size += column.values.Len() + len(column.levels)
+	}
+	return size
+}
+
+// writeRowGroup writes the buffered rows as row group with one plain encoded
+// data page per column
+func (f *parquetFile) writeRowGroup() error {
+	if f.rows == 0 {
+		return nil
+	}
+	group := parquetRowGroup{rows: f.rows}
+	for _, column := range f.columns {
+		var page bytes.Buffer
+		if column.optional {
+			levels := rleLevels(column.levels)
+			binary.Write(&page, binary.LittleEndian, uint32(len(levels)))
+			page.Write(levels)
+		}
+		page.Write(column.values.Bytes())
+
+		var header thriftWriter
+		header.beginStruct()
+		header.i32Field(1, 0)
+		header.i32Field(2, int32(page.Len()))
+		header.i32Field(3, int32(page.Len()))
+		header.structField(5)
+		header.i32Field(1, int32(f.rows))
+		header.i32Field(2, parquetEncodingPlain)
+		header.i32Field(3, parquetEncodingRLE)
+		header.i32Field(4, parquetEncodingRLE)
+		header.endStruct()
+		header.endStruct()
+
+		chunk := parquetChunk{offset: f.offset, size: int64(header.buf.Len() + page.Len())}
+		if _, err := f.file.Write(header.buf.Bytes()); err != nil {
+			return err
+		}
+		if _, err := f.file.Write(page.Bytes()); err != nil {
+			return err
+		}
+		f.offset += chunk.size
+		group.size += chunk.size
+		group.chunks = append(group.chunks, chunk)
+
+		column.values.Reset()
+		column.levels = column.levels[:0]
+	}
+	f.rowGroups = append(f.rowGroups, group)
+	f.rows = 0
+	return nil
+}
+
+// close writes the buffered rows and the footer
+func (f *parquetFile) close() error {
+	err := f.writeRowGroup()
+	if err == nil {
+		_, err = f.file.Write(f.footer())
+	}
+	if closeErr := f.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// footer encodes the file metadata followed by its length and the magic
+func (f *parquetFile) footer() []byte {
+	var numRows int64
+	for _, group := range f.rowGroups {
+		numRows += group.rows
+	}
+
+	var w thriftWriter
+	w.beginStruct()
+	w.i32Field(1, 1)
+	w.listField(2, thriftStruct, len(f.columns)+1)
+	w.beginStruct()
+	w.binaryField(4, "schema")
+	w.i32Field(5, int32(len(f.columns)))
+	w.endStruct()
+	for _, column := range f.columns {
+		physical, converted := column.physicalType()
+		w.beginStruct()
+		w.i32Field(1, physical)
+		if column.typeLength > 0 {
+			w.i32Field(2, int32(column.typeLength))
+		}
+		repetition := int32(parquetRequired)
+		if column.optional {
+			repetition = parquetOptional
+		}
+		w.i32Field(3, repetition)
+		w.binaryField(4, column.name)
+		if converted != parquetNoConvertedType {
+			w.i32Field(6, converted)
+		}
+		if converted == parquetDecimal {
+			w.i32Field(7, int32(column.column.Scale))
+			w.i32Field(8, int32(decimalPrecision(column.column)))
+		}
+		w.endStruct()
+	}
+	w.i64Field(3, numRows)
+	w.listField(4, thriftStruct, len(f.rowGroups))
+	for _, group := range f.rowGroups {
+		w.beginStruct()
+		w.listField(1, thriftStruct, len(group.chunks))
+		for i, chunk := range group.chunks {
+			physical, _ := f.columns[i].physicalType()
+			w.beginStruct()
+			w.i64Field(2, chunk.offset)
+			w.structField(3)
+			w.i32Field(1, physical)
+			w.listField(2, thriftI32, 2)
+			w.varint(parquetEncodingPlain)
+			w.varint(parquetEncodingRLE)
+			w.listField(3, thriftBinary, 1)
+			w.binary(f.columns[i].name)
+			w.i32Field(4, 0)
+			w.i64Field(5, group.rows)
+			w.i64Field(6, chunk.size)
+			w.i64Field(7, chunk.size)
+			w.i64Field(9, chunk.offset)
+			w.endStruct()
+			w.endStruct()
+		}
+		w.i64Field(2, group.size)
+		w.i64Field(3, group.rows)
+		w.endStruct()
+	}
+	w.binaryField(6, "binlog-parser")
+	w.endStruct()
+
+	footer := w.buf.Bytes()
+	binary.Write(&w.buf, binary.LittleEndian, uint32(len(footer)))
+	w.buf.Write(parquetMagic)
+	return w.buf.Bytes()
+}
+
+// rleLevels encodes definition levels of bit width one as runs of the
+// RLE/bit-packing hybrid encoding
+func rleLevels(levels []byte) []byte {
+	var buf bytes.Buffer
+	var b [binary.MaxVarintLen64]byte
+	for i := 0; i < len(levels); {
+		run := 1
+		for i+run < len(levels) && levels[i+run] == levels[i] {
+			run++
+		}
+		buf.Write(b[:binary.PutUvarint(b[:], uint64(run)<<1)])
+		buf.WriteByte(levels[i])
+		i += run
+	}
+	return buf.Bytes()
+}
+
+// thrift compact protocol types
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// thriftWriter encodes structs with the thrift compact protocol that parquet
+// uses for its metadata
+type thriftWriter struct {
+	buf    bytes.Buffer
+	fields []int16
+}
+
+func (w *thriftWriter) beginStruct() {
+	w.fields = append(w.fields, 0)
+}
+
+func (w *thriftWriter) endStruct() {
+	w.buf.WriteByte(0)
+	w.fields = w.fields[:len(w.fields)-1]
+}
+
+func (w *thriftWriter) field(id int16, fieldType byte) {
+	last := &w.fields[len(w.fields)-1]
+	if delta := id - *last; delta > 0 && delta <= 15 {
+		w.buf.WriteByte(byte(delta)<<4 | fieldType)
+	} else {
+		w.buf.WriteByte(fieldType)
+		w.varint(int64(id))
+	}
+	*last = id
+}
+
+func (w *thriftWriter) varint(n int64) {
+	var b [binary.MaxVarintLen64]byte
+	w.buf.Write(b[:binary.PutVarint(b[:], n)])
+}
+
+func (w *thriftWriter) binary(s string) {
+	var b [binary.MaxVarintLen64]byte
+	w.buf.Write(b[:binary.PutUvarint(b[:], uint64(len(s)))])
+	w.buf.WriteString(s)
+}
+
+func (w *thriftWriter) i32Field(id int16, n int32) {
+	w.field(id, thriftI32)
+	w.varint(int64(n))
+}
+
+func (w *thriftWriter) i64Field(id int16, n int64) {
+	w.field(id, thriftI64)
+	w.varint(n)
+}
+
+func (w *thriftWriter) binaryField(id int16, s string) {
+	w.field(id, thriftBinary)
+	w.binary(s)
+}
+
+func (w *thriftWriter) structField(id int16) {
+	w.field(id, thriftStruct)
+	w.beginStruct()
+}
+
+// listField starts a list, the elements are written after it
+func (w *thriftWriter) listField(id int16, elementType byte, size int) {
+	w.field(id, thriftList)
+	if size < 15 {
+		w.buf.WriteByte(byte(size)<<4 | elementType)
+		return
+	}
+	w.buf.WriteByte(0xf0 | elementType)
+	var b [binary.MaxVarintLen64]byte
+	w.buf.Write(b[:binary.PutUvarint(b[:], uint64(size))])
+}
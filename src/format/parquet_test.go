@@ -0,0 +1,321 @@
+package format
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/tanema/binlog-parser/src/database"
+	"github.com/tanema/binlog-parser/src/parser"
+)
+
+func TestParquetWriter(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "parquet")
+	defer os.RemoveAll(dir)
+
+	header := parser.NewMessageHeader("test_db", "employees", time.Unix(1492070524, 0), 635, 8)
+	header.Columns = []database.Column{
+		{Name: "emp_no", DataType: "int", ColumnType: "int(11)"},
+		{Name: "first_name", DataType: "varchar", ColumnType: "varchar(14)", Nullable: true},
+	}
+	nextDay := header
+	nextDay.BinlogMessageTime = time.Unix(1492070524, 0).Add(24 * time.Hour).UTC().Format(time.RFC3339)
+	data := parser.MessageRowData{Row: parser.MessageRow{"emp_no": int32(1), "first_name": "Max"}}
+
+	w := NewParquet(dir)
+	w.MaxFileSize = 40
+	messages := []parser.Message{
+		parser.NewInsertMessage(header, data),
+		parser.NewInsertMessage(header, data),
+		parser.NewQueryMessage(header, "DROP TABLE employees"),
+		parser.NewDeleteMessage(nextDay, data),
+	}
+	for _, message := range messages {
+		if output, err := w.Format(message); err != nil || output != nil {
+			t.Fatal(fmt.Sprintf("Unexpected output %s, %v", output, err))
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*", "*", "*", "*.parquet"))
+	for i := range files {
+		files[i], _ = filepath.Rel(dir, files[i])
+	}
+	expected := []string{
+		"test_db/employees/date=2017-04-13/part-0.parquet",
+		"test_db/employees/date=2017-04-13/part-1.parquet",
+		"test_db/employees/date=2017-04-14/part-0.parquet",
+	}
+	if !reflect.DeepEqual(files, expected) {
+		t.Fatal(fmt.Sprintf("Expected files %v, got %v", expected, files))
+	}
+
+	content, _ := ioutil.ReadFile(filepath.Join(dir, expected[0]))
+	footerLength := int(binary.LittleEndian.Uint32(content[len(content)-8:]))
+	footer := content[len(content)-8-footerLength : len(content)-8]
+	if !bytes.HasPrefix(content, parquetMagic) || !bytes.HasSuffix(content, parquetMagic) {
+		t.Fatal("Expected file to start and end with the magic")
+	}
+	for _, name := range []string{"_op", "_binlog_time", "_binlog_position", "_xid", "emp_no", "first_name"} {
+		if !bytes.Contains(footer, []byte(name)) {
+			t.Fatal(fmt.Sprintf("Expected column %s in footer", name))
+		}
+	}
+}
+
+func TestParquetRoundTrip(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "parquet")
+	defer os.RemoveAll(dir)
+
+	header := parser.NewMessageHeader("test_db", "employees", time.Unix(1492070524, 0), 635, 8)
+	header.Columns = []database.Column{
+		{Name: "emp_no", DataType: "int", ColumnType: "int(11)"},
+		{Name: "first_name", DataType: "varchar", ColumnType: "varchar(14)", Nullable: true},
+		{Name: "visits", DataType: "bigint", ColumnType: "bigint(20) unsigned"},
+		{Name: "score", DataType: "double", ColumnType: "double"},
+		{Name: "photo", DataType: "blob", ColumnType: "blob", Nullable: true},
+	}
+	maxRow := parser.MessageRowData{Row: parser.MessageRow{"emp_no": int32(1), "first_name": "Max", "visits": int64(-1), "score": 1.5, "photo": []byte{0xca, 0xfe}}}
+	moritzRow := parser.MessageRowData{Row: parser.MessageRow{"emp_no": int32(2), "first_name": nil, "visits": int64(7), "score": -2.25, "photo": nil}}
+
+	w := NewParquet(dir)
+	for _, message := range []parser.Message{
+		parser.NewInsertMessage(header, maxRow),
+		parser.NewUpdateMessage(header, maxRow, moritzRow),
+		parser.NewDeleteMessage(header, moritzRow),
+	} {
+		if _, err := w.Format(message); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	columns, rows := readParquet(t, filepath.Join(dir, "test_db/employees/date=2017-04-13/part-0.parquet"))
+	expectedColumns := []string{"_op", "_binlog_time", "_binlog_position", "_xid", "emp_no", "first_name", "visits", "score", "photo"}
+	if !reflect.DeepEqual(columns, expectedColumns) {
+		t.Fatal(fmt.Sprintf("Expected columns %v, got %v", expectedColumns, columns))
+	}
+	micros := int64(1492070524) * 1000000
+	expectedRows := [][]interface{}{
+		{"insert", micros, int64(635), int64(8), int64(1), "Max", int64(-1), 1.5, "\xca\xfe"},
+		{"update", micros, int64(635), int64(8), int64(2), nil, int64(7), -2.25, nil},
+		{"delete", micros, int64(635), int64(8), int64(2), nil, int64(7), -2.25, nil},
+	}
+	if !reflect.DeepEqual(rows, expectedRows) {
+		t.Fatal(fmt.Sprintf("Expected rows %v, got %v", expectedRows, rows))
+	}
+}
+
+// readParquet decodes the columns and rows of a file written by the Parquet
+// writer, plain encoded uncompressed data pages only
+func readParquet(t *testing.T, path string) ([]string, [][]interface{}) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(content, parquetMagic) || !bytes.HasSuffix(content, parquetMagic) {
+		t.Fatal("Expected file to start and end with the magic")
+	}
+	footerLength := int(binary.LittleEndian.Uint32(content[len(content)-8:]))
+	footer := &thriftReader{data: content[len(content)-8-footerLength : len(content)-8]}
+	meta := footer.readStruct()
+
+	var names []string
+	var schema []map[int16]interface{}
+	for _, element := range meta[2].([]interface{})[1:] {
+		field := element.(map[int16]interface{})
+		names = append(names, string(field[4].([]byte)))
+		schema = append(schema, field)
+	}
+
+	var rows [][]interface{}
+	for _, group := range meta[4].([]interface{}) {
+		group := group.(map[int16]interface{})
+		numRows := int(group[3].(int64))
+		groupRows := make([][]interface{}, numRows)
+		for i := range groupRows {
+			groupRows[i] = make([]interface{}, len(schema))
+		}
+		for i, chunk := range group[1].([]interface{}) {
+			chunkMeta := chunk.(map[int16]interface{})[3].(map[int16]interface{})
+			if codec := chunkMeta[4].(int64); codec != 0 {
+				t.Fatal(fmt.Sprintf("Expected uncompressed chunk, got codec %d", codec))
+			}
+			r := &thriftReader{data: content, pos: int(chunkMeta[9].(int64))}
+			pageHeader := r.readStruct()
+			page := content[r.pos : r.pos+int(pageHeader[3].(int64))]
+			if n := pageHeader[5].(map[int16]interface{})[1].(int64); int(n) != numRows {
+				t.Fatal(fmt.Sprintf("Expected %d values in page, got %d", numRows, n))
+			}
+
+			levels := bytes.Repeat([]byte{1}, numRows)
+			if schema[i][3].(int64) == parquetOptional {
+				length := int(binary.LittleEndian.Uint32(page))
+				levels = readRLELevels(t, page[4:4+length])
+				page = page[4+length:]
+			}
+			values := bytes.NewReader(page)
+			for row, level := range levels {
+				if level == 0 {
+					continue
+				}
+				groupRows[row][i] = readPlainValue(t, values, schema[i][1].(int64))
+			}
+			if values.Len() != 0 {
+				t.Fatal(fmt.Sprintf("Expected all values of %s to be read, %d bytes left", names[i], values.Len()))
+			}
+		}
+		rows = append(rows, groupRows...)
+	}
+	if int(meta[3].(int64)) != len(rows) {
+		t.Fatal(fmt.Sprintf("Expected %d rows, got %d", meta[3], len(rows)))
+	}
+	return names, rows
+}
+
+// readPlainValue reads a plain encoded value, byte arrays as string
+func readPlainValue(t *testing.T, r *bytes.Reader, physical int64) interface{} {
+	var err error
+	var value interface{}
+	switch physical {
+	case parquetInt32:
+		var v int32
+		err = binary.Read(r, binary.LittleEndian, &v)
+		value = int64(v)
+	case parquetInt64:
+		var v int64
+		err = binary.Read(r, binary.LittleEndian, &v)
+		value = v
+	case parquetDouble:
+		var v float64
+		err = binary.Read(r, binary.LittleEndian, &v)
+		value = v
+	case parquetByteArray:
+		var length uint32
+		if err = binary.Read(r, binary.LittleEndian, &length); err == nil {
+			v := make([]byte, length)
+			_, err = io.ReadFull(r, v)
+			value = string(v)
+		}
+	default:
+		t.Fatal(fmt.Sprintf("Unexpected physical type %d", physical))
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return value
+}
+
+// readRLELevels decodes definition levels of bit width one written as runs
+func readRLELevels(t *testing.T, data []byte) []byte {
+	var levels []byte
+	for len(data) > 0 {
+		header, n := binary.Uvarint(data)
+		if n <= 0 || header&1 != 0 || n >= len(data) {
+			t.Fatal(fmt.Sprintf("Expected an RLE run, got %x", data))
+		}
+		levels = append(levels, bytes.Repeat([]byte{data[n]}, int(header>>1))...)
+		data = data[n+1:]
+	}
+	return levels
+}
+
+// thriftReader decodes structs of the thrift compact protocol into maps by
+// field id
+type thriftReader struct {
+	data []byte
+	pos  int
+}
+
+func (r *thriftReader) uvarint() uint64 {
+	n, size := binary.Uvarint(r.data[r.pos:])
+	r.pos += size
+	return n
+}
+
+func (r *thriftReader) varint() int64 {
+	n, size := binary.Varint(r.data[r.pos:])
+	r.pos += size
+	return n
+}
+
+func (r *thriftReader) readStruct() map[int16]interface{} {
+	fields := map[int16]interface{}{}
+	var last int16
+	for {
+		b := r.data[r.pos]
+		r.pos++
+		if b == 0 {
+			return fields
+		}
+		id := last + int16(b>>4)
+		if b>>4 == 0 {
+			id = int16(r.varint())
+		}
+		fields[id] = r.readValue(b & 0x0f)
+		last = id
+	}
+}
+
+func (r *thriftReader) readValue(valueType byte) interface{} {
+	switch valueType {
+	case thriftI32, thriftI64:
+		return r.varint()
+	case thriftBinary:
+		length := int(r.uvarint())
+		r.pos += length
+		return r.data[r.pos-length : r.pos]
+	case thriftList:
+		b := r.data[r.pos]
+		r.pos++
+		size := int(b >> 4)
+		if size == 15 {
+			size = int(r.uvarint())
+		}
+		list := make([]interface{}, size)
+		for i := range list {
+			list[i] = r.readValue(b & 0x0f)
+		}
+		return list
+	case thriftStruct:
+		return r.readStruct()
+	}
+	panic(fmt.Sprintf("unexpected thrift type %d", valueType))
+}
+
+func TestRLELevels(t *testing.T) {
+	actual := rleLevels([]byte{1, 1, 1, 0, 1})
+	expected := []byte{0x06, 0x01, 0x02, 0x00, 0x02, 0x01}
+	if !bytes.Equal(actual, expected) {
+		t.Fatal(fmt.Sprintf("Expected %x, got %x", expected, actual))
+	}
+}
+
+func TestThriftWriter(t *testing.T) {
+	var w thriftWriter
+	w.beginStruct()
+	w.i32Field(1, 1)
+	w.binaryField(4, "a")
+	w.structField(20)
+	w.i64Field(1, -1)
+	w.endStruct()
+	w.listField(21, thriftI32, 1)
+	w.varint(2)
+	w.endStruct()
+
+	expected := []byte{0x15, 0x02, 0x38, 0x01, 'a', 0x0c, 0x28, 0x16, 0x01, 0x00, 0x19, 0x15, 0x04, 0x00}
+	if !bytes.Equal(w.buf.Bytes(), expected) {
+		t.Fatal(fmt.Sprintf("Expected %x, got %x", expected, w.buf.Bytes()))
+	}
+}
@@ -0,0 +1,507 @@
+package format
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"sort"
+
+	"github.com/tanema/binlog-parser/src/database"
+	"github.com/tanema/binlog-parser/src/parser"
+)
+
+// protobuf wire types
+const (
+	protoVarint  = 0
+	protoFixed64 = 1
+	protoBytes   = 2
+	protoFixed32 = 5
+)
+
+var errInvalidProtobuf = errors.New("invalid protobuf message")
+
+// protobufMaxMessageSize is the largest message ProtobufDecoder reads, a
+// message holds the rows of a single binlog event, which is at most 1 GiB
+const protobufMaxMessageSize = 1 << 30
+
+// Protobuf renders messages in the protocol buffer encoding of binlog.proto,
+// each prefixed with its varint encoded length. Column values are written
+// with their Go type, values of other types like decimals as string. Values
+// of unsigned integer columns are written as unsigned, of text and JSON
+// columns as string.
+type Protobuf struct{}
+
+// Format renders the message as length delimited protobuf Message
+func (f Protobuf) Format(message parser.Message) ([]byte, error) {
+	var m protoBuffer
+	switch msg := message.(type) {
+	case parser.InsertMessage:
+		m.message(1, func(b *protoBuffer) {
+			b.message(1, func(b *protoBuffer) { b.header(msg.Header) })
+			b.message(2, func(b *protoBuffer) { b.rowData(msg.Header, msg.Data) })
+		})
+	case parser.UpdateMessage:
+		m.message(2, func(b *protoBuffer) {
+			b.message(1, func(b *protoBuffer) { b.header(msg.Header) })
+			b.message(2, func(b *protoBuffer) { b.rowData(msg.Header, msg.OldData) })
+			b.message(3, func(b *protoBuffer) { b.rowData(msg.Header, msg.NewData) })
+		})
+	case parser.DeleteMessage:
+		m.message(3, func(b *protoBuffer) {
+			b.message(1, func(b *protoBuffer) { b.header(msg.Header) })
+			b.message(2, func(b *protoBuffer) { b.rowData(msg.Header, msg.Data) })
+		})
+	case parser.QueryMessage:
+		m.message(4, func(b *protoBuffer) {
+			b.message(1, func(b *protoBuffer) { b.header(msg.Header) })
+			b.string(2, string(msg.Query))
+			if msg.DDL != nil {
+				b.message(3, func(b *protoBuffer) { b.ddl(*msg.DDL) })
+			}
+		})
+	default:
+		return nil, fmt.Errorf("unknown message type %T", message)
+	}
+
+	var out protoBuffer
+	out.uvarint(uint64(m.Len()))
+	out.Write(m.Bytes())
+	return out.Bytes(), nil
+}
+
+// Binary marks the output as binary, messages are framed by their length
+func (f Protobuf) Binary() {}
+
+// protoBuffer encodes protobuf fields, fields with the default value are left
+// out like proto3 does
+type protoBuffer struct {
+	bytes.Buffer
+}
+
+func (b *protoBuffer) uvarint(v uint64) {
+	var buf [binary.MaxVarintLen64]byte
+	b.Write(buf[:binary.PutUvarint(buf[:], v)])
+}
+
+func (b *protoBuffer) tag(num int, wireType int) {
+	b.uvarint(uint64(num)<<3 | uint64(wireType))
+}
+
+func (b *protoBuffer) varint(num int, v uint64) {
+	if v != 0 {
+		b.tag(num, protoVarint)
+		b.uvarint(v)
+	}
+}
+
+func (b *protoBuffer) string(num int, s string) {
+	if s != "" {
+		b.bytes(num, []byte(s))
+	}
+}
+
+func (b *protoBuffer) bytes(num int, data []byte) {
+	b.tag(num, protoBytes)
+	b.uvarint(uint64(len(data)))
+	b.Write(data)
+}
+
+func (b *protoBuffer) message(num int, encode func(*protoBuffer)) {
+	var m protoBuffer
+	encode(&m)
+	b.bytes(num, m.Bytes())
+}
+
+func (b *protoBuffer) header(header parser.MessageHeader) {
+	b.string(1, header.Schema)
+	b.string(2, header.Table)
+	b.string(3, header.BinlogMessageTime)
+	b.varint(4, uint64(header.BinlogPosition))
+	b.varint(5, header.XID)
+	b.string(6, string(header.RowsQuery))
+	b.varint(7, uint64(header.SchemaVersion))
+	if header.Event != nil {
+		event := header.Event
+		b.message(8, func(b *protoBuffer) {
+			b.varint(1, uint64(event.ServerID))
+			b.varint(2, uint64(event.StartPosition))
+			b.varint(3, uint64(event.EventSize))
+			b.string(4, event.EventType)
+			b.string(5, event.BinlogFile)
+			b.varint(6, uint64(event.RowIndex))
+			b.string(7, event.GTID)
+			if event.Compressed {
+				b.varint(8, 1)
+			}
+			b.varint(9, uint64(event.UncompressedSize))
+			b.string(10, event.RelayLogFile)
+			b.varint(11, uint64(event.RelayLogPosition))
+		})
+	}
+}
+
+func (b *protoBuffer) rowData(header parser.MessageHeader, data parser.MessageRowData) {
+	columns := make(map[string]database.Column, len(header.Columns))
+	for _, column := range header.Columns {
+		columns[column.Name] = column
+	}
+	names := make([]string, 0, len(data.Row))
+	for name := range data.Row {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		column := columns[name]
+		value := NormalizeValue(column, data.Row[name])
+		if b, ok := value.([]byte); ok && column.DataType != "" && !IsBinaryType(column.DataType) {
+			// text columns are strings whatever their size
+			value = string(b)
+		}
+		b.message(1, func(b *protoBuffer) {
+			b.string(1, name)
+			b.message(2, func(b *protoBuffer) { b.value(value) })
+		})
+	}
+	b.string(2, data.MappingNotice)
+}
+
+// value writes the field of the Value oneof for the Go type, nothing is
+// written for NULL
+func (b *protoBuffer) value(value interface{}) {
+	var signed int64
+	switch v := value.(type) {
+	case nil:
+		return
+	case int8:
+		signed = int64(v)
+	case int16:
+		signed = int64(v)
+	case int32:
+		signed = int64(v)
+	case int64:
+		signed = v
+	case int:
+		signed = int64(v)
+	case uint8:
+		b.tag(2, protoVarint)
+		b.uvarint(uint64(v))
+		return
+	case uint16:
+		b.tag(2, protoVarint)
+		b.uvarint(uint64(v))
+		return
+	case uint32:
+		b.tag(2, protoVarint)
+		b.uvarint(uint64(v))
+		return
+	case uint64:
+		b.tag(2, protoVarint)
+		b.uvarint(v)
+		return
+	case float32:
+		b.tag(3, protoFixed32)
+		binary.Write(b, binary.LittleEndian, math.Float32bits(v))
+		return
+	case float64:
+		b.tag(4, protoFixed64)
+		binary.Write(b, binary.LittleEndian, math.Float64bits(v))
+		return
+	case string:
+		b.bytes(5, []byte(v))
+		return
+	case []byte:
+		b.bytes(6, v)
+		return
+	default:
+		b.bytes(5, []byte(fmt.Sprint(v)))
+		return
+	}
+	b.tag(1, protoVarint)
+	b.uvarint(uint64(signed<<1) ^ uint64(signed>>63))
+}
+
+func (b *protoBuffer) ddl(ddl parser.DDLStatement) {
+	b.string(1, string(ddl.Kind))
+	for _, schema := range ddl.Schemas {
+		b.bytes(2, []byte(schema))
+	}
+	for _, table := range ddl.Tables {
+		b.message(3, func(b *protoBuffer) {
+			b.string(1, table.Schema)
+			b.string(2, table.Table)
+		})
+	}
+	for num, columns := range [][]string{ddl.AddedColumns, ddl.DroppedColumns, ddl.ModifiedColumns} {
+		for _, column := range columns {
+			b.bytes(num+4, []byte(column))
+		}
+	}
+	for _, rename := range ddl.RenamedColumns {
+		b.message(7, func(b *protoBuffer) {
+			b.string(1, rename.From)
+			b.string(2, rename.To)
+		})
+	}
+}
+
+// ProtobufDecoder reads the messages written by the protobuf format. Signed
+// integers are decoded as int64 and unsigned ones as uint64.
+type ProtobufDecoder struct {
+	r *bufio.Reader
+}
+
+// NewProtobufDecoder creates a decoder reading from r
+func NewProtobufDecoder(r io.Reader) *ProtobufDecoder {
+	return &ProtobufDecoder{r: bufio.NewReader(r)}
+}
+
+// Decode reads the next message, io.EOF is returned at the end of the stream
+func (d *ProtobufDecoder) Decode() (parser.Message, error) {
+	size, err := binary.ReadUvarint(d.r)
+	if err != nil {
+		return nil, err
+	}
+	if size > protobufMaxMessageSize {
+		return nil, fmt.Errorf("protobuf message size %d exceeds %d bytes", size, protobufMaxMessageSize)
+	}
+	// the buffer grows with the data read, a corrupt size fails at the end of
+	// the stream without taking the memory up front
+	data, err := ioutil.ReadAll(io.LimitReader(d.r, int64(size)))
+	if err != nil {
+		return nil, err
+	}
+	if uint64(len(data)) < size {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	var message parser.Message
+	err = protoFields(data, func(num int, _ uint64, data []byte) error {
+		var header parser.MessageHeader
+		var rows [2]parser.MessageRowData
+		var query parser.QueryMessage
+		err := protoFields(data, func(field int, _ uint64, data []byte) error {
+			var err error
+			switch {
+			case field == 1:
+				header, err = decodeProtoHeader(data)
+			case num == 4 && field == 2:
+				query.Query = parser.SQLQuery(data)
+			case num == 4 && field == 3:
+				query.DDL, err = decodeProtoDDL(data)
+			case field == 2 || field == 3:
+				rows[field-2], err = decodeProtoRowData(data)
+			}
+			return err
+		})
+		switch num {
+		case 1:
+			message = parser.NewInsertMessage(header, rows[0])
+		case 2:
+			message = parser.NewUpdateMessage(header, rows[0], rows[1])
+		case 3:
+			message = parser.NewDeleteMessage(header, rows[0])
+		case 4:
+			ddl := query.DDL
+			query = parser.NewQueryMessage(header, query.Query)
+			query.DDL = ddl
+			message = query
+		}
+		return err
+	})
+	if err == nil && message == nil {
+		err = errInvalidProtobuf
+	}
+	return message, err
+}
+
+func decodeProtoHeader(data []byte) (parser.MessageHeader, error) {
+	var header parser.MessageHeader
+	err := protoFields(data, func(num int, v uint64, data []byte) error {
+		switch num {
+		case 1:
+			header.Schema = string(data)
+		case 2:
+			header.Table = string(data)
+		case 3:
+			header.BinlogMessageTime = string(data)
+		case 4:
+			header.BinlogPosition = uint32(v)
+		case 5:
+			header.XID = v
+		case 6:
+			header.RowsQuery = parser.SQLQuery(data)
+		case 7:
+			header.SchemaVersion = int(v)
+		case 8:
+			header.Event = &parser.EventMetadata{}
+			return protoFields(data, func(num int, v uint64, data []byte) error {
+				switch num {
+				case 1:
+					header.Event.ServerID = uint32(v)
+				case 2:
+					header.Event.StartPosition = uint32(v)
+				case 3:
+					header.Event.EventSize = uint32(v)
+				case 4:
+					header.Event.EventType = string(data)
+				case 5:
+					header.Event.BinlogFile = string(data)
+				case 6:
+					header.Event.RowIndex = int(v)
+				case 7:
+					header.Event.GTID = string(data)
+				case 8:
+					header.Event.Compressed = v != 0
+				case 9:
+					header.Event.UncompressedSize = uint32(v)
+				case 10:
+					header.Event.RelayLogFile = string(data)
+				case 11:
+					header.Event.RelayLogPosition = uint32(v)
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+	return header, err
+}
+
+func decodeProtoRowData(data []byte) (parser.MessageRowData, error) {
+	rowData := parser.MessageRowData{Row: parser.MessageRow{}}
+	err := protoFields(data, func(num int, _ uint64, data []byte) error {
+		if num == 2 {
+			rowData.MappingNotice = string(data)
+			return nil
+		}
+		if num != 1 {
+			return nil
+		}
+		var name string
+		var value interface{}
+		err := protoFields(data, func(num int, _ uint64, data []byte) error {
+			var err error
+			switch num {
+			case 1:
+				name = string(data)
+			case 2:
+				value, err = decodeProtoValue(data)
+			}
+			return err
+		})
+		rowData.Row[name] = value
+		return err
+	})
+	return rowData, err
+}
+
+func decodeProtoValue(data []byte) (interface{}, error) {
+	var value interface{}
+	err := protoFields(data, func(num int, v uint64, data []byte) error {
+		switch num {
+		case 1:
+			value = int64(v>>1) ^ -int64(v&1)
+		case 2:
+			value = v
+		case 3:
+			value = math.Float32frombits(uint32(v))
+		case 4:
+			value = math.Float64frombits(v)
+		case 5:
+			value = string(data)
+		case 6:
+			value = append([]byte{}, data...)
+		}
+		return nil
+	})
+	return value, err
+}
+
+func decodeProtoDDL(data []byte) (*parser.DDLStatement, error) {
+	ddl := &parser.DDLStatement{}
+	err := protoFields(data, func(num int, _ uint64, data []byte) error {
+		switch num {
+		case 1:
+			ddl.Kind = parser.DDLKind(data)
+		case 2:
+			ddl.Schemas = append(ddl.Schemas, string(data))
+		case 3:
+			ddl.Tables = append(ddl.Tables, parser.TableName{})
+			return protoFields(data, func(num int, _ uint64, data []byte) error {
+				if num == 1 {
+					ddl.Tables[len(ddl.Tables)-1].Schema = string(data)
+				} else if num == 2 {
+					ddl.Tables[len(ddl.Tables)-1].Table = string(data)
+				}
+				return nil
+			})
+		case 4:
+			ddl.AddedColumns = append(ddl.AddedColumns, string(data))
+		case 5:
+			ddl.DroppedColumns = append(ddl.DroppedColumns, string(data))
+		case 6:
+			ddl.ModifiedColumns = append(ddl.ModifiedColumns, string(data))
+		case 7:
+			ddl.RenamedColumns = append(ddl.RenamedColumns, parser.ColumnRename{})
+			return protoFields(data, func(num int, _ uint64, data []byte) error {
+				if num == 1 {
+					ddl.RenamedColumns[len(ddl.RenamedColumns)-1].From = string(data)
+				} else if num == 2 {
+					ddl.RenamedColumns[len(ddl.RenamedColumns)-1].To = string(data)
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+	return ddl, err
+}
+
+// protoFields calls fn for every field of the encoded message with the number
+// of the field and either its numeric value or its bytes
+func protoFields(data []byte, fn func(num int, v uint64, data []byte) error) error {
+	for len(data) > 0 {
+		tag, n := binary.Uvarint(data)
+		if n <= 0 {
+			return errInvalidProtobuf
+		}
+		data = data[n:]
+
+		var v uint64
+		var field []byte
+		switch tag & 7 {
+		case protoVarint:
+			if v, n = binary.Uvarint(data); n <= 0 {
+				return errInvalidProtobuf
+			}
+			data = data[n:]
+		case protoFixed64:
+			if len(data) < 8 {
+				return errInvalidProtobuf
+			}
+			v, data = binary.LittleEndian.Uint64(data), data[8:]
+		case protoFixed32:
+			if len(data) < 4 {
+				return errInvalidProtobuf
+			}
+			v, data = uint64(binary.LittleEndian.Uint32(data)), data[4:]
+		case protoBytes:
+			size, n := binary.Uvarint(data)
+			if n <= 0 || uint64(len(data)-n) < size {
+				return errInvalidProtobuf
+			}
+			field, data = data[n:n+int(size)], data[n+int(size):]
+		default:
+			return errInvalidProtobuf
+		}
+		if err := fn(int(tag>>3), v, field); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+package format
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/tanema/binlog-parser/src/database"
+	"github.com/tanema/binlog-parser/src/parser"
+)
+
+func TestProtobufRoundTrip(t *testing.T) {
+	header := parser.NewMessageHeader("test_db", "employees", time.Unix(1492070524, 0), 635, 8)
+	header.RowsQuery = "update employees set first_name = 'Max'"
+	header.SchemaVersion = parser.SchemaVersion2
+	header.Event = &parser.EventMetadata{ServerID: 1, StartPosition: 563, EventSize: 72, EventType: "UpdateRowsEventV2", BinlogFile: "mysql-bin.000001", RowIndex: 1}
+	oldData := parser.MessageRowData{Row: parser.MessageRow{
+		"emp_no":     int64(-1),
+		"salary":     uint64(1 << 63),
+		"rating":     float32(1.5),
+		"score":      float64(2.25),
+		"first_name": "Max",
+		"photo":      []byte{0xca, 0xfe},
+		"last_name":  nil,
+	}}
+	newData := parser.MessageRowData{Row: parser.MessageRow{"emp_no": int64(0)}, MappingNotice: "column names array is missing field(s), will map them as unknown_*"}
+	query := parser.NewQueryMessage(parser.NewMessageHeader("test_db", "employees", time.Unix(1492070524, 0), 635, 0), "ALTER TABLE employees RENAME COLUMN a TO b")
+	query.DDL = &parser.DDLStatement{
+		Kind:           parser.DDLAlterTable,
+		Schemas:        []string{"test_db"},
+		Tables:         []parser.TableName{{Schema: "test_db", Table: "employees"}},
+		RenamedColumns: []parser.ColumnRename{{From: "a", To: "b"}},
+	}
+
+	messages := []parser.Message{
+		parser.NewInsertMessage(header, oldData),
+		parser.NewUpdateMessage(header, oldData, newData),
+		parser.NewDeleteMessage(header, newData),
+		query,
+	}
+	var stream bytes.Buffer
+	for _, message := range messages {
+		data, err := Protobuf{}.Format(message)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stream.Write(data)
+	}
+
+	decoder := NewProtobufDecoder(&stream)
+	for _, expected := range messages {
+		actual, err := decoder.Decode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(actual, expected) {
+			t.Fatal(fmt.Sprintf("Expected %+v, got %+v", expected, actual))
+		}
+	}
+	if _, err := decoder.Decode(); err != io.EOF {
+		t.Fatal(fmt.Sprintf("Expected EOF, got %v", err))
+	}
+}
+
+func TestProtobufEventMetadata(t *testing.T) {
+	testCases := []parser.EventMetadata{
+		{ServerID: 1, StartPosition: 120, EventSize: 280, EventType: "TransactionPayloadEvent", BinlogFile: "mysql-bin.000008", GTID: "3e11fa47-71ca-11e1-9e33-c80aa9429562:23", Compressed: true, UncompressedSize: 512},
+		{ServerID: 2, StartPosition: 563, EventSize: 72, EventType: "WriteRowsEventV2", BinlogFile: "mysql-bin.000001", RowIndex: 2, RelayLogFile: "relay-bin.000003", RelayLogPosition: 1024},
+	}
+	for _, tc := range testCases {
+		event := tc
+		header := parser.NewMessageHeader("test_db", "employees", time.Unix(1492070524, 0), 635, 8)
+		header.SchemaVersion = parser.SchemaVersion2
+		header.Event = &event
+		expected := parser.NewInsertMessage(header, parser.MessageRowData{Row: parser.MessageRow{"emp_no": int64(1)}})
+		data, err := Protobuf{}.Format(expected)
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual, err := NewProtobufDecoder(bytes.NewReader(data)).Decode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(actual.GetHeader().Event, &tc) {
+			t.Fatal(fmt.Sprintf("Expected %+v, got %+v", tc, actual.GetHeader().Event))
+		}
+	}
+}
+
+func TestProtobufValues(t *testing.T) {
+	testCases := []struct {
+		value    interface{}
+		expected []byte
+	}{
+		{nil, []byte{}},
+		{int32(-1), []byte{0x08, 0x01}},
+		{int8(1), []byte{0x08, 0x02}},
+		{uint64(1), []byte{0x10, 0x01}},
+		{float32(1.5), []byte{0x1d, 0x00, 0x00, 0xc0, 0x3f}},
+		{"ab", []byte{0x2a, 0x02, 'a', 'b'}},
+		{[]byte{0xff}, []byte{0x32, 0x01, 0xff}},
+	}
+	for _, tc := range testCases {
+		var b protoBuffer
+		b.value(tc.value)
+		if !bytes.Equal(b.Bytes(), tc.expected) {
+			t.Fatal(fmt.Sprintf("Wrong encoding of %v, expected %x got %x", tc.value, tc.expected, b.Bytes()))
+		}
+	}
+}
+
+func TestProtobufUnsignedColumns(t *testing.T) {
+	header := parser.NewMessageHeader("test_db", "employees", time.Unix(1492070524, 0), 635, 8)
+	header.Columns = []database.Column{
+		{Name: "emp_no", DataType: "int", ColumnType: "int(10) unsigned"},
+		{Name: "visits", DataType: "bigint", ColumnType: "bigint(20) unsigned"},
+		{Name: "balance", DataType: "bigint", ColumnType: "bigint(20)"},
+	}
+	row := parser.MessageRow{"emp_no": int32(-1), "visits": int64(-1), "balance": int64(-1)}
+	data, _ := Protobuf{}.Format(parser.NewInsertMessage(header, parser.MessageRowData{Row: row}))
+	message, err := NewProtobufDecoder(bytes.NewReader(data)).Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := parser.MessageRow{"emp_no": uint64(math.MaxUint32), "visits": uint64(math.MaxUint64), "balance": int64(-1)}
+	if actual := message.(parser.InsertMessage).Data.Row; !reflect.DeepEqual(actual, expected) {
+		t.Fatal(fmt.Sprintf("Expected %v, got %v", expected, actual))
+	}
+}
+
+func TestProtobufTextColumns(t *testing.T) {
+	header := parser.NewMessageHeader("test_db", "notes", time.Unix(1492070524, 0), 635, 8)
+	header.Columns = []database.Column{
+		{Name: "title", DataType: "varchar", ColumnType: "varchar(255)"},
+		{Name: "body", DataType: "text", ColumnType: "text"},
+		{Name: "doc", DataType: "json", ColumnType: "json"},
+		{Name: "raw", DataType: "blob", ColumnType: "blob"},
+	}
+	row := parser.MessageRow{"title": "hi", "body": []byte("hello"), "doc": []byte(`{"a":1}`), "raw": []byte{0xff}}
+	data, _ := Protobuf{}.Format(parser.NewInsertMessage(header, parser.MessageRowData{Row: row}))
+	message, err := NewProtobufDecoder(bytes.NewReader(data)).Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := parser.MessageRow{"title": "hi", "body": "hello", "doc": `{"a":1}`, "raw": []byte{0xff}}
+	if actual := message.(parser.InsertMessage).Data.Row; !reflect.DeepEqual(actual, expected) {
+		t.Fatal(fmt.Sprintf("Expected %v, got %v", expected, actual))
+	}
+}
+
+func TestProtobufDecoderTruncated(t *testing.T) {
+	data, _ := Protobuf{}.Format(parser.NewQueryMessage(parser.MessageHeader{}, "BEGIN"))
+	_, err := NewProtobufDecoder(bytes.NewReader(data[:len(data)-1])).Decode()
+	if err != io.ErrUnexpectedEOF {
+		t.Fatal(fmt.Sprintf("Expected unexpected EOF, got %v", err))
+	}
+
+	sizes := [][]byte{
+		{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01},
+		binary.AppendUvarint(nil, protobufMaxMessageSize),
+	}
+	for _, size := range sizes {
+		if _, err := NewProtobufDecoder(bytes.NewReader(size)).Decode(); err == nil {
+			t.Fatal(fmt.Sprintf("Expected error for the size %x", size))
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package format
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/tanema/binlog-parser/src/parser"
+)
+
+// SQL renders row messages as the equivalent INSERT, UPDATE and DELETE
+// statements, grouped into one BEGIN/COMMIT block per transaction. DDL query
+// messages are written as they are, other queries are skipped.
+type SQL struct {
+	inTransaction bool
+}
+
+// NewSQL creates a SQL formatter
+func NewSQL() *SQL {
+	return &SQL{}
+}
+
+// Format renders the statement of a message, opening a transaction block for
+// the first row of a transaction
+func (f *SQL) Format(message parser.Message) ([]byte, error) {
+	header := message.GetHeader()
+	var statement string
+	var err error
+	switch m := message.(type) {
+	case parser.InsertMessage:
+		statement, err = insertStatement(header, m.Data)
+	case parser.UpdateMessage:
+		statement, err = updateStatement(header, m.OldData, m.NewData)
+	case parser.DeleteMessage:
+		statement, err = deleteStatement(header, m.Data)
+	case parser.QueryMessage:
+		if m.DDL == nil {
+			return nil, nil
+		}
+		return []byte(ddlStatement(m)), nil
+	default:
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !f.inTransaction {
+		f.inTransaction = true
+		statement = fmt.Sprintf("BEGIN; /* xid %d */\n%s", header.XID, statement)
+	}
+	return []byte(statement), nil
+}
+
+// Commit closes the transaction block
+func (f *SQL) Commit(xid uint64) ([]byte, error) {
+	if !f.inTransaction {
+		return nil, nil
+	}
+	f.inTransaction = false
+	return []byte("COMMIT;"), nil
+}
+
+// ddlStatement renders the DDL query in the default database of the session
+// that ran it, which unqualified names in the query resolve against
+func ddlStatement(message parser.QueryMessage) string {
+	query := strings.TrimRight(strings.TrimSpace(string(message.Query)), ";")
+	if message.Database == "" {
+		return query + ";"
+	}
+	return fmt.Sprintf("USE %s;\n%s;", quoteIdentifier(message.Database), query)
+}
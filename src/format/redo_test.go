@@ -0,0 +1,74 @@
+package format
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/tanema/binlog-parser/src/database"
+	"github.com/tanema/binlog-parser/src/parser"
+)
+
+func TestSQLFormat(t *testing.T) {
+	header := parser.NewMessageHeader("test_db", "employees", time.Now(), 100, 8)
+	header.Columns = []database.Column{
+		{Name: "emp_no", DataType: "int", ColumnType: "int(11)", PrimaryKey: true},
+		{Name: "first_name", DataType: "varchar", ColumnType: "varchar(14)"},
+	}
+	maxRow := parser.MessageRowData{Row: parser.MessageRow{"emp_no": int32(1), "first_name": "Max"}}
+	moritzRow := parser.MessageRowData{Row: parser.MessageRow{"emp_no": int32(1), "first_name": "Moritz"}}
+
+	formatter := NewSQL()
+	var output []string
+	for _, message := range []parser.Message{
+		parser.NewInsertMessage(header, maxRow),
+		parser.NewUpdateMessage(header, maxRow, moritzRow),
+		parser.NewDeleteMessage(header, moritzRow),
+	} {
+		data, err := formatter.Format(message)
+		if err != nil {
+			t.Fatal(err)
+		}
+		output = append(output, string(data))
+	}
+	data, _ := formatter.Commit(8)
+	output = append(output, string(data))
+
+	expected := []string{
+		"BEGIN; /* xid 8 */\nINSERT INTO `test_db`.`employees` (`emp_no`, `first_name`) VALUES (1, 'Max');",
+		"UPDATE `test_db`.`employees` SET `emp_no`=1, `first_name`='Moritz' WHERE `emp_no`=1;",
+		"DELETE FROM `test_db`.`employees` WHERE `emp_no`=1;",
+		"COMMIT;",
+	}
+	if fmt.Sprint(output) != fmt.Sprint(expected) {
+		t.Fatal(fmt.Sprintf("Wrong SQL\nexpected %q\ngot      %q", expected, output))
+	}
+
+	if data, _ := formatter.Commit(9); data != nil {
+		t.Fatal("Expected nothing to commit for empty transaction")
+	}
+
+	t.Run("DDL", func(t *testing.T) {
+		query := parser.NewQueryMessage(header, "ALTER TABLE employees ADD last_name VARCHAR(16);")
+		query.DDL, _ = parser.ParseDDL(string(query.Query), "test_db")
+		query.Database = "test_db"
+		data, _ := formatter.Format(query)
+		expected := "USE `test_db`;\nALTER TABLE employees ADD last_name VARCHAR(16);"
+		if string(data) != expected {
+			t.Fatal(fmt.Sprintf("Expected %s, got %s", expected, data))
+		}
+
+		rename := parser.NewQueryMessage(parser.NewMessageHeader("other_db", "employees", time.Now(), 100, 0), "RENAME TABLE other_db.employees TO staff")
+		rename.DDL, _ = parser.ParseDDL(string(rename.Query), "test_db")
+		rename.Database = "test_db"
+		data, _ = formatter.Format(rename)
+		expected = "USE `test_db`;\nRENAME TABLE other_db.employees TO staff;"
+		if string(data) != expected {
+			t.Fatal(fmt.Sprintf("Expected the default database of the session, got %s", data))
+		}
+
+		if data, _ := formatter.Format(parser.NewQueryMessage(header, "FLUSH TABLES")); data != nil {
+			t.Fatal("Expected non DDL query to be skipped")
+		}
+	})
+}
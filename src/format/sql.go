@@ -0,0 +1,175 @@
+package format
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/tanema/binlog-parser/src/database"
+	"github.com/tanema/binlog-parser/src/parser"
+)
+
+// insertStatement renders an INSERT of the row into the message's table
+func insertStatement(header parser.MessageHeader, data parser.MessageRowData) (string, error) {
+	columns, err := rowColumns(header, data)
+	if err != nil {
+		return "", err
+	}
+	names := make([]string, len(columns))
+	values := make([]string, len(columns))
+	for i, column := range columns {
+		names[i] = quoteIdentifier(column.Name)
+		values[i] = sqlLiteral(column, data.Row[column.Name])
+	}
+	return fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s);", qualifiedTable(header), strings.Join(names, ", "), strings.Join(values, ", ")), nil
+}
+
+// deleteStatement renders a DELETE of the row from the message's table
+func deleteStatement(header parser.MessageHeader, data parser.MessageRowData) (string, error) {
+	where, limit, err := whereClause(header, data)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("DELETE FROM %s WHERE %s%s;", qualifiedTable(header), where, limit), nil
+}
+
+// updateStatement renders an UPDATE that changes the row identified by the
+// before image to the after image
+func updateStatement(header parser.MessageHeader, before, after parser.MessageRowData) (string, error) {
+	columns, err := rowColumns(header, after)
+	if err != nil {
+		return "", err
+	}
+	where, limit, err := whereClause(header, before)
+	if err != nil {
+		return "", err
+	}
+	assignments := make([]string, len(columns))
+	for i, column := range columns {
+		assignments[i] = fmt.Sprintf("%s=%s", quoteIdentifier(column.Name), sqlLiteral(column, after.Row[column.Name]))
+	}
+	return fmt.Sprintf("UPDATE %s SET %s WHERE %s%s;", qualifiedTable(header), strings.Join(assignments, ", "), where, limit), nil
+}
+
+// whereClause identifies the row by its primary key when it is known and by
+// all of its columns otherwise, in which case the statement is limited to a
+// single row
+func whereClause(header parser.MessageHeader, data parser.MessageRowData) (string, string, error) {
+	columns, err := rowColumns(header, data)
+	if err != nil {
+		return "", "", err
+	}
+	var keyColumns []database.Column
+	for _, column := range columns {
+		if column.PrimaryKey {
+			keyColumns = append(keyColumns, column)
+		}
+	}
+	limit := ""
+	if len(keyColumns) == 0 {
+		keyColumns = columns
+		limit = " LIMIT 1"
+	}
+
+	conditions := make([]string, len(keyColumns))
+	for i, column := range keyColumns {
+		value := data.Row[column.Name]
+		if value == nil {
+			conditions[i] = fmt.Sprintf("%s IS NULL", quoteIdentifier(column.Name))
+		} else {
+			conditions[i] = fmt.Sprintf("%s=%s", quoteIdentifier(column.Name), sqlLiteral(column, value))
+		}
+	}
+	return strings.Join(conditions, " AND "), limit, nil
+}
+
+// rowColumns returns the columns of the row in table order. Rows that could
+// not be mapped to the table columns can't be rendered as SQL.
+func rowColumns(header parser.MessageHeader, data parser.MessageRowData) ([]database.Column, error) {
+	if data.MappingNotice != "" {
+		return nil, fmt.Errorf("can not render SQL for %s: %s", qualifiedTable(header), data.MappingNotice)
+	}
+	if len(header.Columns) > 0 {
+		return header.Columns, nil
+	}
+	names := make([]string, 0, len(data.Row))
+	for name := range data.Row {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	columns := make([]database.Column, len(names))
+	for i, name := range names {
+		columns[i] = database.Column{Name: name}
+	}
+	return columns, nil
+}
+
+func qualifiedTable(header parser.MessageHeader) string {
+	if header.Schema == "" {
+		return quoteIdentifier(header.Table)
+	}
+	return quoteIdentifier(header.Schema) + "." + quoteIdentifier(header.Table)
+}
+
+func quoteIdentifier(name string) string {
+	return "`" + strings.Replace(name, "`", "``", -1) + "`"
+}
+
+// sqlLiteral renders the binlog value as MySQL literal for the column type.
+// Bytes are hex literals for binary columns and columns of unknown type,
+// quoted strings for text columns and JSON casts for JSON columns, which
+// don't accept binary strings.
+func sqlLiteral(column database.Column, value interface{}) string {
+	value = NormalizeValue(column, value)
+	if b, ok := value.([]byte); ok && column.DataType != "" && !IsBinaryType(column.DataType) {
+		value = string(b)
+	}
+	switch v := value.(type) {
+	case nil:
+		return "NULL"
+	case int8, int16, int32, int64, int, uint8, uint16, uint32, uint64, uint:
+		return fmt.Sprint(v)
+	case float32:
+		return strconv.FormatFloat(float64(v), 'g', -1, 32)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case []byte:
+		return hexLiteral(v)
+	case string:
+		switch column.DataType {
+		case "decimal", "numeric":
+			if _, err := strconv.ParseFloat(v, 64); err == nil {
+				return v
+			}
+		case "json":
+			return "CAST(" + quoteString(v) + " AS JSON)"
+		}
+		if IsBinaryType(column.DataType) {
+			return hexLiteral([]byte(v))
+		}
+		return quoteString(v)
+	}
+	return quoteString(fmt.Sprint(value))
+}
+
+func hexLiteral(b []byte) string {
+	if len(b) == 0 {
+		return "''"
+	}
+	return "X'" + hex.EncodeToString(b) + "'"
+}
+
+var sqlStringEscaper = strings.NewReplacer(
+	"\\", "\\\\",
+	"'", "\\'",
+	"\x00", "\\0",
+	"\n", "\\n",
+	"\r", "\\r",
+	"\x1a", "\\Z",
+)
+
+func quoteString(s string) string {
+	return "'" + sqlStringEscaper.Replace(s) + "'"
+}
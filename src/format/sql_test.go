@@ -0,0 +1,77 @@
+package format
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/tanema/binlog-parser/src/database"
+	"github.com/tanema/binlog-parser/src/parser"
+)
+
+func TestSQLLiteral(t *testing.T) {
+	testCases := []struct {
+		column   database.Column
+		value    interface{}
+		expected string
+	}{
+		{database.Column{DataType: "int", ColumnType: "int(11)"}, int32(-5), "-5"},
+		{database.Column{DataType: "int", ColumnType: "int(10) unsigned"}, int32(-1), "4294967295"},
+		{database.Column{DataType: "double", ColumnType: "double"}, float64(1.5), "1.5"},
+		{database.Column{DataType: "decimal", ColumnType: "decimal(10,2)"}, "10.50", "10.50"},
+		{database.Column{DataType: "varchar", ColumnType: "varchar(10)"}, "it's a \\ \n", `'it\'s a \\ \n'`},
+		{database.Column{DataType: "varchar", ColumnType: "varchar(10)"}, "", "''"},
+		{database.Column{DataType: "varchar", ColumnType: "varchar(10)"}, nil, "NULL"},
+		{database.Column{DataType: "blob", ColumnType: "blob"}, []byte{0x00, 0xff}, "X'00ff'"},
+		{database.Column{DataType: "varbinary", ColumnType: "varbinary(4)"}, "ab", "X'6162'"},
+		{database.Column{DataType: "text", ColumnType: "text"}, []byte("it's"), `'it\'s'`},
+		{database.Column{DataType: "json", ColumnType: "json"}, []byte(`{"a": "it's"}`), `CAST('{"a": "it\'s"}' AS JSON)`},
+		{database.Column{DataType: "json", ColumnType: "json"}, `[1, 2]`, `CAST('[1, 2]' AS JSON)`},
+		{database.Column{}, []byte{0x00, 0xff}, "X'00ff'"},
+		{database.Column{DataType: "enum", ColumnType: "enum('a','b')"}, int64(2), "'b'"},
+		{database.Column{DataType: "datetime", ColumnType: "datetime"}, "2017-04-13 08:02:04", "'2017-04-13 08:02:04'"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("%s %v", tc.column.ColumnType, tc.value), func(t *testing.T) {
+			if actual := sqlLiteral(tc.column, tc.value); actual != tc.expected {
+				t.Fatal(fmt.Sprintf("Expected %s, got %s", tc.expected, actual))
+			}
+		})
+	}
+}
+
+func TestSQLStatements(t *testing.T) {
+	header := parser.NewMessageHeader("test_db", "employees", time.Now(), 100, 8)
+	header.Columns = []database.Column{
+		{Name: "emp_no", DataType: "int", ColumnType: "int(11)", PrimaryKey: true},
+		{Name: "first_name", DataType: "varchar", ColumnType: "varchar(14)", Nullable: true},
+	}
+	before := parser.MessageRowData{Row: parser.MessageRow{"emp_no": int32(1), "first_name": nil}}
+	after := parser.MessageRowData{Row: parser.MessageRow{"emp_no": int32(1), "first_name": "Max"}}
+
+	t.Run("By primary key", func(t *testing.T) {
+		statement, _ := updateStatement(header, before, after)
+		expected := "UPDATE `test_db`.`employees` SET `emp_no`=1, `first_name`='Max' WHERE `emp_no`=1;"
+		if statement != expected {
+			t.Fatal(fmt.Sprintf("Expected %s, got %s", expected, statement))
+		}
+	})
+
+	t.Run("By all columns without primary key", func(t *testing.T) {
+		header := header
+		header.Columns = []database.Column{{Name: "emp_no", DataType: "int"}, {Name: "first_name", DataType: "varchar"}}
+		statement, _ := deleteStatement(header, before)
+		expected := "DELETE FROM `test_db`.`employees` WHERE `emp_no`=1 AND `first_name` IS NULL LIMIT 1;"
+		if statement != expected {
+			t.Fatal(fmt.Sprintf("Expected %s, got %s", expected, statement))
+		}
+	})
+
+	t.Run("Unmapped row", func(t *testing.T) {
+		unmapped := parser.MessageRowData{Row: parser.MessageRow{"(unknown_0)": 1}, MappingNotice: "row is missing field(s), ignoring missing"}
+		if _, err := insertStatement(header, unmapped); err == nil {
+			t.Fatal("Expected error for unmapped row")
+		}
+	})
+}
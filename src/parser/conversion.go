@@ -14,6 +14,8 @@ type RowsEventData struct {
 	BinlogEventHeader replication.EventHeader
 	BinlogEvent       replication.RowsEvent
 	TableMetadata     database.TableMetadata
+	RowsQuery         SQLQuery
+	Event             EventMetadata
 }
 
 // NewRowsEventData creates a new RowEventData
@@ -22,20 +24,56 @@ func NewRowsEventData(binlogEventHeader replication.EventHeader, binlogEvent rep
 		BinlogEventHeader: binlogEventHeader,
 		BinlogEvent:       binlogEvent,
 		TableMetadata:     tableMetadata,
+		Event:             NewEventMetadata(binlogEventHeader),
 	}
 }
 
-// ConvertQueryEventToMessage converts a query event into a message
+// NewEventMetadata creates the event metadata from a binlog event header, the
+// start position is derived from the end position and the event size
+func NewEventMetadata(binlogEventHeader replication.EventHeader) EventMetadata {
+	metadata := EventMetadata{
+		ServerID:  binlogEventHeader.ServerID,
+		EventSize: binlogEventHeader.EventSize,
+		EventType: binlogEventHeader.EventType.String(),
+	}
+	if binlogEventHeader.EventType == transactionPayloadEvent {
+		metadata.EventType = "TransactionPayloadEvent"
+	}
+	if binlogEventHeader.LogPos >= binlogEventHeader.EventSize {
+		metadata.StartPosition = binlogEventHeader.LogPos - binlogEventHeader.EventSize
+	}
+	return metadata
+}
+
+// ConvertQueryEventToMessage converts a query event into a message. DDL
+// statements are parsed so the header names the affected schema and table.
 func ConvertQueryEventToMessage(binlogEventHeader replication.EventHeader, binlogEvent replication.QueryEvent) Message {
+	schema := string(binlogEvent.Schema)
+	table := "(unknown)"
+	ddl, isDDL := ParseDDL(string(binlogEvent.Query), schema)
+	if isDDL {
+		if len(ddl.Tables) > 0 {
+			schema = ddl.Tables[0].Schema
+			table = ddl.Tables[0].Table
+		} else if len(ddl.Schemas) > 0 {
+			schema = ddl.Schemas[0]
+		}
+	}
+
 	header := NewMessageHeader(
-		string(binlogEvent.Schema),
-		"(unknown)",
+		schema,
+		table,
 		time.Unix(int64(binlogEventHeader.Timestamp), 0),
 		binlogEventHeader.LogPos,
 		0,
 	)
 
+	metadata := NewEventMetadata(binlogEventHeader)
+	header.Event = &metadata
+
 	message := NewQueryMessage(header, SQLQuery(binlogEvent.Query))
+	message.DDL = ddl
+	message.Database = string(binlogEvent.Schema)
 	return Message(message)
 }
 
@@ -53,18 +91,20 @@ func ConvertRowsEventsToMessages(xID uint64, rowsEventsData []RowsEventData) []M
 			d.BinlogEventHeader.LogPos,
 			xID,
 		)
+		header.RowsQuery = d.RowsQuery
+		header.Columns = d.TableMetadata.Columns
 
 		switch d.BinlogEventHeader.EventType {
 		case replication.WRITE_ROWS_EVENTv1, replication.WRITE_ROWS_EVENTv2:
-			for _, message := range createInsertMessagesFromRowData(header, rowData) {
+			for _, message := range createInsertMessagesFromRowData(header, d.Event, rowData) {
 				ret = append(ret, Message(message))
 			}
 		case replication.UPDATE_ROWS_EVENTv1, replication.UPDATE_ROWS_EVENTv2:
-			for _, message := range createUpdateMessagesFromRowData(header, rowData) {
+			for _, message := range createUpdateMessagesFromRowData(header, d.Event, rowData) {
 				ret = append(ret, Message(message))
 			}
 		case replication.DELETE_ROWS_EVENTv1, replication.DELETE_ROWS_EVENTv2:
-			for _, message := range createDeleteMessagesFromRowData(header, rowData) {
+			for _, message := range createDeleteMessagesFromRowData(header, d.Event, rowData) {
 				ret = append(ret, Message(message))
 			}
 		}
@@ -73,33 +113,39 @@ func ConvertRowsEventsToMessages(xID uint64, rowsEventsData []RowsEventData) []M
 	return ret
 }
 
-func createUpdateMessagesFromRowData(header MessageHeader, rowData []MessageRowData) []UpdateMessage {
+func createUpdateMessagesFromRowData(header MessageHeader, metadata EventMetadata, rowData []MessageRowData) []UpdateMessage {
 	if len(rowData)%2 != 0 {
 		panic("update rows should be old/new pairs") // should never happen as per mysql format
 	}
 	ret := make([]UpdateMessage, len(rowData)/2)
 	for i := 0; i < len(rowData); i += 2 {
-		ret[i/2] = NewUpdateMessage(header, rowData[i], rowData[i+1])
+		ret[i/2] = NewUpdateMessage(rowHeader(header, metadata, i/2), rowData[i], rowData[i+1])
 	}
 	return ret
 }
 
-func createInsertMessagesFromRowData(header MessageHeader, rowData []MessageRowData) []InsertMessage {
+func createInsertMessagesFromRowData(header MessageHeader, metadata EventMetadata, rowData []MessageRowData) []InsertMessage {
 	ret := make([]InsertMessage, len(rowData))
 	for i, data := range rowData {
-		ret[i] = NewInsertMessage(header, data)
+		ret[i] = NewInsertMessage(rowHeader(header, metadata, i), data)
 	}
 	return ret
 }
 
-func createDeleteMessagesFromRowData(header MessageHeader, rowData []MessageRowData) []DeleteMessage {
+func createDeleteMessagesFromRowData(header MessageHeader, metadata EventMetadata, rowData []MessageRowData) []DeleteMessage {
 	ret := make([]DeleteMessage, len(rowData))
 	for i, data := range rowData {
-		ret[i] = NewDeleteMessage(header, data)
+		ret[i] = NewDeleteMessage(rowHeader(header, metadata, i), data)
 	}
 	return ret
 }
 
+func rowHeader(header MessageHeader, metadata EventMetadata, rowIndex int) MessageHeader {
+	metadata.RowIndex = rowIndex
+	header.Event = &metadata
+	return header
+}
+
 func mapRowDataToColumnNames(rows [][]interface{}, columnNames []string) []MessageRowData {
 	var mappedRows []MessageRowData
 
@@ -26,6 +26,30 @@ func TestConvertQueryEventToMessage(t *testing.T) {
 	if string(message.(QueryMessage).Query) != query {
 		t.Fatal("Unexpected value for query ")
 	}
+
+	if message.GetHeader().Table != "(unknown)" || message.(QueryMessage).DDL != nil {
+		t.Fatal("Expected non DDL query to have no table")
+	}
+}
+
+func TestConvertDDLQueryEventToMessage(t *testing.T) {
+	eventHeader := replication.EventHeader{Timestamp: uint32(time.Now().Unix()), LogPos: 100}
+	queryEvent := replication.QueryEvent{Schema: []byte("db_name"), Query: []byte("ALTER TABLE other_db.table_name DROP COLUMN field_1")}
+
+	message := ConvertQueryEventToMessage(eventHeader, queryEvent)
+
+	if message.GetHeader().Schema != "other_db" || message.GetHeader().Table != "table_name" {
+		t.Fatal(fmt.Sprintf("Wrong schema/table in header - got %v", message.GetHeader()))
+	}
+
+	if message.(QueryMessage).Database != "db_name" {
+		t.Fatal(fmt.Sprintf("Expected the default database of the session, got %s", message.(QueryMessage).Database))
+	}
+
+	ddl := message.(QueryMessage).DDL
+	if ddl == nil || ddl.Kind != DDLAlterTable || !reflect.DeepEqual(ddl.DroppedColumns, []string{"field_1"}) {
+		t.Fatal(fmt.Sprintf("Wrong DDL - got %+v", ddl))
+	}
 }
 
 func TestConvertRowsEventsToMessages(t *testing.T) {
@@ -143,6 +167,47 @@ func TestConvertRowsEventsToMessages(t *testing.T) {
 		})
 	}
 
+	t.Run("Event metadata", func(t *testing.T) {
+		eventHeader := createEventHeader(logPos, replication.UPDATE_ROWS_EVENTv2)
+		eventHeader.ServerID = 7
+		eventHeader.EventSize = 60
+		rowsEvent := createRowsEvent(
+			[]interface{}{"value_1", "value_2"}, []interface{}{"value_3", "value_4"},
+			[]interface{}{"value_5", "value_6"}, []interface{}{"value_7", "value_8"},
+		)
+		rowsEventData := []RowsEventData{NewRowsEventData(eventHeader, rowsEvent, tableMetadata)}
+
+		convertedMessages := ConvertRowsEventsToMessages(xid, rowsEventData)
+
+		if len(convertedMessages) != 2 {
+			t.Fatal("Expected 2 update messages to be created")
+		}
+
+		for i, message := range convertedMessages {
+			expected := EventMetadata{ServerID: 7, StartPosition: 40, EventSize: 60, EventType: "UpdateRowsEventV2", RowIndex: i}
+			if !reflect.DeepEqual(*message.GetHeader().Event, expected) {
+				t.Fatal(fmt.Sprintf("Wrong event metadata for message %d - got %+v", i, *message.GetHeader().Event))
+			}
+		}
+	})
+
+	t.Run("Rows query", func(t *testing.T) {
+		eventHeader := createEventHeader(logPos, replication.WRITE_ROWS_EVENTv2)
+		rowsEvent := createRowsEvent([]interface{}{"value_1", "value_2"})
+		rowsEventData := NewRowsEventData(eventHeader, rowsEvent, tableMetadata)
+		rowsEventData.RowsQuery = "INSERT INTO table_name VALUES ('value_1', 'value_2')"
+
+		convertedMessages := ConvertRowsEventsToMessages(xid, []RowsEventData{rowsEventData})
+
+		if len(convertedMessages) != 1 {
+			t.Fatal("Expected 1 insert message to be created")
+		}
+
+		if convertedMessages[0].GetHeader().RowsQuery != rowsEventData.RowsQuery {
+			t.Fatal(fmt.Sprintf("Wrong rows query - got %v", convertedMessages[0].GetHeader().RowsQuery))
+		}
+	})
+
 	t.Run("Unknown event type", func(t *testing.T) {
 		eventHeader := createEventHeader(logPos, replication.RAND_EVENT) // can be any unkown event actually
 		rowsEvent := createRowsEvent()
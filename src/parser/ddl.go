@@ -0,0 +1,485 @@
+package parser
+
+import (
+	"strings"
+)
+
+// DDLKind is the kind of DDL statement ENUM
+type DDLKind string
+
+const (
+	// DDLCreateTable is a CREATE TABLE statement
+	DDLCreateTable DDLKind = "CreateTable"
+	// DDLAlterTable is an ALTER TABLE statement
+	DDLAlterTable DDLKind = "AlterTable"
+	// DDLDropTable is a DROP TABLE statement
+	DDLDropTable DDLKind = "DropTable"
+	// DDLRenameTable is a RENAME TABLE statement
+	DDLRenameTable DDLKind = "RenameTable"
+	// DDLTruncateTable is a TRUNCATE TABLE statement
+	DDLTruncateTable DDLKind = "TruncateTable"
+	// DDLCreateIndex is a CREATE INDEX statement
+	DDLCreateIndex DDLKind = "CreateIndex"
+	// DDLDropIndex is a DROP INDEX statement
+	DDLDropIndex DDLKind = "DropIndex"
+	// DDLCreateDatabase is a CREATE DATABASE/SCHEMA statement
+	DDLCreateDatabase DDLKind = "CreateDatabase"
+	// DDLAlterDatabase is an ALTER DATABASE/SCHEMA statement
+	DDLAlterDatabase DDLKind = "AlterDatabase"
+	// DDLDropDatabase is a DROP DATABASE/SCHEMA statement
+	DDLDropDatabase DDLKind = "DropDatabase"
+)
+
+// TableName is a schema qualified table name
+type TableName struct {
+	Schema string
+	Table  string
+}
+
+// ColumnRename describes a column that changed its name
+type ColumnRename struct {
+	From string
+	To   string
+}
+
+// DDLStatement is the structured form of a DDL query. Tables lists every
+// table the statement touches, for renames both the old and the new name.
+type DDLStatement struct {
+	Kind            DDLKind
+	Schemas         []string       `json:",omitempty"`
+	Tables          []TableName    `json:",omitempty"`
+	AddedColumns    []string       `json:",omitempty"`
+	DroppedColumns  []string       `json:",omitempty"`
+	ModifiedColumns []string       `json:",omitempty"`
+	RenamedColumns  []ColumnRename `json:",omitempty"`
+}
+
+// ParseDDL parses a DDL query, unqualified table names are resolved against
+// the default schema. It returns false if the query is not a DDL statement
+// that is understood.
+func ParseDDL(query string, defaultSchema string) (*DDLStatement, bool) {
+	p := &ddlParser{tokens: tokenizeSQL(query), defaultSchema: defaultSchema}
+	stmt := &DDLStatement{}
+
+	var ok bool
+	switch {
+	case p.accept("CREATE"):
+		ok = p.parseCreate(stmt)
+	case p.accept("ALTER"):
+		ok = p.parseAlter(stmt)
+	case p.accept("DROP"):
+		ok = p.parseDrop(stmt)
+	case p.accept("RENAME", "TABLE"):
+		stmt.Kind = DDLRenameTable
+		ok = p.parseRenameTable(stmt)
+	case p.accept("TRUNCATE"):
+		p.accept("TABLE")
+		stmt.Kind = DDLTruncateTable
+		ok = p.parseTableList(stmt)
+	}
+	if !ok {
+		return nil, false
+	}
+
+	for _, table := range stmt.Tables {
+		stmt.addSchema(table.Schema)
+	}
+	return stmt, true
+}
+
+func (s *DDLStatement) addTable(table TableName) {
+	for _, t := range s.Tables {
+		if t == table {
+			return
+		}
+	}
+	s.Tables = append(s.Tables, table)
+}
+
+func (s *DDLStatement) addSchema(schema string) {
+	if schema != "" && !contains(s.Schemas, schema) {
+		s.Schemas = append(s.Schemas, schema)
+	}
+}
+
+type ddlToken struct {
+	text   string
+	quoted bool
+}
+
+type ddlParser struct {
+	tokens        []ddlToken
+	pos           int
+	defaultSchema string
+}
+
+func (p *ddlParser) done() bool {
+	return p.pos >= len(p.tokens) || p.tokens[p.pos].text == ";"
+}
+
+func (p *ddlParser) peek() ddlToken {
+	if p.done() {
+		return ddlToken{}
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *ddlParser) is(keyword string) bool {
+	token := p.peek()
+	return !token.quoted && strings.EqualFold(token.text, keyword)
+}
+
+// accept consumes the keywords if all of them follow in order
+func (p *ddlParser) accept(keywords ...string) bool {
+	start := p.pos
+	for _, keyword := range keywords {
+		if !p.is(keyword) {
+			p.pos = start
+			return false
+		}
+		p.pos++
+	}
+	return true
+}
+
+func (p *ddlParser) acceptAny(keywords ...string) bool {
+	for _, keyword := range keywords {
+		if p.accept(keyword) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *ddlParser) identifier() (string, bool) {
+	token := p.peek()
+	if token.text == "" || (!token.quoted && isPunctuation(token.text)) {
+		return "", false
+	}
+	p.pos++
+	return token.text, true
+}
+
+func (p *ddlParser) tableName() (TableName, bool) {
+	first, ok := p.identifier()
+	if !ok {
+		return TableName{}, false
+	}
+	if p.peek().text == "." && !p.peek().quoted {
+		p.pos++
+		second, ok := p.identifier()
+		if !ok {
+			return TableName{}, false
+		}
+		return TableName{Schema: first, Table: second}, true
+	}
+	return TableName{Schema: p.defaultSchema, Table: first}, true
+}
+
+// skipDefinition moves past the current comma separated definition,
+// honoring nested parentheses
+func (p *ddlParser) skipDefinition() {
+	depth := 0
+	for !p.done() {
+		token := p.peek()
+		if !token.quoted {
+			switch token.text {
+			case "(":
+				depth++
+			case ")":
+				if depth == 0 {
+					return
+				}
+				depth--
+			case ",":
+				if depth == 0 {
+					return
+				}
+			}
+		}
+		p.pos++
+	}
+}
+
+func (p *ddlParser) parseCreate(stmt *DDLStatement) bool {
+	p.accept("OR", "REPLACE")
+	switch {
+	case p.acceptAny("DATABASE", "SCHEMA"):
+		stmt.Kind = DDLCreateDatabase
+		return p.parseDatabase(stmt)
+	case p.accept("TEMPORARY", "TABLE"), p.accept("TABLE"):
+		stmt.Kind = DDLCreateTable
+		p.accept("IF", "NOT", "EXISTS")
+		table, ok := p.tableName()
+		if !ok {
+			return false
+		}
+		stmt.addTable(table)
+		if p.accept("LIKE") || p.accept("(", "LIKE") {
+			if source, ok := p.tableName(); ok {
+				stmt.addTable(source)
+			}
+			return true
+		}
+		if p.accept("(") {
+			p.parseColumnDefinitions(stmt)
+		}
+		return true
+	}
+
+	p.acceptAny("ONLINE", "OFFLINE")
+	p.acceptAny("UNIQUE", "FULLTEXT", "SPATIAL")
+	if p.accept("INDEX") {
+		stmt.Kind = DDLCreateIndex
+		return p.parseIndexTable(stmt)
+	}
+	return false
+}
+
+func (p *ddlParser) parseColumnDefinitions(stmt *DDLStatement) {
+	for !p.done() && p.peek().text != ")" {
+		if !isConstraintDefinition(p) {
+			if column, ok := p.identifier(); ok {
+				stmt.AddedColumns = append(stmt.AddedColumns, column)
+			}
+		}
+		p.skipDefinition()
+		p.accept(",")
+	}
+}
+
+func (p *ddlParser) parseAlter(stmt *DDLStatement) bool {
+	if p.acceptAny("DATABASE", "SCHEMA") {
+		stmt.Kind = DDLAlterDatabase
+		return p.parseDatabase(stmt)
+	}
+	p.acceptAny("ONLINE", "OFFLINE")
+	p.accept("IGNORE")
+	if !p.accept("TABLE") {
+		return false
+	}
+	stmt.Kind = DDLAlterTable
+	table, ok := p.tableName()
+	if !ok {
+		return false
+	}
+	stmt.addTable(table)
+
+	for !p.done() {
+		p.parseAlterSpecification(stmt)
+		p.skipDefinition()
+		if !p.accept(",") {
+			break
+		}
+	}
+	return true
+}
+
+func (p *ddlParser) parseAlterSpecification(stmt *DDLStatement) {
+	switch {
+	case p.accept("ADD"):
+		if p.accept("COLUMN") || !isConstraintDefinition(p) {
+			if p.accept("(") {
+				p.parseColumnDefinitions(stmt)
+				p.accept(")")
+			} else if column, ok := p.identifier(); ok {
+				stmt.AddedColumns = append(stmt.AddedColumns, column)
+			}
+		}
+	case p.accept("DROP"):
+		if p.accept("COLUMN") || !isConstraintDefinition(p) {
+			if column, ok := p.identifier(); ok {
+				stmt.DroppedColumns = append(stmt.DroppedColumns, column)
+			}
+		}
+	case p.accept("MODIFY"):
+		p.accept("COLUMN")
+		if column, ok := p.identifier(); ok {
+			stmt.ModifiedColumns = append(stmt.ModifiedColumns, column)
+		}
+	case p.accept("CHANGE"):
+		p.accept("COLUMN")
+		from, okFrom := p.identifier()
+		to, okTo := p.identifier()
+		if okFrom && okTo {
+			if from != to {
+				stmt.RenamedColumns = append(stmt.RenamedColumns, ColumnRename{From: from, To: to})
+			}
+			stmt.ModifiedColumns = append(stmt.ModifiedColumns, to)
+		}
+	case p.accept("ALTER"):
+		if p.accept("COLUMN") || !isConstraintDefinition(p) {
+			if column, ok := p.identifier(); ok {
+				stmt.ModifiedColumns = append(stmt.ModifiedColumns, column)
+			}
+		}
+	case p.accept("RENAME"):
+		switch {
+		case p.accept("COLUMN"):
+			from, okFrom := p.identifier()
+			if okFrom && p.accept("TO") {
+				if to, ok := p.identifier(); ok {
+					stmt.RenamedColumns = append(stmt.RenamedColumns, ColumnRename{From: from, To: to})
+				}
+			}
+		case p.acceptAny("INDEX", "KEY"):
+		default:
+			p.acceptAny("TO", "AS")
+			if table, ok := p.tableName(); ok {
+				stmt.addTable(table)
+			}
+		}
+	}
+}
+
+func (p *ddlParser) parseDrop(stmt *DDLStatement) bool {
+	switch {
+	case p.acceptAny("DATABASE", "SCHEMA"):
+		stmt.Kind = DDLDropDatabase
+		p.accept("IF", "EXISTS")
+		return p.parseDatabase(stmt)
+	case p.accept("TEMPORARY", "TABLE"), p.accept("TABLE"):
+		stmt.Kind = DDLDropTable
+		p.accept("IF", "EXISTS")
+		return p.parseTableList(stmt)
+	}
+	p.acceptAny("ONLINE", "OFFLINE")
+	if p.accept("INDEX") {
+		stmt.Kind = DDLDropIndex
+		return p.parseIndexTable(stmt)
+	}
+	return false
+}
+
+func (p *ddlParser) parseDatabase(stmt *DDLStatement) bool {
+	p.accept("IF", "NOT", "EXISTS")
+	schema, ok := p.identifier()
+	if !ok {
+		return false
+	}
+	stmt.addSchema(schema)
+	return true
+}
+
+func (p *ddlParser) parseIndexTable(stmt *DDLStatement) bool {
+	if _, ok := p.identifier(); !ok {
+		return false
+	}
+	if !p.accept("ON") {
+		return false
+	}
+	table, ok := p.tableName()
+	if !ok {
+		return false
+	}
+	stmt.addTable(table)
+	return true
+}
+
+func (p *ddlParser) parseTableList(stmt *DDLStatement) bool {
+	for {
+		table, ok := p.tableName()
+		if !ok {
+			return len(stmt.Tables) > 0
+		}
+		stmt.addTable(table)
+		if !p.accept(",") {
+			return true
+		}
+	}
+}
+
+func (p *ddlParser) parseRenameTable(stmt *DDLStatement) bool {
+	for {
+		from, ok := p.tableName()
+		if !ok || !p.accept("TO") {
+			return len(stmt.Tables) > 0
+		}
+		to, ok := p.tableName()
+		if !ok {
+			return false
+		}
+		stmt.addTable(from)
+		stmt.addTable(to)
+		if !p.accept(",") {
+			return true
+		}
+	}
+}
+
+// isConstraintDefinition reports whether the upcoming definition is an index
+// or constraint instead of a column, without consuming it
+func isConstraintDefinition(p *ddlParser) bool {
+	for _, keyword := range []string{"PRIMARY", "KEY", "INDEX", "UNIQUE", "CONSTRAINT", "FOREIGN", "FULLTEXT", "SPATIAL", "CHECK", "PARTITION"} {
+		if p.is(keyword) {
+			return true
+		}
+	}
+	return false
+}
+
+func isPunctuation(text string) bool {
+	return len(text) == 1 && strings.ContainsAny(text, "(),.;=")
+}
+
+// tokenizeSQL splits a query into identifiers, quoted strings and punctuation,
+// dropping comments. Executable comments (/*! ... */) are kept as code.
+func tokenizeSQL(query string) []ddlToken {
+	var tokens []ddlToken
+	for i := 0; i < len(query); {
+		c := query[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case strings.HasPrefix(query[i:], "/*!"):
+			i += 3
+			for i < len(query) && query[i] >= '0' && query[i] <= '9' {
+				i++
+			}
+		case strings.HasPrefix(query[i:], "/*"):
+			end := strings.Index(query[i+2:], "*/")
+			if end < 0 {
+				return tokens
+			}
+			i += end + 4
+		case strings.HasPrefix(query[i:], "*/"):
+			i += 2
+		case c == '#' || strings.HasPrefix(query[i:], "-- "):
+			end := strings.IndexByte(query[i:], '\n')
+			if end < 0 {
+				return tokens
+			}
+			i += end + 1
+		case c == '`' || c == '\'' || c == '"':
+			var text strings.Builder
+			i++
+			for i < len(query) {
+				if query[i] == c {
+					if i+1 < len(query) && query[i+1] == c {
+						text.WriteByte(c)
+						i += 2
+						continue
+					}
+					break
+				}
+				if query[i] == '\\' && c != '`' && i+1 < len(query) {
+					i++
+				}
+				text.WriteByte(query[i])
+				i++
+			}
+			i++
+			tokens = append(tokens, ddlToken{text: text.String(), quoted: true})
+		case isPunctuation(string(c)):
+			tokens = append(tokens, ddlToken{text: string(c)})
+			i++
+		default:
+			start := i
+			for i < len(query) && !strings.ContainsRune(" \t\n\r`'\"(),.;=", rune(query[i])) && !strings.HasPrefix(query[i:], "/*") && !strings.HasPrefix(query[i:], "*/") {
+				i++
+			}
+			tokens = append(tokens, ddlToken{text: query[start:i]})
+		}
+	}
+	return tokens
+}
@@ -0,0 +1,105 @@
+package parser
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestParseDDL(t *testing.T) {
+	testCases := []struct {
+		query    string
+		expected *DDLStatement
+	}{
+		{
+			"CREATE TABLE employees (\n    emp_no INT UNSIGNED AUTO_INCREMENT NOT NULL,\n    first_name VARCHAR(14) NOT NULL,\n    PRIMARY KEY (emp_no) \n)",
+			&DDLStatement{
+				Kind:         DDLCreateTable,
+				Schemas:      []string{"test_db"},
+				Tables:       []TableName{{"test_db", "employees"}},
+				AddedColumns: []string{"emp_no", "first_name"},
+			},
+		},
+		{
+			"CREATE TABLE IF NOT EXISTS `other_db`.`copy` LIKE `employees`",
+			&DDLStatement{
+				Kind:    DDLCreateTable,
+				Schemas: []string{"other_db", "test_db"},
+				Tables:  []TableName{{"other_db", "copy"}, {"test_db", "employees"}},
+			},
+		},
+		{
+			"alter table language add some_field varchar(255) default NULL",
+			&DDLStatement{
+				Kind:         DDLAlterTable,
+				Schemas:      []string{"test_db"},
+				Tables:       []TableName{{"test_db", "language"}},
+				AddedColumns: []string{"some_field"},
+			},
+		},
+		{
+			"ALTER TABLE `t` ADD COLUMN (a INT, b DECIMAL(10,2)), DROP COLUMN c, DROP INDEX idx, MODIFY d TEXT, " +
+				"CHANGE e f INT, CHANGE g g BIGINT, RENAME COLUMN h TO i, ALTER COLUMN j SET DEFAULT 1, ADD INDEX (a), RENAME TO `t2`",
+			&DDLStatement{
+				Kind:            DDLAlterTable,
+				Schemas:         []string{"test_db"},
+				Tables:          []TableName{{"test_db", "t"}, {"test_db", "t2"}},
+				AddedColumns:    []string{"a", "b"},
+				DroppedColumns:  []string{"c"},
+				ModifiedColumns: []string{"d", "f", "g", "j"},
+				RenamedColumns:  []ColumnRename{{"e", "f"}, {"h", "i"}},
+			},
+		},
+		{
+			"DROP TABLE `filler`,`test_db`.`lookup` /* generated by server */",
+			&DDLStatement{
+				Kind:    DDLDropTable,
+				Schemas: []string{"test_db"},
+				Tables:  []TableName{{"test_db", "filler"}, {"test_db", "lookup"}},
+			},
+		},
+		{
+			"RENAME TABLE a TO b, c TO archive.c",
+			&DDLStatement{
+				Kind:    DDLRenameTable,
+				Schemas: []string{"test_db", "archive"},
+				Tables:  []TableName{{"test_db", "a"}, {"test_db", "b"}, {"test_db", "c"}, {"archive", "c"}},
+			},
+		},
+		{
+			"TRUNCATE logs",
+			&DDLStatement{Kind: DDLTruncateTable, Schemas: []string{"test_db"}, Tables: []TableName{{"test_db", "logs"}}},
+		},
+		{
+			"CREATE UNIQUE INDEX idx_name ON users (name)",
+			&DDLStatement{Kind: DDLCreateIndex, Schemas: []string{"test_db"}, Tables: []TableName{{"test_db", "users"}}},
+		},
+		{
+			"DROP INDEX idx_name ON users",
+			&DDLStatement{Kind: DDLDropIndex, Schemas: []string{"test_db"}, Tables: []TableName{{"test_db", "users"}}},
+		},
+		{
+			"CREATE DATABASE /*!32312 IF NOT EXISTS*/ `shop`",
+			&DDLStatement{Kind: DDLCreateDatabase, Schemas: []string{"shop"}},
+		},
+		{
+			"DROP SCHEMA IF EXISTS shop",
+			&DDLStatement{Kind: DDLDropDatabase, Schemas: []string{"shop"}},
+		},
+		{"DELETE FROM `test_db`.`filler`", nil},
+		{"CREATE VIEW v AS SELECT 1", nil},
+		{"BEGIN", nil},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.query, func(t *testing.T) {
+			ddl, ok := ParseDDL(tc.query, "test_db")
+			if ok != (tc.expected != nil) {
+				t.Fatal(fmt.Sprintf("Unexpected DDL detection result %v", ok))
+			}
+			if !reflect.DeepEqual(ddl, tc.expected) {
+				t.Fatal(fmt.Sprintf("Wrong DDL statement\nexpected %+v\ngot      %+v", tc.expected, ddl))
+			}
+		})
+	}
+}
@@ -1,7 +1,10 @@
 package parser
 
 import (
+	"encoding/json"
 	"time"
+
+	"github.com/tanema/binlog-parser/src/database"
 )
 
 // Message is the interface the encapsulates a binlog event
@@ -24,6 +27,14 @@ const (
 	MessageTypeQuery MessageType = "Query"
 )
 
+const (
+	// SchemaVersion1 is the original output schema of the message header
+	SchemaVersion1 = 1
+	// SchemaVersion2 adds the SchemaVersion and the Event metadata to the
+	// message header
+	SchemaVersion2 = 2
+)
+
 // MessageHeader describes the origin of the message
 type MessageHeader struct {
 	Schema            string
@@ -31,6 +42,32 @@ type MessageHeader struct {
 	BinlogMessageTime string
 	BinlogPosition    uint32
 	XID               uint64
+	RowsQuery         SQLQuery          `json:",omitempty"`
+	SchemaVersion     int               `json:",omitempty"`
+	Event             *EventMetadata    `json:",omitempty"`
+	Columns           []database.Column `json:"-"`
+}
+
+// EventMetadata describes the binlog event a message was created from.
+// StartPosition is the offset of the event in the binlog file, unlike
+// BinlogPosition which is the position of the next event. Events of a
+// compressed transaction are Compressed and start at the offset of their
+// TRANSACTION_PAYLOAD_EVENT, which has the size of the events it holds as
+// UncompressedSize. Events of a relay log carry the binlog file and
+// position of the source, and their file and offset in the relay log as
+// RelayLogFile and RelayLogPosition.
+type EventMetadata struct {
+	ServerID         uint32
+	StartPosition    uint32
+	EventSize        uint32
+	EventType        string
+	BinlogFile       string
+	RowIndex         int
+	GTID             string `json:",omitempty"`
+	Compressed       bool   `json:",omitempty"`
+	UncompressedSize uint32 `json:",omitempty"`
+	RelayLogFile     string `json:",omitempty"`
+	RelayLogPosition uint32 `json:",omitempty"`
 }
 
 // NewMessageHeader creates and returns a new message header
@@ -44,6 +81,29 @@ func NewMessageHeader(schema string, table string, binlogMessageTime time.Time,
 	}
 }
 
+// MarshalJSON only includes the fields of the header's schema version, so
+// consumers of the original schema don't break
+func (h MessageHeader) MarshalJSON() ([]byte, error) {
+	type header MessageHeader
+	if h.SchemaVersion < SchemaVersion2 {
+		h.SchemaVersion = 0
+		h.Event = nil
+	}
+	return json.Marshal(header(h))
+}
+
+// PrimaryKey returns the primary key values of the row, or nil if the primary
+// key of the table is unknown
+func (h MessageHeader) PrimaryKey(row MessageRow) []interface{} {
+	var values []interface{}
+	for _, column := range h.Columns {
+		if column.PrimaryKey {
+			values = append(values, row[column.Name])
+		}
+	}
+	return values
+}
+
 type baseMessage struct {
 	Header MessageHeader
 	Type   MessageType
@@ -69,10 +129,15 @@ type MessageRowData struct {
 // SQLQuery is just a plain query string
 type SQLQuery string
 
-// QueryMessage is a message that wraps a query statement
+// QueryMessage is a message that wraps a query statement, DDL holds the
+// structured form if the query is a DDL statement
 type QueryMessage struct {
 	baseMessage
 	Query SQLQuery
+	DDL   *DDLStatement `json:",omitempty"`
+	// Database is the default database of the session that ran the query,
+	// unqualified names in the query resolve against it
+	Database string `json:"-"`
 }
 
 // NewQueryMessage creates a new query message
@@ -113,3 +178,22 @@ type DeleteMessage struct {
 func NewDeleteMessage(header MessageHeader, data MessageRowData) DeleteMessage {
 	return DeleteMessage{baseMessage: baseMessage{Header: header, Type: MessageTypeDelete}, Data: data}
 }
+
+// WithHeader returns a copy of the message with its header replaced
+func WithHeader(message Message, header MessageHeader) Message {
+	switch m := message.(type) {
+	case QueryMessage:
+		m.Header = header
+		return m
+	case InsertMessage:
+		m.Header = header
+		return m
+	case UpdateMessage:
+		m.Header = header
+		return m
+	case DeleteMessage:
+		m.Header = header
+		return m
+	}
+	return message
+}
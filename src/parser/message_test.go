@@ -1,8 +1,14 @@
 package parser
 
 import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/tanema/binlog-parser/src/database"
 )
 
 func TestNewMessageHeader(t *testing.T) {
@@ -28,3 +34,42 @@ func TestNewMessageHeader(t *testing.T) {
 		t.Fatal("Wrong Xid in message header")
 	}
 }
+
+func TestMessageHeaderSchemaVersion(t *testing.T) {
+	messageHeader := NewMessageHeader("schema", "table", time.Now(), 1, 2)
+	messageHeader.Event = &EventMetadata{ServerID: 3, StartPosition: 4, EventSize: 5, EventType: "QueryEvent", BinlogFile: "mysql-bin.000001"}
+
+	t.Run("Version 1 omits event metadata", func(t *testing.T) {
+		messageHeader.SchemaVersion = SchemaVersion1
+		data, err := json.Marshal(messageHeader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(string(data), "Event") || strings.Contains(string(data), "SchemaVersion") {
+			t.Fatal("Unexpected event metadata in version 1 header: " + string(data))
+		}
+	})
+
+	t.Run("Version 2 includes event metadata", func(t *testing.T) {
+		messageHeader.SchemaVersion = SchemaVersion2
+		data, err := json.Marshal(messageHeader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(data), `"SchemaVersion":2`) || !strings.Contains(string(data), `"BinlogFile":"mysql-bin.000001"`) {
+			t.Fatal("Missing event metadata in version 2 header: " + string(data))
+		}
+	})
+}
+
+func TestMessageHeaderPrimaryKey(t *testing.T) {
+	header := NewMessageHeader("test_db", "employees", time.Unix(1492070524, 0), 635, 8)
+	row := MessageRow{"dept_no": "d001", "emp_no": int32(1), "title": "Engineer"}
+	if key := header.PrimaryKey(row); key != nil {
+		t.Fatal(fmt.Sprintf("Expected no key without columns, got %v", key))
+	}
+	header.Columns = []database.Column{{Name: "dept_no", PrimaryKey: true}, {Name: "emp_no", PrimaryKey: true}, {Name: "title"}}
+	if key := header.PrimaryKey(row); !reflect.DeepEqual(key, []interface{}{"d001", int32(1)}) {
+		t.Fatal(fmt.Sprintf("Wrong key %v", key))
+	}
+}
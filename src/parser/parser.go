@@ -1,8 +1,14 @@
 package parser
 
 import (
+	"errors"
+	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/siddontang/go-mysql/replication"
 
@@ -11,8 +17,42 @@ import (
 
 // ConsumerFunc is a function to handle each message from the binlog
 type ConsumerFunc func(Message) error
+
+// CommitFunc is a function called once all messages of a transaction were
+// handed to the consumer
+type CommitFunc func(xid uint64) error
+
+// EventFunc is a function called with the metadata and time of every binlog
+// event before it's handled
+type EventFunc func(event EventMetadata, timestamp time.Time) error
+
+// TransactionFunc is a function called with the summary of each transaction
+// once it ended
+type TransactionFunc func(transaction Transaction) error
+
+// CorruptFunc is a function called with each corrupt event that is skipped
+type CorruptFunc func(err *CorruptEventError) error
+
 type predicate func(message Message) bool
 
+// Transaction summarizes a transaction of the binlog, from its GTID or BEGIN
+// event to its XID event. Rows counts all rows regardless of the filters,
+// FirstQuery is the statement of the first rows event if rows queries are
+// captured.
+type Transaction struct {
+	XID           uint64
+	GTID          string
+	BinlogFile    string
+	StartPosition uint32
+	EndPosition   uint32
+	Begin         time.Time
+	End           time.Time
+	Rows          int
+	Bytes         uint64
+	Tables        []TableName
+	FirstQuery    SQLQuery `json:",omitempty"`
+}
+
 type rowsEventBuffer struct {
 	buffered []RowsEventData
 }
@@ -31,18 +71,84 @@ func (mb *rowsEventBuffer) drain() []RowsEventData {
 // predicates and then emits the messages
 type Parser struct {
 	consumer           ConsumerFunc
+	commit             CommitFunc
+	event              EventFunc
+	transactionFunc    TransactionFunc
+	transaction        *Transaction
 	rowRowsEventBuffer rowsEventBuffer
 	db                 *database.DB
 	predicates         []predicate
+	captureRowsQuery   bool
+	rowsQuery          SQLQuery
+	schemaVersion      int
+	binlogFile         string
+	position           uint32
+	gtid               string
+	verifyChecksum     bool
+	corrupt            CorruptFunc
+	skipRows           bool
+	payload            *EventMetadata
+	relay              bool
+	sourceFile         string
+	start              *startPoint
+	stop               *stopPoint
+	inTransaction      bool
+	stopped            bool
 }
 
+// startPoint is where StartAt starts parsing
+type startPoint struct {
+	binlogFile string
+	position   uint32
+	seenFile   bool
+}
+
+// stopPoint is where StopAt ends parsing
+type stopPoint struct {
+	binlogFile string
+	position   uint32
+	time       time.Time
+	seenFile   bool
+}
+
+// errStop ends the parsing of the binlogs at the stop point
+var errStop = errors.New("stop point reached")
+
 // New creates a new Parser for a binlog and database
 func New(db *database.DB, consumer ConsumerFunc) Parser {
 	return Parser{
 		db:                 db,
 		rowRowsEventBuffer: rowsEventBuffer{},
 		consumer:           consumer,
+		schemaVersion:      SchemaVersion1,
+	}
+}
+
+// SetSchemaVersion selects the output schema version of the message headers.
+// SchemaVersion2 adds the event metadata.
+func (p *Parser) SetSchemaVersion(version int) error {
+	if version != SchemaVersion1 && version != SchemaVersion2 {
+		return fmt.Errorf("unknown schema version %d", version)
 	}
+	p.schemaVersion = version
+	return nil
+}
+
+// OnCommit sets the function called at the end of each transaction
+func (p *Parser) OnCommit(commit CommitFunc) {
+	p.commit = commit
+}
+
+// OnEvent sets the function called for each binlog event, it sees all events
+// regardless of the filters
+func (p *Parser) OnEvent(event EventFunc) {
+	p.event = event
+}
+
+// OnTransaction sets the function called with the summary of each
+// transaction, it sees all transactions regardless of the filters
+func (p *Parser) OnTransaction(transaction TransactionFunc) {
+	p.transactionFunc = transaction
 }
 
 // IncludeTables will set the filter for selected tables
@@ -53,6 +159,13 @@ func (p *Parser) IncludeTables(tables []string) {
 			if message.GetHeader().Table == "" {
 				return true
 			}
+			if ddl := messageDDL(message); ddl != nil {
+				for _, table := range ddl.Tables {
+					if contains(tables, table.Table) {
+						return true
+					}
+				}
+			}
 			return contains(tables, message.GetHeader().Table)
 		}
 		p.predicates = append(p.predicates, tablePredicate)
@@ -67,36 +180,295 @@ func (p *Parser) IncludeSchemas(schemas []string) {
 			if message.GetHeader().Schema == "" {
 				return true
 			}
+			if ddl := messageDDL(message); ddl != nil {
+				for _, schema := range ddl.Schemas {
+					if contains(schemas, schema) {
+						return true
+					}
+				}
+			}
 			return contains(schemas, message.GetHeader().Schema)
 		}
 		p.predicates = append(p.predicates, schemaPredicate)
 	}
 }
 
+// IncludeTimeRange will set a filter for messages with a binlog time within
+// [start, stop), a zero time leaves that end of the range open
+func (p *Parser) IncludeTimeRange(start, stop time.Time) {
+	if start.IsZero() && stop.IsZero() {
+		return
+	}
+	timePredicate := func(message Message) bool {
+		messageTime, err := time.Parse(time.RFC3339, message.GetHeader().BinlogMessageTime)
+		if err != nil {
+			return false
+		}
+		return (start.IsZero() || !messageTime.Before(start)) && (stop.IsZero() || messageTime.Before(stop))
+	}
+	p.predicates = append(p.predicates, timePredicate)
+}
+
+// IncludePositionRange will set a filter for messages whose event starts
+// within [start, stop) in the binlog file, a zero position leaves that end of
+// the range open
+func (p *Parser) IncludePositionRange(start, stop uint32) {
+	if start == 0 && stop == 0 {
+		return
+	}
+	positionPredicate := func(message Message) bool {
+		position := message.GetHeader().BinlogPosition
+		if event := message.GetHeader().Event; event != nil {
+			position = event.StartPosition
+		}
+		return position >= start && (stop == 0 || position < stop)
+	}
+	p.predicates = append(p.predicates, positionPredicate)
+}
+
+// StartAt skips the events before the position in the binlog file, like the
+// end of the last transaction a checkpoint names. The binlogs before it are
+// skipped, which are the binlogs parsed before it and, if it's never parsed,
+// the binlogs numbered before it.
+func (p *Parser) StartAt(binlogFile string, position uint32) {
+	p.start = &startPoint{binlogFile: binlogFile, position: position}
+}
+
+// reached tells whether an event is at or past the start point
+func (s *startPoint) reached(metadata EventMetadata) bool {
+	if metadata.BinlogFile == s.binlogFile {
+		s.seenFile = true
+		return metadata.StartPosition >= s.position
+	}
+	return s.seenFile || binlogAfter(metadata.BinlogFile, s.binlogFile)
+}
+
+// binlogAfter tells whether binlog file a is numbered after b, binlog files
+// are named by the basename and a sequence number, like mysql-bin.000042
+func binlogAfter(a, b string) bool {
+	i, j := strings.LastIndexByte(a, '.'), strings.LastIndexByte(b, '.')
+	if i < 0 || j < 0 || a[:i] != b[:j] {
+		return false
+	}
+	n, errA := strconv.ParseUint(a[i+1:], 10, 64)
+	m, errB := strconv.ParseUint(b[j+1:], 10, 64)
+	return errA == nil && errB == nil && n > m
+}
+
+// StopAt ends parsing at the first transaction that starts at or after the
+// position in the binlog file or at or after the time, so that no transaction
+// is cut short, and leaves the binlogs after it unparsed. An empty binlog file
+// matches every binlog, a zero position or time leaves that condition out.
+func (p *Parser) StopAt(binlogFile string, position uint32, stop time.Time) {
+	if position == 0 && stop.IsZero() {
+		return
+	}
+	p.stop = &stopPoint{binlogFile: binlogFile, position: position, time: stop}
+}
+
+// reached tells whether an event outside of a transaction is at or past the
+// stop point, a binlog parsed after the binlog of the stop point is past it
+func (s *stopPoint) reached(metadata EventMetadata, eventTime time.Time) bool {
+	if !s.time.IsZero() && !eventTime.Before(s.time) {
+		return true
+	}
+	if s.position == 0 {
+		return false
+	}
+	if s.binlogFile != "" && metadata.BinlogFile != s.binlogFile {
+		return s.seenFile
+	}
+	s.seenFile = true
+	return metadata.StartPosition >= s.position
+}
+
+// CaptureRowsQuery will attach the original statement logged in a
+// ROWS_QUERY_EVENT (binlog_rows_query_log_events=ON) to each row message
+func (p *Parser) CaptureRowsQuery(capture bool) {
+	p.captureRowsQuery = capture
+}
+
+// IncludeQueries will set a filter for messages whose originating statement
+// contains one of the given substrings. Row messages are matched against their
+// captured rows query, so this also enables CaptureRowsQuery.
+func (p *Parser) IncludeQueries(substrings []string) {
+	substrings = clean(substrings)
+	if len(substrings) > 0 {
+		p.captureRowsQuery = true
+		queryPredicate := func(message Message) bool {
+			query := message.GetHeader().RowsQuery
+			if queryMessage, ok := message.(QueryMessage); ok {
+				query = queryMessage.Query
+			}
+			return containsSubstring(string(query), substrings)
+		}
+		p.predicates = append(p.predicates, queryPredicate)
+	}
+}
+
+// VerifyChecksum checks the CRC32 of each event of binlogs written with
+// binlog_checksum=CRC32
+func (p *Parser) VerifyChecksum(verify bool) {
+	p.verifyChecksum = verify
+}
+
+// SkipCorrupt skips corrupt events instead of stopping at them: the function
+// is called with the error, the parser resynchronizes at the next valid event
+// header and the rows of the transaction the corrupt event was part of are
+// dropped
+func (p *Parser) SkipCorrupt(corrupt CorruptFunc) {
+	p.corrupt = corrupt
+}
+
 // ParseFile will parse the binlog and emit messages to the consumer
 // for each message
 func (p *Parser) ParseFile(filename string, offset int64) error {
-	return replication.NewBinlogParser().ParseFile(filename, 0, p.handleEvent)
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return p.ParseReader(filepath.Base(filename), f)
+}
+
+// ParseReader parses the binlog read from r like ParseFile, binlogFile is the
+// name messages carry
+func (p *Parser) ParseReader(binlogFile string, r io.Reader) error {
+	if p.stopped {
+		return nil
+	}
+	events, err := NewEventReader(binlogFile, r)
+	if err != nil {
+		return err
+	}
+	return p.parseEvents(events)
+}
+
+// parseEvents handles the events of the reader until the end of the binlog
+func (p *Parser) parseEvents(events *EventReader) error {
+	p.binlogFile = events.BinlogFile
+	events.VerifyChecksum = p.verifyChecksum
+	for {
+		e, err := events.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if corrupt, ok := err.(*CorruptEventError); ok && p.corrupt != nil {
+			if err := p.corrupt(corrupt); err != nil {
+				return err
+			}
+			p.rowRowsEventBuffer.drain()
+			p.transaction = nil
+			p.inTransaction = false
+			p.rowsQuery = ""
+			p.skipRows = true
+			if _, err := events.Resync(); err == io.EOF {
+				return nil
+			} else if err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		p.position = uint32(events.Offset()) - e.Header.EventSize
+		p.relay = events.Relay
+		if err := p.handleEvent(e); err == errStop {
+			p.stopped = true
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
 }
 
 func (p *Parser) handleEvent(e *replication.BinlogEvent) error {
-	e.Dump(os.Stdout)
+	if e.Header.Flags&(logEventArtificial|logEventRelayLog) != 0 {
+		// bookkeeping of the relay log or the replication stream, the rotate
+		// event of the source names the binlog the events come from
+		if rotate, ok := e.Event.(*replication.RotateEvent); ok && e.Header.Flags&logEventRelayLog == 0 {
+			p.sourceFile = string(rotate.NextLogName)
+		}
+		return nil
+	}
+	metadata := p.eventMetadata(e.Header)
+	if p.start != nil && !p.start.reached(metadata) {
+		return nil
+	}
+	if p.stop != nil && !p.inTransaction && p.stop.reached(metadata, time.Unix(int64(e.Header.Timestamp), 0).UTC()) {
+		return errStop
+	}
+	if payload, ok := e.Event.(*TransactionPayloadEvent); ok {
+		metadata.UncompressedSize = uint32(payload.UncompressedSize)
+	}
+	if p.event != nil {
+		if err := p.event(metadata, time.Unix(int64(e.Header.Timestamp), 0).UTC()); err != nil {
+			return err
+		}
+	}
+	p.trackTransaction(e, metadata)
 	switch e.Header.EventType {
 	case replication.QUERY_EVENT:
 		queryEvent := e.Event.(*replication.QueryEvent)
 		query := string(queryEvent.Query)
+		if strings.ToUpper(strings.Trim(query, " ")) == "BEGIN" {
+			p.skipRows = false
+			p.inTransaction = true
+		}
 		if strings.ToUpper(strings.Trim(query, " ")) != "BEGIN" && !strings.HasPrefix(strings.ToUpper(strings.Trim(query, " ")), "SAVEPOINT") {
-			if err := p.sendMessage(ConvertQueryEventToMessage(*e.Header, *queryEvent)); err != nil {
+			// DDL statements commit implicitly
+			p.transaction = nil
+			p.inTransaction = false
+			message := ConvertQueryEventToMessage(*e.Header, *queryEvent)
+			header := message.GetHeader()
+			header.Event = &metadata
+			if err := p.sendMessage(WithHeader(message, header)); err != nil {
 				return err
 			}
+			if messageDDL(message) != nil {
+				p.gtid = ""
+			}
 		}
 	case replication.XID_EVENT:
 		xidEvent := e.Event.(*replication.XIDEvent)
+		if err := p.endTransaction(uint64(xidEvent.XID), e.Header, metadata); err != nil {
+			return err
+		}
+		p.rowsQuery = ""
+		p.gtid = ""
+		p.skipRows = false
+		p.inTransaction = false
 		for _, message := range ConvertRowsEventsToMessages(uint64(xidEvent.XID), p.rowRowsEventBuffer.drain()) {
 			if err := p.sendMessage(message); err != nil {
 				return err
 			}
 		}
+		if p.commit != nil {
+			if err := p.commit(uint64(xidEvent.XID)); err != nil {
+				return err
+			}
+		}
+	case replication.GTID_EVENT:
+		gtidEvent := e.Event.(*replication.GTIDEvent)
+		p.gtid = formatGTID(gtidEvent.SID, gtidEvent.GNO)
+		p.skipRows = false
+		p.inTransaction = true
+	case replication.ANONYMOUS_GTID_EVENT:
+		p.inTransaction = true
+	case replication.MARIADB_GTID_EVENT:
+		gtidEvent := e.Event.(*replication.MariadbGTIDEvent)
+		p.gtid = gtidEvent.GTID.String()
+		p.skipRows = false
+		p.inTransaction = true
+	case replication.ROWS_QUERY_EVENT:
+		if p.captureRowsQuery {
+			p.rowsQuery = SQLQuery(e.Event.(*replication.RowsQueryEvent).Query)
+		}
+	case replication.MARIADB_ANNOTATE_ROWS_EVENT:
+		if p.captureRowsQuery {
+			p.rowsQuery = SQLQuery(e.Event.(*replication.MariadbAnnotateRowsEvent).Query)
+		}
 	case replication.TABLE_MAP_EVENT:
 		tableMapEvent := e.Event.(*replication.TableMapEvent)
 		schema := string(tableMapEvent.Schema)
@@ -105,19 +477,155 @@ func (p *Parser) handleEvent(e *replication.BinlogEvent) error {
 		if err := p.db.Map.Add(tableID, schema, table); err != nil {
 			return err
 		}
+	case replication.ROTATE_EVENT:
+		if p.relay {
+			p.sourceFile = string(e.Event.(*replication.RotateEvent).NextLogName)
+		}
+	case transactionPayloadEvent:
+		if err := p.handlePayload(e.Event.(*TransactionPayloadEvent), metadata); err != nil {
+			return err
+		}
 	case replication.WRITE_ROWS_EVENTv1, replication.UPDATE_ROWS_EVENTv1, replication.DELETE_ROWS_EVENTv1, replication.WRITE_ROWS_EVENTv2, replication.UPDATE_ROWS_EVENTv2, replication.DELETE_ROWS_EVENTv2:
+		if p.skipRows {
+			// the transaction lost events to corruption
+			return nil
+		}
 		rowsEvent := e.Event.(*replication.RowsEvent)
 		tableID := uint64(rowsEvent.TableID)
 		tableMetadata, ok := p.db.Map.LookupTableMetadata(tableID)
 		if !ok {
 			return nil
 		}
-		p.rowRowsEventBuffer.bufferRowsEventData(NewRowsEventData(*e.Header, *rowsEvent, tableMetadata))
+		rowsEventData := NewRowsEventData(*e.Header, *rowsEvent, tableMetadata)
+		rowsEventData.RowsQuery = p.rowsQuery
+		rowsEventData.Event = metadata
+		p.rowRowsEventBuffer.bufferRowsEventData(rowsEventData)
 	}
 	return nil
 }
 
+// handlePayload handles the events of a compressed transaction like the
+// events of the binlog, at the position of the payload event
+func (p *Parser) handlePayload(payload *TransactionPayloadEvent, metadata EventMetadata) error {
+	p.payload = &metadata
+	defer func() { p.payload = nil }()
+	for _, e := range payload.Events {
+		e.Header.LogPos = metadata.StartPosition + metadata.EventSize
+		if err := p.handleEvent(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// eventMetadata builds the metadata of the current event and advances the
+// tracked file offset past it. Events of a compressed transaction take the
+// offset of their payload event, events of a relay log the position in the
+// binlog of the source, named by the last rotate event of the source.
+func (p *Parser) eventMetadata(binlogEventHeader *replication.EventHeader) EventMetadata {
+	metadata := NewEventMetadata(*binlogEventHeader)
+	metadata.BinlogFile = p.binlogFile
+	metadata.StartPosition = p.position
+	metadata.GTID = p.gtid
+	if p.payload != nil {
+		metadata.BinlogFile = p.payload.BinlogFile
+		metadata.StartPosition = p.payload.StartPosition
+		metadata.RelayLogFile = p.payload.RelayLogFile
+		metadata.RelayLogPosition = p.payload.RelayLogPosition
+		metadata.Compressed = true
+		return metadata
+	}
+	if p.relay {
+		metadata.RelayLogFile = p.binlogFile
+		metadata.RelayLogPosition = p.position
+		metadata.StartPosition = 0
+		if binlogEventHeader.LogPos >= binlogEventHeader.EventSize {
+			metadata.StartPosition = binlogEventHeader.LogPos - binlogEventHeader.EventSize
+		}
+		if p.sourceFile != "" {
+			metadata.BinlogFile = p.sourceFile
+		}
+	}
+	p.position += binlogEventHeader.EventSize
+	return metadata
+}
+
+// trackTransaction starts a transaction at a GTID or BEGIN event and adds the
+// size of the events to it
+func (p *Parser) trackTransaction(e *replication.BinlogEvent, metadata EventMetadata) {
+	if p.transactionFunc == nil {
+		return
+	}
+	start := false
+	switch e.Header.EventType {
+	case replication.GTID_EVENT, replication.ANONYMOUS_GTID_EVENT, replication.MARIADB_GTID_EVENT:
+		start = true
+	case replication.QUERY_EVENT:
+		start = p.transaction == nil && strings.ToUpper(strings.Trim(string(e.Event.(*replication.QueryEvent).Query), " ")) == "BEGIN"
+	}
+	if start {
+		p.transaction = &Transaction{
+			BinlogFile:    metadata.BinlogFile,
+			StartPosition: metadata.StartPosition,
+			Begin:         time.Unix(int64(e.Header.Timestamp), 0).UTC(),
+		}
+		if p.payload != nil {
+			// the transaction starts with the BEGIN of its payload
+			p.transaction.Bytes = uint64(p.payload.EventSize)
+		}
+	}
+	if p.transaction != nil && !metadata.Compressed {
+		p.transaction.Bytes += uint64(metadata.EventSize)
+	}
+}
+
+// endTransaction summarizes the buffered rows events of the transaction
+// ending with the XID event
+func (p *Parser) endTransaction(xid uint64, header *replication.EventHeader, metadata EventMetadata) error {
+	transaction := p.transaction
+	p.transaction = nil
+	if p.transactionFunc == nil {
+		return nil
+	}
+	end := time.Unix(int64(header.Timestamp), 0).UTC()
+	// the XID event of a compressed transaction ends with its payload event
+	last := metadata
+	if p.payload != nil {
+		last = *p.payload
+	}
+	if transaction == nil {
+		transaction = &Transaction{BinlogFile: last.BinlogFile, StartPosition: last.StartPosition, Begin: end, Bytes: uint64(last.EventSize)}
+	}
+	transaction.XID = xid
+	transaction.GTID = p.gtid
+	transaction.EndPosition = last.StartPosition + last.EventSize
+	transaction.End = end
+
+	seen := map[TableName]bool{}
+	for _, rowsEventData := range p.rowRowsEventBuffer.buffered {
+		rows := len(rowsEventData.BinlogEvent.Rows)
+		switch rowsEventData.BinlogEventHeader.EventType {
+		case replication.UPDATE_ROWS_EVENTv1, replication.UPDATE_ROWS_EVENTv2:
+			rows /= 2
+		}
+		transaction.Rows += rows
+		table := TableName{Schema: rowsEventData.TableMetadata.Schema, Table: rowsEventData.TableMetadata.Table}
+		if !seen[table] {
+			seen[table] = true
+			transaction.Tables = append(transaction.Tables, table)
+		}
+		if transaction.FirstQuery == "" {
+			transaction.FirstQuery = rowsEventData.RowsQuery
+		}
+	}
+	return p.transactionFunc(*transaction)
+}
+
 func (p *Parser) sendMessage(message Message) error {
+	if header := message.GetHeader(); header.SchemaVersion != p.schemaVersion {
+		header.SchemaVersion = p.schemaVersion
+		message = WithHeader(message, header)
+	}
 	for _, predicate := range p.predicates {
 		pass := predicate(message)
 		if !pass {
@@ -127,6 +635,21 @@ func (p *Parser) sendMessage(message Message) error {
 	return p.consumer(message)
 }
 
+// formatGTID formats a MySQL GTID as source_id:transaction_id
+func formatGTID(sid []byte, gno int64) string {
+	if len(sid) != 16 {
+		return ""
+	}
+	return fmt.Sprintf("%x-%x-%x-%x-%x:%d", sid[0:4], sid[4:6], sid[6:8], sid[8:10], sid[10:16], gno)
+}
+
+func messageDDL(message Message) *DDLStatement {
+	if queryMessage, ok := message.(QueryMessage); ok {
+		return queryMessage.DDL
+	}
+	return nil
+}
+
 func clean(items []string) (arr []string) {
 	for _, item := range items {
 		item = strings.TrimSpace(item)
@@ -137,6 +660,15 @@ func clean(items []string) (arr []string) {
 	return
 }
 
+func containsSubstring(s string, substrings []string) bool {
+	for _, substring := range substrings {
+		if strings.Contains(s, substring) {
+			return true
+		}
+	}
+	return false
+}
+
 func contains(s []string, e string) bool {
 	for _, a := range s {
 		if a == e {
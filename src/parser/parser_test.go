@@ -3,9 +3,12 @@ package parser
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"reflect"
 	"testing"
 	"time"
+
+	"github.com/tanema/binlog-parser/src/database"
 )
 
 func TestParser(t *testing.T) {
@@ -58,6 +61,98 @@ func TestParser(t *testing.T) {
 			t.Fatal("unexpected output")
 		}
 	})
+
+	t.Run("Filter table, matches any DDL table", func(t *testing.T) {
+		p, buf := createParserWithConsumer()
+		p.IncludeTables([]string{"lookup"})
+		ddl, _ := ParseDDL("DROP TABLE filler, lookup", "database_name")
+		ddlMessage := NewQueryMessage(NewMessageHeader("database_name", "filler", time.Now(), 100, 0), "DROP TABLE filler, lookup")
+		ddlMessage.DDL = ddl
+		p.sendMessage(ddlMessage)
+		if buf.String() == "" {
+			t.Fatal("no output")
+		}
+	})
+
+	t.Run("Filter time range", func(t *testing.T) {
+		messageTime, _ := time.Parse(time.RFC3339, message.GetHeader().BinlogMessageTime)
+		testCases := []struct {
+			start, stop time.Time
+			pass        bool
+		}{
+			{messageTime, time.Time{}, true},
+			{time.Time{}, messageTime, false},
+			{messageTime.Add(-time.Minute), messageTime.Add(time.Minute), true},
+			{messageTime.Add(time.Second), time.Time{}, false},
+		}
+		for _, tc := range testCases {
+			p, buf := createParserWithConsumer()
+			p.IncludeTimeRange(tc.start, tc.stop)
+			p.sendMessage(message)
+			if (buf.String() != "") != tc.pass {
+				t.Fatal(fmt.Sprintf("Unexpected filter result for range %s - %s", tc.start, tc.stop))
+			}
+		}
+	})
+
+	t.Run("Filter position range", func(t *testing.T) {
+		header := message.GetHeader()
+		header.Event = &EventMetadata{StartPosition: 50}
+		positioned := WithHeader(message, header)
+		testCases := []struct {
+			start, stop uint32
+			pass        bool
+		}{
+			{50, 0, true},
+			{0, 50, false},
+			{4, 51, true},
+			{51, 100, false},
+		}
+		for _, tc := range testCases {
+			p, buf := createParserWithConsumer()
+			p.IncludePositionRange(tc.start, tc.stop)
+			p.sendMessage(positioned)
+			if (buf.String() != "") != tc.pass {
+				t.Fatal(fmt.Sprintf("Unexpected filter result for range %d - %d", tc.start, tc.stop))
+			}
+		}
+	})
+
+	t.Run("Filter queries, passes through", func(t *testing.T) {
+		p, buf := createParserWithConsumer()
+		p.IncludeQueries([]string{"FROM table"})
+		p.sendMessage(message)
+		if buf.String() == "" {
+			t.Fatal("no output")
+		}
+	})
+
+	t.Run("Filter queries, filtered out", func(t *testing.T) {
+		p, buf := createParserWithConsumer()
+		p.IncludeQueries([]string{"/* app:orders */"})
+		p.sendMessage(message)
+		if buf.String() != "" {
+			t.Fatal("unexpected output")
+		}
+	})
+
+	t.Run("Filter queries, matches rows query", func(t *testing.T) {
+		p, buf := createParserWithConsumer()
+		p.IncludeQueries([]string{"/* app:orders */"})
+		if !p.captureRowsQuery {
+			t.Fatal("expected rows query capture to be enabled")
+		}
+		header := NewMessageHeader("database_name", "table_name", time.Now(), 100, 100)
+		p.sendMessage(NewInsertMessage(header, MessageRowData{}))
+		if buf.String() != "" {
+			t.Fatal("unexpected output")
+		}
+		header.RowsQuery = "INSERT INTO table_name VALUES (1) /* app:orders */"
+		p.sendMessage(NewInsertMessage(header, MessageRowData{}))
+		if buf.String() == "" {
+			t.Fatal("no output")
+		}
+	})
 }
 
 func TestRowsEventBuffer(t *testing.T) {
@@ -113,3 +208,62 @@ func createParserWithConsumer() (Parser, *bytes.Buffer) {
 	}}
 	return p, buf
 }
+
+func TestParserTransactions(t *testing.T) {
+	var transactions []Transaction
+	p := New(database.Offline(), func(Message) error { return nil })
+	p.OnTransaction(func(transaction Transaction) error {
+		transactions = append(transactions, transaction)
+		return nil
+	})
+	if err := p.ParseFile("../../test/data/fixtures/mysql-bin.01", 0); err != nil {
+		t.Fatal(err)
+	}
+	if len(transactions) != 4 {
+		t.Fatal(fmt.Sprintf("Expected 4 transactions, got %d", len(transactions)))
+	}
+	expected := Transaction{
+		XID:           10,
+		BinlogFile:    "mysql-bin.01",
+		StartPosition: 428,
+		EndPosition:   723,
+		Begin:         time.Date(2017, 4, 13, 6, 34, 37, 0, time.UTC),
+		End:           time.Date(2017, 4, 13, 6, 34, 37, 0, time.UTC),
+		Rows:          5,
+		Bytes:         295,
+		Tables:        []TableName{{Schema: "test_db", Table: "rooms"}},
+	}
+	if !reflect.DeepEqual(transactions[1], expected) {
+		t.Fatal(fmt.Sprintf("Expected %+v, got %+v", expected, transactions[1]))
+	}
+}
+
+func TestParserStartAt(t *testing.T) {
+	testCases := []struct {
+		binlogFile string
+		position   uint32
+		xids       []uint64
+	}{
+		{"mysql-bin.000041", 723, []uint64{12, 14}},
+		{"mysql-bin.000041", 1257, nil},
+		{"mysql-bin.000040", 1257, []uint64{9, 10, 12, 14}},
+		{"mysql-bin.000042", 4, nil},
+		{"other-bin.000040", 4, nil},
+	}
+	for _, tc := range testCases {
+		var xids []uint64
+		p := New(database.Offline(), func(message Message) error { return nil })
+		p.OnCommit(func(xid uint64) error {
+			xids = append(xids, xid)
+			return nil
+		})
+		p.StartAt(tc.binlogFile, tc.position)
+		data, _ := fixtureEvents(t, "mysql-bin.01")
+		if err := p.ParseReader("mysql-bin.000041", bytes.NewReader(data)); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(xids, tc.xids) {
+			t.Fatal(fmt.Sprintf("Expected the transactions %v after %s:%d, got %v", tc.xids, tc.binlogFile, tc.position, xids))
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package parser
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/siddontang/go-mysql/mysql"
+	"github.com/siddontang/go-mysql/replication"
+)
+
+// transactionPayloadEvent is the TRANSACTION_PAYLOAD_EVENT MySQL 8.0.20+
+// writes for each transaction with binlog_transaction_compression=ON, it's
+// unknown to go-mysql
+const transactionPayloadEvent = replication.EventType(40)
+
+// the fields of the header of a transaction payload event
+const (
+	payloadHeaderEnd        = 0
+	payloadSizeField        = 1
+	payloadCompressionField = 2
+	payloadUncompressedSize = 3
+)
+
+// the compression types of a transaction payload event
+const (
+	payloadCompressionZstd = 0
+	payloadCompressionNone = 255
+)
+
+// TransactionPayloadEvent is a compressed transaction, Events are the events
+// of the transaction, from the BEGIN query to the XID event
+type TransactionPayloadEvent struct {
+	CompressionType  uint64
+	PayloadSize      uint64
+	UncompressedSize uint64
+	Payload          []byte
+	Events           []*replication.BinlogEvent
+	// decoder is the zstd decoder of the EventReader, it's shared by the
+	// payloads of the binlog
+	decoder *zstd.Decoder
+}
+
+// Decode reads the header of the event and decompresses its payload, the
+// events are decoded by the EventReader that keeps the table maps
+func (e *TransactionPayloadEvent) Decode(data []byte) error {
+	e.PayloadSize = uint64(len(data))
+	pos := 0
+	readInt := func() (uint64, error) {
+		if pos >= len(data) {
+			return 0, io.ErrUnexpectedEOF
+		}
+		value, _, n := mysql.LengthEncodedInt(data[pos:])
+		if n == 0 || pos+n > len(data) {
+			return 0, io.ErrUnexpectedEOF
+		}
+		pos += n
+		return value, nil
+	}
+	for {
+		field, err := readInt()
+		if err != nil {
+			return fmt.Errorf("truncated transaction payload header")
+		}
+		if field == payloadHeaderEnd {
+			break
+		}
+		length, err := readInt()
+		if err != nil || pos+int(length) > len(data) {
+			return fmt.Errorf("truncated transaction payload header")
+		}
+		end := pos + int(length)
+		switch field {
+		case payloadSizeField:
+			e.PayloadSize, err = readInt()
+		case payloadCompressionField:
+			e.CompressionType, err = readInt()
+		case payloadUncompressedSize:
+			e.UncompressedSize, err = readInt()
+		}
+		if err != nil {
+			return fmt.Errorf("truncated transaction payload header")
+		}
+		pos = end
+	}
+
+	if e.UncompressedSize > maxEventSize {
+		return fmt.Errorf("transaction payload of %d bytes is too large", e.UncompressedSize)
+	}
+	payload := data[pos:]
+	switch e.CompressionType {
+	case payloadCompressionZstd:
+		decoder, err := e.decoder, error(nil)
+		if decoder == nil {
+			// decoded outside of an EventReader
+			if decoder, err = zstd.NewReader(nil, zstd.WithDecoderMaxMemory(maxEventSize)); err != nil {
+				return err
+			}
+			defer decoder.Close()
+		}
+		if e.Payload, err = decoder.DecodeAll(payload, make([]byte, 0, e.UncompressedSize)); err != nil {
+			return fmt.Errorf("can't decompress transaction payload: %s", err)
+		}
+	case payloadCompressionNone:
+		e.Payload = payload
+	default:
+		return fmt.Errorf("unknown transaction payload compression %d", e.CompressionType)
+	}
+	if e.UncompressedSize == 0 {
+		e.UncompressedSize = uint64(len(e.Payload))
+	}
+	return nil
+}
+
+// Dump prints the event like the events of go-mysql
+func (e *TransactionPayloadEvent) Dump(w io.Writer) {
+	fmt.Fprintf(w, "Compression type: %d\n", e.CompressionType)
+	fmt.Fprintf(w, "Payload size: %d\n", e.PayloadSize)
+	fmt.Fprintf(w, "Uncompressed size: %d\n", e.UncompressedSize)
+	fmt.Fprintf(w, "Events: %d\n", len(e.Events))
+	fmt.Fprintln(w)
+}
+
+// decodePayload decodes the events of a transaction payload with the parser
+// of the binlog, so rows events find the table maps before them
+func (r *EventReader) decodePayload(payload *TransactionPayloadEvent) error {
+	for data := payload.Payload; len(data) > 0; {
+		if len(data) < replication.EventHeaderSize {
+			return fmt.Errorf("truncated event in transaction payload")
+		}
+		size := binary.LittleEndian.Uint32(data[9:])
+		if size < uint32(replication.EventHeaderSize) || int(size) > len(data) {
+			return fmt.Errorf("invalid event size %d in transaction payload", size)
+		}
+		e, err := r.parser.Parse(withChecksum(data[:size], r.Checksum))
+		if err != nil {
+			return fmt.Errorf("%s in transaction payload", err)
+		}
+		payload.Events = append(payload.Events, e)
+		data = data[size:]
+	}
+	return nil
+}
+
+// withChecksum appends a CRC32 to an event of a payload that has none when
+// the binlog has checksums, since the parser expects one on every event
+func withChecksum(data []byte, checksum byte) []byte {
+	if checksum != replication.BINLOG_CHECKSUM_ALG_CRC32 {
+		return data
+	}
+	if n := len(data) - replication.BinlogChecksumLength; n >= replication.EventHeaderSize && crc32.ChecksumIEEE(data[:n]) == binary.LittleEndian.Uint32(data[n:]) {
+		return data
+	}
+	event := make([]byte, len(data), len(data)+replication.BinlogChecksumLength)
+	copy(event, data)
+	binary.LittleEndian.PutUint32(event[9:], uint32(len(data)+replication.BinlogChecksumLength))
+	return binary.LittleEndian.AppendUint32(event, crc32.ChecksumIEEE(event))
+}
@@ -0,0 +1,122 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/tanema/binlog-parser/src/database"
+)
+
+// mysql-bin.08 is mysql-bin.01 with every transaction compressed into a
+// TRANSACTION_PAYLOAD_EVENT, the events of the first one keep their checksum.
+// It's rewritten from mysql-bin.01 rather than written by a MySQL 8.0.20+
+// server, a binlog of a server with binlog_transaction_compression=ON is
+// still missing from the fixtures.
+func TestParserTransactionPayload(t *testing.T) {
+	parse := func(name string) ([]Message, []Transaction) {
+		var messages []Message
+		var transactions []Transaction
+		p := New(database.Offline(), func(message Message) error {
+			messages = append(messages, message)
+			return nil
+		})
+		p.OnTransaction(func(transaction Transaction) error {
+			transactions = append(transactions, transaction)
+			return nil
+		})
+		p.VerifyChecksum(true)
+		if err := p.ParseFile("../../test/data/fixtures/"+name, 0); err != nil {
+			t.Fatal(err)
+		}
+		return messages, transactions
+	}
+	expected, _ := parse("mysql-bin.01")
+	messages, transactions := parse("mysql-bin.08")
+
+	if len(messages) != len(expected) {
+		t.Fatal(fmt.Sprintf("Expected %d messages, got %d", len(expected), len(messages)))
+	}
+	for i, message := range messages {
+		header, expectedHeader := message.GetHeader(), expected[i].GetHeader()
+		if header.Schema != expectedHeader.Schema || header.Table != expectedHeader.Table || header.XID != expectedHeader.XID {
+			t.Fatal(fmt.Sprintf("Expected message %+v, got %+v", expectedHeader, header))
+		}
+		data, _ := json.Marshal(WithHeader(message, MessageHeader{}))
+		expectedData, _ := json.Marshal(WithHeader(expected[i], MessageHeader{}))
+		if string(data) != string(expectedData) {
+			t.Fatal(fmt.Sprintf("Expected message %s, got %s", expectedData, data))
+		}
+		for _, transaction := range transactions {
+			if transaction.XID == header.XID && (!header.Event.Compressed || header.Event.StartPosition != transaction.StartPosition || header.BinlogPosition != transaction.EndPosition) {
+				t.Fatal(fmt.Sprintf("Expected the position of the payload event %+v, got %d, %+v", transaction, header.BinlogPosition, header.Event))
+			}
+		}
+	}
+
+	testCases := []struct {
+		xid           uint64
+		startPosition uint32
+		endPosition   uint32
+		rows          int
+	}{
+		{9, 120, 400, 2},
+		{10, 400, 648, 5},
+		{12, 648, 883, 2},
+		{14, 883, 1119, 1},
+	}
+	if len(transactions) != len(testCases) {
+		t.Fatal(fmt.Sprintf("Expected %d transactions, got %d", len(testCases), len(transactions)))
+	}
+	for i, tc := range testCases {
+		transaction := transactions[i]
+		if transaction.XID != tc.xid || transaction.StartPosition != tc.startPosition || transaction.EndPosition != tc.endPosition || transaction.Rows != tc.rows {
+			t.Fatal(fmt.Sprintf("Expected transaction %+v, got %+v", tc, transaction))
+		}
+	}
+}
+
+func TestEventReaderPayloadDecoder(t *testing.T) {
+	data, _ := fixtureEvents(t, "mysql-bin.08")
+	events, err := NewEventReader("mysql-bin.08", bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var payloads []*TransactionPayloadEvent
+	for {
+		e, err := events.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		if payload, ok := e.Event.(*TransactionPayloadEvent); ok {
+			payloads = append(payloads, payload)
+		}
+	}
+	if len(payloads) != 4 {
+		t.Fatal(fmt.Sprintf("Expected 4 payloads, got %d", len(payloads)))
+	}
+	for _, payload := range payloads {
+		if payload.decoder == nil || payload.decoder != payloads[0].decoder {
+			t.Fatal("Expected the payloads to share the decoder of the reader")
+		}
+	}
+}
+
+func TestTransactionPayloadCorruptHeader(t *testing.T) {
+	testCases := []struct {
+		name string
+		data []byte
+	}{
+		{"truncated header", []byte{payloadUncompressedSize, 9, 0xfe, 0xff}},
+		{"huge uncompressed size", []byte{payloadUncompressedSize, 9, 0xfe, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x7f, payloadHeaderEnd, 0x28, 0xb5, 0x2f, 0xfd}},
+	}
+	for _, tc := range testCases {
+		if err := new(TransactionPayloadEvent).Decode(tc.data); err == nil {
+			t.Fatal(fmt.Sprintf("Expected an error for %s", tc.name))
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package parser
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/siddontang/go-mysql/replication"
+)
+
+// eventReaderBufferSize is the read buffer of an EventReader, events up to
+// this size are checksummed while resynchronizing
+const eventReaderBufferSize = 1 << 20
+
+// maxEventSize is the largest event an EventReader reads, MySQL doesn't write
+// events larger than max_allowed_packet, which is at most 1 GiB
+const maxEventSize = 1 << 30
+
+// lastEventType is HEARTBEAT_LOG_EVENT_V2, the last event type of MySQL 8.0,
+// go-mysql knows the event types up to PREVIOUS_GTIDS_EVENT only
+const lastEventType = replication.EventType(41)
+
+// the flags of an event header that mark the events of relay logs and
+// replication streams that aren't events of the source binlog
+const (
+	// logEventArtificial is LOG_EVENT_ARTIFICIAL_F, set on the events the
+	// source makes up for the replication stream, like its first rotate event
+	logEventArtificial = 0x20
+	// logEventRelayLog is LOG_EVENT_RELAY_LOG_F, set on the events the replica
+	// writes to its relay log itself
+	logEventRelayLog = 0x40
+)
+
+// CorruptEventError describes a bad event of a binlog by its offset in the
+// file
+type CorruptEventError struct {
+	BinlogFile string
+	Offset     int64
+	Reason     string
+}
+
+func (e *CorruptEventError) Error() string {
+	return fmt.Sprintf("corrupt event in %s at offset %d: %s", e.BinlogFile, e.Offset, e.Reason)
+}
+
+// EventReader reads the events of a binlog one at a time and checks that each
+// is complete, starts where the previous one ended and, if VerifyChecksum is
+// set and the binlog was written with binlog_checksum=CRC32, matches its
+// checksum. A bad event is returned as CorruptEventError, after which Resync
+// can skip to the next valid event.
+type EventReader struct {
+	BinlogFile     string
+	VerifyChecksum bool
+	// Checksum is the checksum algorithm of the format description event,
+	// replication.BINLOG_CHECKSUM_ALG_CRC32 if events have a CRC32
+	Checksum byte
+	// Relay is set for relay logs and the output of mysqlbinlog --raw, their
+	// events carry the positions of the source binlog rather than their
+	// offsets in the file, so positions aren't checked
+	Relay   bool
+	rotated bool
+	in      *bufio.Reader
+	offset  int64
+	parser  *replication.BinlogParser
+	// zstd decompresses the transaction payloads, it's created with the first
+	// one
+	zstd *zstd.Decoder
+}
+
+// NewEventReader checks the magic number at the start of the binlog and
+// creates a reader for its events
+func NewEventReader(binlogFile string, r io.Reader) (*EventReader, error) {
+	in := bufio.NewReaderSize(r, eventReaderBufferSize)
+	magic := make([]byte, len(replication.BinLogFileHeader))
+	if _, err := io.ReadFull(in, magic); err != nil || !bytes.Equal(magic, replication.BinLogFileHeader) {
+		return nil, fmt.Errorf("%s is not a binlog file, it doesn't start with the magic number", binlogFile)
+	}
+	return &EventReader{
+		BinlogFile: binlogFile,
+		Checksum:   replication.BINLOG_CHECKSUM_ALG_UNDEF,
+		in:         in,
+		offset:     int64(len(magic)),
+		parser:     replication.NewBinlogParser(),
+	}, nil
+}
+
+// Offset is the position of the next event in the file
+func (r *EventReader) Offset() int64 {
+	return r.offset
+}
+
+// Next reads and decodes the next event, it returns io.EOF at the end of the
+// file. The events of a compressed transaction are decoded into its
+// TransactionPayloadEvent.
+func (r *EventReader) Next() (*replication.BinlogEvent, error) {
+	header, err := r.in.Peek(replication.EventHeaderSize)
+	if len(header) == 0 && err == io.EOF {
+		return nil, io.EOF
+	} else if err == io.EOF {
+		return nil, r.corrupt("truncated event header, %d of %d bytes", len(header), replication.EventHeaderSize)
+	} else if err != nil {
+		return nil, err
+	}
+	size := binary.LittleEndian.Uint32(header[9:])
+	next := binary.LittleEndian.Uint32(header[13:])
+	if size < uint32(replication.EventHeaderSize) || size > maxEventSize {
+		return nil, r.corrupt("invalid event size %d", size)
+	}
+	// a binlog ends with its rotate event, a stream goes on with the events of
+	// the next binlog
+	if r.rotated || binary.LittleEndian.Uint16(header[17:])&logEventRelayLog != 0 {
+		r.Relay = true
+	}
+	if next != 0 && !r.Relay && int64(next) != r.offset+int64(size) {
+		return nil, r.corrupt("event ends at %d but the next position is %d", r.offset+int64(size), next)
+	}
+
+	start := r.offset
+	// the buffer grows with the data read rather than with the size in the
+	// header, so a bad size fails at the end of the input without taking the
+	// memory up front
+	event := bytes.NewBuffer(make([]byte, 0, minSize(size, eventReaderBufferSize)))
+	n, err := io.CopyN(event, r.in, int64(size))
+	r.offset += n
+	if err == io.EOF {
+		return nil, &CorruptEventError{BinlogFile: r.BinlogFile, Offset: start, Reason: fmt.Sprintf("truncated event, %d of %d bytes", n, size)}
+	} else if err != nil {
+		return nil, err
+	}
+	data := event.Bytes()
+	r.parser.SetVerifyChecksum(r.VerifyChecksum)
+	e, err := r.parser.Parse(data)
+	if err == replication.ErrChecksumMismatch {
+		return nil, &CorruptEventError{BinlogFile: r.BinlogFile, Offset: start, Reason: "checksum mismatch"}
+	} else if err != nil {
+		return nil, &CorruptEventError{BinlogFile: r.BinlogFile, Offset: start, Reason: err.Error()}
+	}
+	switch event := e.Event.(type) {
+	case *replication.FormatDescriptionEvent:
+		r.Checksum = event.ChecksumAlgorithm
+		// the source sends its format description event without position when
+		// the stream doesn't start at the beginning of the binlog
+		r.Relay = r.Relay || e.Header.LogPos == 0
+	case *replication.RotateEvent:
+		r.rotated = true
+	}
+	if e.Header.EventType == transactionPayloadEvent {
+		if r.zstd == nil {
+			// without input the decoder starts no goroutines, it needs no Close
+			if r.zstd, err = zstd.NewReader(nil, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxMemory(maxEventSize)); err != nil {
+				return nil, err
+			}
+		}
+		payload := &TransactionPayloadEvent{decoder: r.zstd}
+		if err := payload.Decode(e.Event.(*replication.GenericEvent).Data); err != nil {
+			return nil, &CorruptEventError{BinlogFile: r.BinlogFile, Offset: start, Reason: err.Error()}
+		}
+		if err := r.decodePayload(payload); err != nil {
+			return nil, &CorruptEventError{BinlogFile: r.BinlogFile, Offset: start, Reason: err.Error()}
+		}
+		e.Event = payload
+	}
+	return e, nil
+}
+
+// corrupt creates the error for an event with a bad header and skips its first
+// byte, so Resync looks for the next event after it
+func (r *EventReader) corrupt(format string, args ...interface{}) error {
+	err := &CorruptEventError{BinlogFile: r.BinlogFile, Offset: r.offset, Reason: fmt.Sprintf(format, args...)}
+	n, _ := r.in.Discard(1)
+	r.offset += int64(n)
+	return err
+}
+
+// Resync skips to the next offset after a corrupt event where a valid event
+// header starts and returns the number of bytes skipped. A header is valid if
+// its event type is known and, unless the binlog is a relay log, its next
+// position matches its size, events with a CRC32 that fit the read buffer must
+// also match their checksum. It returns
+// io.EOF if no valid event follows.
+func (r *EventReader) Resync() (int64, error) {
+	skipped := int64(0)
+	for {
+		header, err := r.in.Peek(replication.EventHeaderSize)
+		if err == io.EOF {
+			n, _ := r.in.Discard(len(header))
+			r.offset += int64(n)
+			return skipped + int64(n), io.EOF
+		} else if err != nil {
+			return skipped, err
+		}
+		if r.validHeader(header) {
+			return skipped, nil
+		}
+		if _, err := r.in.Discard(1); err != nil {
+			return skipped, err
+		}
+		r.offset++
+		skipped++
+	}
+}
+
+func (r *EventReader) validHeader(header []byte) bool {
+	eventType := header[4]
+	size := binary.LittleEndian.Uint32(header[9:])
+	next := binary.LittleEndian.Uint32(header[13:])
+	if !(eventType >= byte(replication.START_EVENT_V3) && eventType <= byte(lastEventType)) && !(eventType >= byte(replication.MARIADB_ANNOTATE_ROWS_EVENT) && eventType <= byte(replication.MARIADB_GTID_LIST_EVENT)) {
+		return false
+	}
+	if size < uint32(replication.EventHeaderSize) || size > maxEventSize || !r.Relay && int64(next) != r.offset+int64(size) {
+		return false
+	}
+	if r.Checksum != replication.BINLOG_CHECKSUM_ALG_CRC32 || size > eventReaderBufferSize {
+		return true
+	}
+	data, err := r.in.Peek(int(size))
+	if err != nil || len(data) < replication.BinlogChecksumLength+replication.EventHeaderSize {
+		return false
+	}
+	body := data[:len(data)-replication.BinlogChecksumLength]
+	return crc32.ChecksumIEEE(body) == binary.LittleEndian.Uint32(data[len(body):])
+}
+
+func minSize(a, b uint32) uint32 {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,101 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/tanema/binlog-parser/src/database"
+)
+
+func parseMessages(t *testing.T, binlogFile string, r io.Reader) []Message {
+	var messages []Message
+	p := New(database.Offline(), func(message Message) error {
+		messages = append(messages, message)
+		return nil
+	})
+	p.VerifyChecksum(true)
+	if err := p.ParseReader(binlogFile, r); err != nil {
+		t.Fatal(err)
+	}
+	return messages
+}
+
+func assertSameRows(t *testing.T, message, expected Message) {
+	data, _ := json.Marshal(WithHeader(message, MessageHeader{}))
+	expectedData, _ := json.Marshal(WithHeader(expected, MessageHeader{}))
+	if string(data) != string(expectedData) || message.GetHeader().XID != expected.GetHeader().XID {
+		t.Fatal(fmt.Sprintf("Expected message %s, got %s", expectedData, data))
+	}
+}
+
+// relay-bin.01 is a relay log with the events of mysql-bin.01 as
+// mysql-bin.000041 followed by the first transaction of mysql-bin.000042
+func TestParserRelayLog(t *testing.T) {
+	data, _ := fixtureEvents(t, "mysql-bin.01")
+	expected := parseMessages(t, "mysql-bin.01", bytes.NewReader(data))
+	relay, _ := fixtureEvents(t, "relay-bin.01")
+	messages := parseMessages(t, "relay-bin.01", bytes.NewReader(relay))
+
+	if len(messages) != len(expected)+2 {
+		t.Fatal(fmt.Sprintf("Expected %d messages, got %d", len(expected)+2, len(messages)))
+	}
+	for i, message := range messages {
+		header := message.GetHeader()
+		expectedMessage, binlogFile, shift := expected[i%len(expected)], "mysql-bin.000041", uint32(163)
+		if i >= len(expected) {
+			binlogFile, shift = "mysql-bin.000042", 1463
+		}
+		expectedHeader := expectedMessage.GetHeader()
+		assertSameRows(t, message, expectedMessage)
+		if header.BinlogPosition != expectedHeader.BinlogPosition || header.Event.BinlogFile != binlogFile || header.Event.StartPosition != expectedHeader.Event.StartPosition {
+			t.Fatal(fmt.Sprintf("Expected the position of the source %s:%d, got %d, %+v", binlogFile, expectedHeader.Event.StartPosition, header.BinlogPosition, header.Event))
+		}
+		if header.Event.RelayLogFile != "relay-bin.01" || header.Event.RelayLogPosition != expectedHeader.Event.StartPosition+shift || header.Event.ServerID != 1 {
+			t.Fatal(fmt.Sprintf("Expected the relay log position %d, got %+v", expectedHeader.Event.StartPosition+shift, header.Event))
+		}
+	}
+
+	var eventTypes []string
+	p := New(database.Offline(), func(message Message) error { return nil })
+	p.OnEvent(func(event EventMetadata, _ time.Time) error {
+		eventTypes = append(eventTypes, event.EventType)
+		return nil
+	})
+	if err := p.ParseReader("relay-bin.01", bytes.NewReader(relay)); err != nil {
+		t.Fatal(err)
+	}
+	if len(eventTypes) != 23 || eventTypes[0] != "FormatDescriptionEvent" || eventTypes[17] != "RotateEvent" {
+		t.Fatal(fmt.Sprintf("Expected the events of the source only, got %v", eventTypes))
+	}
+}
+
+// mysqlbinlog --raw --start-position writes the format description event
+// the source sends without position before the events from the start
+func TestParserRawStream(t *testing.T) {
+	data, offsets := fixtureEvents(t, "mysql-bin.01")
+	expected := parseMessages(t, "mysql-bin.01", bytes.NewReader(data))
+
+	format := append([]byte{}, data[offsets[0]:offsets[1]]...)
+	binary.LittleEndian.PutUint32(format[13:], 0)
+	binary.LittleEndian.PutUint32(format[len(format)-4:], crc32.ChecksumIEEE(format[:len(format)-4]))
+	raw := append(append(append([]byte{}, data[:offsets[0]]...), format...), data[offsets[5]:]...)
+	messages := parseMessages(t, "mysql-bin.000041", bytes.NewReader(raw))
+
+	expected = expected[2:]
+	if len(messages) != len(expected) {
+		t.Fatal(fmt.Sprintf("Expected %d messages, got %d", len(expected), len(messages)))
+	}
+	for i, message := range messages {
+		header, expectedHeader := message.GetHeader(), expected[i].GetHeader()
+		assertSameRows(t, message, expected[i])
+		if header.BinlogPosition != expectedHeader.BinlogPosition || header.Event.StartPosition != expectedHeader.Event.StartPosition || header.Event.BinlogFile != "mysql-bin.000041" {
+			t.Fatal(fmt.Sprintf("Expected the position of the source %d, got %d, %+v", expectedHeader.Event.StartPosition, header.BinlogPosition, header.Event))
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package parser
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/siddontang/go-mysql/replication"
+	"github.com/ulikunitz/xz"
+)
+
+// Stdin is the binlog path that reads the binlog from the standard input
+const Stdin = "-"
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	xzMagic   = []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}
+	tarMagic  = []byte("ustar")
+)
+
+// tarMagicOffset is where the magic of a tar header starts
+const tarMagicOffset = 257
+
+// BinlogFunc is called with the name and the events of each binlog of a file
+type BinlogFunc func(binlogFile string, r io.Reader) error
+
+// OpenBinlogs opens the binlog at the path, or the standard input for Stdin,
+// decompresses it if it's gzip, zstd or xz compressed and calls fn with it.
+// For a tarball fn is called for each binlog in it in archive order, other
+// files of the tarball are skipped.
+func OpenBinlogs(binlogPath string, fn BinlogFunc) error {
+	if binlogPath == Stdin {
+		return ReadBinlogs("stdin", os.Stdin, fn)
+	}
+	f, err := os.Open(binlogPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return ReadBinlogs(filepath.Base(binlogPath), f, fn)
+}
+
+// ReadBinlogs reads a binlog, compressed binlog or tarball of binlogs like
+// OpenBinlogs from the reader, name is the name of the file it comes from
+func ReadBinlogs(name string, r io.Reader, fn BinlogFunc) error {
+	in, compressed, closeReader, err := decompress(r)
+	if err != nil {
+		return err
+	}
+	defer closeReader()
+	buffered := bufio.NewReader(in)
+	header, _ := buffered.Peek(tarMagicOffset + len(tarMagic))
+	if len(header) == tarMagicOffset+len(tarMagic) && bytes.Equal(header[tarMagicOffset:], tarMagic) {
+		return readTar(buffered, fn)
+	}
+	if compressed {
+		name = trimCompressionExt(name)
+	}
+	return fn(name, buffered)
+}
+
+// readTar calls fn for the regular files of the tarball that are binlogs or
+// compressed binlogs
+func readTar(r io.Reader, fn BinlogFunc) error {
+	archive := tar.NewReader(r)
+	for {
+		entry, err := archive.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if entry.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err := readTarEntry(path.Base(entry.Name), archive, fn); err != nil {
+			return err
+		}
+	}
+}
+
+func readTarEntry(name string, r io.Reader, fn BinlogFunc) error {
+	in, compressed, closeReader, err := decompress(r)
+	if err != nil {
+		return err
+	}
+	defer closeReader()
+	buffered := bufio.NewReader(in)
+	if magic, _ := buffered.Peek(len(replication.BinLogFileHeader)); !bytes.Equal(magic, replication.BinLogFileHeader) {
+		return nil
+	}
+	if compressed {
+		name = trimCompressionExt(name)
+	}
+	return fn(name, buffered)
+}
+
+// decompress wraps the reader with a decompressor if it starts with the magic
+// number of gzip, zstd or xz, the returned function releases the decompressor
+func decompress(r io.Reader) (io.Reader, bool, func(), error) {
+	buffered := bufio.NewReader(r)
+	magic, _ := buffered.Peek(len(xzMagic))
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		in, err := gzip.NewReader(buffered)
+		if err != nil {
+			return nil, false, nil, err
+		}
+		return in, true, func() { in.Close() }, nil
+	case bytes.HasPrefix(magic, zstdMagic):
+		in, err := zstd.NewReader(buffered)
+		if err != nil {
+			return nil, false, nil, err
+		}
+		return in, true, in.Close, nil
+	case bytes.HasPrefix(magic, xzMagic):
+		in, err := xz.NewReader(buffered)
+		if err != nil {
+			return nil, false, nil, err
+		}
+		return in, true, func() {}, nil
+	}
+	return buffered, false, func() {}, nil
+}
+
+// trimCompressionExt removes the extension of the compression from the name
+// of a binlog, so messages carry the name the server gave it
+func trimCompressionExt(name string) string {
+	switch ext := path.Ext(name); strings.ToLower(ext) {
+	case ".gz", ".zst", ".zstd", ".xz":
+		return strings.TrimSuffix(name, ext)
+	}
+	return name
+}
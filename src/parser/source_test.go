@@ -0,0 +1,97 @@
+package parser
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+func TestReadBinlogs(t *testing.T) {
+	data, err := ioutil.ReadFile("../../test/data/fixtures/mysql-bin.01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	compress := func(newWriter func(io.Writer) (io.WriteCloser, error), data []byte) []byte {
+		var out bytes.Buffer
+		w, err := newWriter(&out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(data); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return out.Bytes()
+	}
+	gzipped := func(data []byte) []byte {
+		return compress(func(w io.Writer) (io.WriteCloser, error) { return gzip.NewWriter(w), nil }, data)
+	}
+	zstded := func(data []byte) []byte {
+		return compress(func(w io.Writer) (io.WriteCloser, error) { return zstd.NewWriter(w) }, data)
+	}
+	xzed := func(data []byte) []byte {
+		return compress(func(w io.Writer) (io.WriteCloser, error) { return xz.NewWriter(w) }, data)
+	}
+	tarball := func(files ...interface{}) []byte {
+		var out bytes.Buffer
+		w := tar.NewWriter(&out)
+		for i := 0; i < len(files); i += 2 {
+			content := files[i+1].([]byte)
+			if err := w.WriteHeader(&tar.Header{Name: files[i].(string), Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := w.Write(content); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return out.Bytes()
+	}
+
+	testCases := []struct {
+		name     string
+		data     []byte
+		binlogs  []string
+		hasError bool
+	}{
+		{"mysql-bin.01", data, []string{"mysql-bin.01"}, false},
+		{"mysql-bin.01.gz", gzipped(data), []string{"mysql-bin.01"}, false},
+		{"mysql-bin.01.zst", zstded(data), []string{"mysql-bin.01"}, false},
+		{"mysql-bin.01.xz", xzed(data), []string{"mysql-bin.01"}, false},
+		{"stdin", gzipped(data), []string{"stdin"}, false},
+		{"binlogs.tar", tarball("backup/mysql-bin.01", data, "backup/mysql-bin.index", []byte("mysql-bin.01\n"), "backup/mysql-bin.02.gz", gzipped(data)), []string{"mysql-bin.01", "mysql-bin.02"}, false},
+		{"binlogs.tar.zst", zstded(tarball("mysql-bin.01.xz", xzed(data))), []string{"mysql-bin.01"}, false},
+		{"mysql-bin.01.gz", gzipped(data)[:100], []string{"mysql-bin.01"}, true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var binlogs []string
+			err := ReadBinlogs(tc.name, bytes.NewReader(tc.data), func(binlogFile string, r io.Reader) error {
+				binlogs = append(binlogs, binlogFile)
+				read, err := ioutil.ReadAll(r)
+				if err == nil && !bytes.Equal(read, data) {
+					t.Fatal(fmt.Sprintf("Binlog %s has %d bytes, expected %d", binlogFile, len(read), len(data)))
+				}
+				return err
+			})
+			if tc.hasError != (err != nil) {
+				t.Fatal(fmt.Sprintf("Expected error %v, got %v", tc.hasError, err))
+			}
+			if strings.Join(binlogs, ",") != strings.Join(tc.binlogs, ",") {
+				t.Fatal(fmt.Sprintf("Expected binlogs %v, got %v", tc.binlogs, binlogs))
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package parser
+
+import (
+	"io"
+
+	"github.com/siddontang/go-mysql/replication"
+)
+
+// Verification is the result of checking every event of a binlog. Corrupt
+// holds the first bad event, or all of them when corrupt events are skipped,
+// Skipped is the number of bytes skipped to resynchronize.
+type Verification struct {
+	BinlogFile string
+	Events     int
+	Bytes      int64
+	Checksum   string
+	Corrupt    []*CorruptEventError
+	Skipped    int64
+}
+
+// Verify reads every event of the binlog and checks its size, position and,
+// for binlogs written with binlog_checksum=CRC32, its checksum. Without
+// skipCorrupt it stops at the first corrupt event, otherwise it
+// resynchronizes at the next valid event header.
+func Verify(binlogFile string, r io.Reader, skipCorrupt bool) (verification Verification, err error) {
+	verification = Verification{BinlogFile: binlogFile, Checksum: "unknown", Corrupt: []*CorruptEventError{}}
+	events, err := NewEventReader(binlogFile, r)
+	if err != nil {
+		return verification, err
+	}
+	events.VerifyChecksum = true
+	defer func() {
+		verification.Bytes = events.Offset()
+		switch events.Checksum {
+		case replication.BINLOG_CHECKSUM_ALG_CRC32:
+			verification.Checksum = "CRC32"
+		case replication.BINLOG_CHECKSUM_ALG_OFF:
+			verification.Checksum = "none"
+		}
+	}()
+	for {
+		_, err := events.Next()
+		if err == io.EOF {
+			return verification, nil
+		}
+		corrupt, ok := err.(*CorruptEventError)
+		if !ok && err != nil {
+			return verification, err
+		}
+		if !ok {
+			verification.Events++
+			continue
+		}
+		verification.Corrupt = append(verification.Corrupt, corrupt)
+		if !skipCorrupt {
+			return verification, nil
+		}
+		_, err = events.Resync()
+		verification.Skipped += events.Offset() - corrupt.Offset
+		if err == io.EOF {
+			return verification, nil
+		} else if err != nil {
+			return verification, err
+		}
+	}
+}
+
+// OK tells if the binlog has no corrupt events
+func (v Verification) OK() bool {
+	return len(v.Corrupt) == 0
+}
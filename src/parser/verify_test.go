@@ -0,0 +1,130 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/tanema/binlog-parser/src/database"
+)
+
+// fixtureEvents reads the fixture and the offsets of its events
+func fixtureEvents(t *testing.T, name string) ([]byte, []int64) {
+	data, err := ioutil.ReadFile("../../test/data/fixtures/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	events, err := NewEventReader(name, bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var offsets []int64
+	for {
+		offset := events.Offset()
+		if _, err := events.Next(); err == io.EOF {
+			return data, offsets
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		offsets = append(offsets, offset)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	data, offsets := fixtureEvents(t, "mysql-bin.01")
+	corrupted := func(offset int64) []byte {
+		corrupt := append([]byte{}, data...)
+		corrupt[offset] ^= 0xff
+		return corrupt
+	}
+	last := offsets[len(offsets)-1]
+	oversized := append([]byte{}, data...)
+	binary.LittleEndian.PutUint32(oversized[last+9:], 1<<31)
+
+	testCases := []struct {
+		name        string
+		data        []byte
+		skipCorrupt bool
+		events      int
+		corrupt     []string
+	}{
+		{"clean", data, false, len(offsets), nil},
+		{"checksum", corrupted(offsets[5] + 20), false, 5, []string{fmt.Sprintf("%d: checksum mismatch", offsets[5])}},
+		{"size", corrupted(offsets[5] + 9), false, 5, []string{fmt.Sprintf("%d: event ends at", offsets[5])}},
+		{"truncated", data[:len(data)-10], false, len(offsets) - 1, []string{fmt.Sprintf("%d: truncated event", offsets[len(offsets)-1])}},
+		{"truncated header", data[:offsets[len(offsets)-1]+5], false, len(offsets) - 1, []string{fmt.Sprintf("%d: truncated event header, 5 of 19 bytes", offsets[len(offsets)-1])}},
+		{"oversized", oversized, false, len(offsets) - 1, []string{fmt.Sprintf("%d: invalid event size %d", last, 1<<31)}},
+		{"skip checksum", corrupted(offsets[5] + 20), true, len(offsets) - 1, []string{fmt.Sprintf("%d: checksum mismatch", offsets[5])}},
+		{"skip size", corrupted(offsets[5] + 9), true, len(offsets) - 1, []string{fmt.Sprintf("%d: event ends at", offsets[5])}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			verification, err := Verify("mysql-bin.01", bytes.NewReader(tc.data), tc.skipCorrupt)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if verification.Events != tc.events || verification.Checksum != "CRC32" || len(verification.Corrupt) != len(tc.corrupt) || verification.OK() != (tc.corrupt == nil) {
+				t.Fatal(fmt.Sprintf("Expected %d events and %d corrupt, got %+v", tc.events, len(tc.corrupt), verification))
+			}
+			for i, expected := range tc.corrupt {
+				if actual := fmt.Sprintf("%d: %s", verification.Corrupt[i].Offset, verification.Corrupt[i].Reason); !strings.HasPrefix(actual, expected) {
+					t.Fatal(fmt.Sprintf("Expected corrupt event %q, got %q", expected, actual))
+				}
+			}
+			if tc.skipCorrupt && verification.Skipped != offsets[6]-offsets[5] {
+				t.Fatal(fmt.Sprintf("Expected to skip the corrupt event of %d bytes, skipped %d", offsets[6]-offsets[5], verification.Skipped))
+			}
+		})
+	}
+
+	if _, err := Verify("mysql-bin.01", strings.NewReader("not a binlog"), false); err == nil {
+		t.Fatal("Expected error for a file without magic number")
+	}
+}
+
+func TestParserSkipCorrupt(t *testing.T) {
+	data, offsets := fixtureEvents(t, "mysql-bin.01")
+	// the rows event of the second transaction
+	data[offsets[7]+20] ^= 0xff
+
+	parse := func(skip bool) ([]Message, []*CorruptEventError, error) {
+		var messages []Message
+		var corrupt []*CorruptEventError
+		p := New(database.Offline(), func(message Message) error {
+			messages = append(messages, message)
+			return nil
+		})
+		p.VerifyChecksum(true)
+		if skip {
+			p.SkipCorrupt(func(err *CorruptEventError) error {
+				corrupt = append(corrupt, err)
+				return nil
+			})
+		}
+		events, err := NewEventReader("mysql-bin.01", bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return messages, corrupt, p.parseEvents(events)
+	}
+
+	if _, _, err := parse(false); err == nil || !strings.Contains(err.Error(), fmt.Sprintf("offset %d: checksum mismatch", offsets[7])) {
+		t.Fatal(fmt.Sprintf("Expected checksum error, got %v", err))
+	}
+	messages, corrupt, err := parse(true)
+	if err != nil || len(corrupt) != 1 {
+		t.Fatal(fmt.Sprintf("Expected to skip one corrupt event, got %v, %v", corrupt, err))
+	}
+	for _, message := range messages {
+		if message.GetHeader().XID == 10 {
+			t.Fatal(fmt.Sprintf("Expected the rows of the corrupt transaction to be dropped, got %+v", message))
+		}
+	}
+	if len(messages) == 0 {
+		t.Fatal("Expected the messages of the other transactions")
+	}
+}
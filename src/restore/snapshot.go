@@ -0,0 +1,416 @@
+package restore
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/tanema/binlog-parser/src/database"
+)
+
+// SnapshotFormat guesses the format of a snapshot from its file extension
+func SnapshotFormat(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		return FormatCSV
+	case ".sql":
+		return FormatSQL
+	}
+	return FormatNDJSON
+}
+
+// readCSV reads CSV rows, with a header row of column names or with the
+// columns in table order. \N is NULL.
+func readCSV(r io.Reader, columns []database.Column, insert func(map[string]interface{}) error) error {
+	in := csv.NewReader(r)
+	in.FieldsPerRecord = -1
+	names := make([]string, len(columns))
+	for i, column := range columns {
+		names[i] = column.Name
+	}
+	for first := true; ; first = false {
+		record, err := in.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if first && isHeader(record, columns) {
+			names = record
+			continue
+		}
+		if len(record) != len(names) {
+			line, _ := in.FieldPos(0)
+			return fmt.Errorf("line %d of the snapshot has %d fields, expected %d", line, len(record), len(names))
+		}
+		row := make(map[string]interface{}, len(record))
+		for i, field := range record {
+			if field == `\N` {
+				row[names[i]] = nil
+			} else {
+				row[names[i]] = field
+			}
+		}
+		if err := insert(row); err != nil {
+			return err
+		}
+	}
+}
+
+// isHeader tells if every field of the record is a column name
+func isHeader(record []string, columns []database.Column) bool {
+	for _, field := range record {
+		found := false
+		for _, column := range columns {
+			found = found || column.Name == field
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// readNDJSON reads a JSON object per row, objects and arrays are stored as
+// their JSON text
+func readNDJSON(r io.Reader, insert func(map[string]interface{}) error) error {
+	decoder := json.NewDecoder(r)
+	decoder.UseNumber()
+	for {
+		var row map[string]interface{}
+		if err := decoder.Decode(&row); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		for name, value := range row {
+			switch v := value.(type) {
+			case json.Number:
+				row[name] = string(v)
+			case bool:
+				row[name] = 0
+				if v {
+					row[name] = 1
+				}
+			case map[string]interface{}, []interface{}:
+				data, err := json.Marshal(v)
+				if err != nil {
+					return err
+				}
+				row[name] = string(data)
+			}
+		}
+		if err := insert(row); err != nil {
+			return err
+		}
+	}
+}
+
+// readDump reads the rows of the INSERT statements for the table in a
+// mysqldump file, which writes a statement per line. Other statements and the
+// rows of other tables are skipped.
+func readDump(r io.Reader, table string, columns []database.Column, insert func(map[string]interface{}) error) error {
+	in := bufio.NewReader(r)
+	for line := 1; ; line++ {
+		text, err := in.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if statement := strings.TrimSpace(text); statement != "" {
+			name, names, rows, parseErr := parseInsert(statement)
+			if parseErr != nil {
+				return fmt.Errorf("line %d of the snapshot: %s", line, parseErr)
+			}
+			if name == table {
+				if names == nil {
+					for _, column := range columns {
+						names = append(names, column.Name)
+					}
+				}
+				for _, values := range rows {
+					if len(values) != len(names) {
+						return fmt.Errorf("line %d of the snapshot has a row of %d values, expected %d", line, len(values), len(names))
+					}
+					row := make(map[string]interface{}, len(values))
+					for i, value := range values {
+						row[names[i]] = value
+					}
+					if err := insert(row); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+	}
+}
+
+// parseInsert parses an INSERT or REPLACE statement into the table name
+// without schema, the column names if they are listed and the rows. Other
+// statements return an empty table name.
+func parseInsert(statement string) (string, []string, [][]interface{}, error) {
+	s := &sqlScanner{text: statement}
+	if !s.keyword("INSERT") && !s.keyword("REPLACE") {
+		return "", nil, nil, nil
+	}
+	s.keyword("IGNORE")
+	if !s.keyword("INTO") {
+		return "", nil, nil, s.errorf("expected INTO")
+	}
+	table, err := s.identifier()
+	if err != nil {
+		return "", nil, nil, err
+	}
+	if s.symbol('.') {
+		if table, err = s.identifier(); err != nil {
+			return "", nil, nil, err
+		}
+	}
+
+	var names []string
+	if s.symbol('(') {
+		for {
+			name, err := s.identifier()
+			if err != nil {
+				return "", nil, nil, err
+			}
+			names = append(names, name)
+			if s.symbol(')') {
+				break
+			}
+			if !s.symbol(',') {
+				return "", nil, nil, s.errorf("expected , or )")
+			}
+		}
+	}
+	if !s.keyword("VALUES") && !s.keyword("VALUE") {
+		return "", nil, nil, s.errorf("expected VALUES")
+	}
+
+	var rows [][]interface{}
+	for {
+		if !s.symbol('(') {
+			return "", nil, nil, s.errorf("expected (")
+		}
+		var row []interface{}
+		for {
+			value, err := s.value()
+			if err != nil {
+				return "", nil, nil, err
+			}
+			row = append(row, value)
+			if s.symbol(')') {
+				break
+			}
+			if !s.symbol(',') {
+				return "", nil, nil, s.errorf("expected , or )")
+			}
+		}
+		rows = append(rows, row)
+		if !s.symbol(',') {
+			break
+		}
+	}
+	s.symbol(';')
+	if s.skipSpace(); s.pos < len(s.text) {
+		return "", nil, nil, s.errorf("unexpected text after the values")
+	}
+	return table, names, rows, nil
+}
+
+// sqlScanner reads the tokens of an SQL statement as written by mysqldump
+type sqlScanner struct {
+	text string
+	pos  int
+}
+
+func (s *sqlScanner) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("%s at offset %d", fmt.Sprintf(format, args...), s.pos)
+}
+
+func (s *sqlScanner) skipSpace() {
+	for s.pos < len(s.text) && strings.IndexByte(" \t\r\n", s.text[s.pos]) >= 0 {
+		s.pos++
+	}
+}
+
+// keyword consumes the keyword if it's next, ignoring case
+func (s *sqlScanner) keyword(word string) bool {
+	s.skipSpace()
+	end := s.pos + len(word)
+	if end > len(s.text) || !strings.EqualFold(s.text[s.pos:end], word) {
+		return false
+	}
+	if end < len(s.text) && isWordByte(s.text[end]) {
+		return false
+	}
+	s.pos = end
+	return true
+}
+
+// symbol consumes the character if it's next
+func (s *sqlScanner) symbol(c byte) bool {
+	s.skipSpace()
+	if s.pos < len(s.text) && s.text[s.pos] == c {
+		s.pos++
+		return true
+	}
+	return false
+}
+
+// identifier reads a plain or backquoted name
+func (s *sqlScanner) identifier() (string, error) {
+	s.skipSpace()
+	if s.symbol('`') {
+		var name strings.Builder
+		for s.pos < len(s.text) {
+			c := s.text[s.pos]
+			s.pos++
+			if c != '`' {
+				name.WriteByte(c)
+			} else if s.pos < len(s.text) && s.text[s.pos] == '`' {
+				name.WriteByte('`')
+				s.pos++
+			} else {
+				return name.String(), nil
+			}
+		}
+		return "", s.errorf("unterminated identifier")
+	}
+	start := s.pos
+	for s.pos < len(s.text) && isWordByte(s.text[s.pos]) {
+		s.pos++
+	}
+	if start == s.pos {
+		return "", s.errorf("expected identifier")
+	}
+	return s.text[start:s.pos], nil
+}
+
+// value reads a literal: NULL, a number, a quoted string with an optional
+// character set introducer, a hex literal or a bit literal. Numbers are kept
+// as text, hex literals and _binary strings are returned as bytes.
+func (s *sqlScanner) value() (interface{}, error) {
+	s.skipSpace()
+	if s.keyword("NULL") {
+		return nil, nil
+	}
+	if s.pos >= len(s.text) {
+		return nil, s.errorf("expected value")
+	}
+	switch c := s.text[s.pos]; {
+	case c == '\'' || c == '"':
+		return s.quoted()
+	case c == '_':
+		charset, err := s.identifier()
+		if err != nil {
+			return nil, err
+		}
+		s.skipSpace()
+		text, err := s.quoted()
+		if err != nil {
+			return nil, err
+		}
+		if strings.EqualFold(charset, "_binary") {
+			return []byte(text), nil
+		}
+		return text, nil
+	case strings.HasPrefix(s.text[s.pos:], "0x"):
+		s.pos += 2
+		return s.hex(s.word())
+	case (c == 'x' || c == 'X') && strings.HasPrefix(s.text[s.pos+1:], "'"):
+		s.pos++
+		text, err := s.quoted()
+		if err != nil {
+			return nil, err
+		}
+		return s.hex(text)
+	case (c == 'b' || c == 'B') && strings.HasPrefix(s.text[s.pos+1:], "'"):
+		s.pos++
+		text, err := s.quoted()
+		if err != nil {
+			return nil, err
+		}
+		bits, err := strconv.ParseUint(text, 2, 64)
+		if err != nil {
+			return nil, s.errorf("invalid bit literal %q", text)
+		}
+		return strconv.FormatUint(bits, 10), nil
+	}
+	number := s.word()
+	if number == "" {
+		return nil, s.errorf("expected value")
+	}
+	return number, nil
+}
+
+// word reads the characters up to the next separator
+func (s *sqlScanner) word() string {
+	start := s.pos
+	for s.pos < len(s.text) && strings.IndexByte(" \t\r\n,)", s.text[s.pos]) < 0 {
+		s.pos++
+	}
+	return s.text[start:s.pos]
+}
+
+func (s *sqlScanner) hex(text string) (interface{}, error) {
+	data, err := hex.DecodeString(text)
+	if err != nil {
+		return nil, s.errorf("invalid hex literal %q", text)
+	}
+	return data, nil
+}
+
+// quoted reads a string in single or double quotes with the backslash escapes
+// of MySQL, a doubled quote is the quote itself
+func (s *sqlScanner) quoted() (string, error) {
+	quote := s.text[s.pos]
+	s.pos++
+	var text strings.Builder
+	for s.pos < len(s.text) {
+		c := s.text[s.pos]
+		s.pos++
+		switch {
+		case c == '\\' && s.pos < len(s.text):
+			escaped := s.text[s.pos]
+			s.pos++
+			switch escaped {
+			case '0':
+				text.WriteByte(0)
+			case 'b':
+				text.WriteByte('\b')
+			case 'n':
+				text.WriteByte('\n')
+			case 'r':
+				text.WriteByte('\r')
+			case 't':
+				text.WriteByte('\t')
+			case 'Z':
+				text.WriteByte(26)
+			default:
+				text.WriteByte(escaped)
+			}
+		case c == quote && s.pos < len(s.text) && s.text[s.pos] == quote:
+			text.WriteByte(quote)
+			s.pos++
+		case c == quote:
+			return text.String(), nil
+		default:
+			text.WriteByte(c)
+		}
+	}
+	return "", s.errorf("unterminated string")
+}
+
+func isWordByte(c byte) bool {
+	return c == '_' || c == '$' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c >= 0x80
+}
@@ -0,0 +1,78 @@
+package restore
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/tanema/binlog-parser/src/database"
+)
+
+func TestParseInsert(t *testing.T) {
+	testCases := []struct {
+		statement string
+		table     string
+		names     []string
+		rows      [][]interface{}
+	}{
+		{"INSERT INTO `orders` VALUES (1,'it''s',NULL),(2,'a\\'b\\nc',-1.5e3);", "orders", nil, [][]interface{}{{"1", "it's", nil}, {"2", "a'b\nc", "-1.5e3"}}},
+		{"INSERT INTO `shop`.`orders` (`id`, `data`) VALUES (3, _binary 'x\\0'), (4, 0x0aff);", "orders", []string{"id", "data"}, [][]interface{}{{"3", []byte("x\x00")}, {"4", []byte{0x0a, 0xff}}}},
+		{"REPLACE INTO orders(id,flags) VALUE (5,b'101')", "orders", []string{"id", "flags"}, [][]interface{}{{"5", "5"}}},
+		{"insert ignore into `weird``name` values (X'41', _utf8mb4 \"q\")", "weird`name", nil, [][]interface{}{{[]byte("A"), "q"}}},
+		{"/*!40101 SET NAMES utf8 */;", "", nil, nil},
+		{"LOCK TABLES `orders` WRITE;", "", nil, nil},
+	}
+	for _, tc := range testCases {
+		table, names, rows, err := parseInsert(tc.statement)
+		if err != nil {
+			t.Fatal(fmt.Sprintf("Expected to parse %s, got %s", tc.statement, err))
+		}
+		if table != tc.table || !reflect.DeepEqual(names, tc.names) || !reflect.DeepEqual(rows, tc.rows) {
+			t.Fatal(fmt.Sprintf("Expected %q %v %#v for %s, got %q %v %#v", tc.table, tc.names, tc.rows, tc.statement, table, names, rows))
+		}
+	}
+
+	for _, statement := range []string{
+		"INSERT INTO `orders` VALUES (1,'open",
+		"INSERT INTO `orders` VALUES (1 2)",
+		"INSERT INTO `orders` VALUES (0xzz)",
+		"INSERT `orders` VALUES (1)",
+	} {
+		if _, _, _, err := parseInsert(statement); err == nil {
+			t.Fatal(fmt.Sprintf("Expected error for %s", statement))
+		}
+	}
+}
+
+func TestReadCSV(t *testing.T) {
+	columns := []database.Column{{Name: "id"}, {Name: "name"}}
+	testCases := []struct {
+		input    string
+		expected []map[string]interface{}
+	}{
+		{"name,id\nx,1\n\\N,2\n", []map[string]interface{}{{"id": "1", "name": "x"}, {"id": "2", "name": nil}}},
+		{"1,x\n2,\"a,b\"\n", []map[string]interface{}{{"id": "1", "name": "x"}, {"id": "2", "name": "a,b"}}},
+	}
+	for _, tc := range testCases {
+		var rows []map[string]interface{}
+		err := readCSV(strings.NewReader(tc.input), columns, func(row map[string]interface{}) error {
+			rows = append(rows, row)
+			return nil
+		})
+		if err != nil || !reflect.DeepEqual(rows, tc.expected) {
+			t.Fatal(fmt.Sprintf("Expected %v for %q, got %v, %v", tc.expected, tc.input, rows, err))
+		}
+	}
+	if err := readCSV(strings.NewReader("1,x,y\n"), columns, func(map[string]interface{}) error { return nil }); err == nil {
+		t.Fatal("Expected error for a row with too many fields")
+	}
+}
+
+func TestSnapshotFormat(t *testing.T) {
+	for path, expected := range map[string]string{"orders.csv": FormatCSV, "dump.SQL": FormatSQL, "orders.ndjson": FormatNDJSON, "orders.jsonl": FormatNDJSON} {
+		if actual := SnapshotFormat(path); actual != expected {
+			t.Fatal(fmt.Sprintf("Expected %s for %s, got %s", expected, path, actual))
+		}
+	}
+}
@@ -0,0 +1,286 @@
+package restore
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/tanema/binlog-parser/src/database"
+	"github.com/tanema/binlog-parser/src/sink"
+)
+
+const (
+	// FormatCSV is CSV with a header row, NULL is written as \N
+	FormatCSV = "csv"
+	// FormatNDJSON is a JSON object per row and line
+	FormatNDJSON = "ndjson"
+	// FormatSQL is a mysqldump file, only valid for snapshots
+	FormatSQL = "sql"
+)
+
+// Store keeps the state of a table in an SQLite database on disk, so tables
+// larger than memory can be restored. The table is created with the columns
+// and primary key of the MySQL table and typed by them, so numbers from
+// snapshots and binlogs compare equal. Binlogs carry text and JSON values as
+// bytes, the sink applies them as text for the columns to match.
+type Store struct {
+	Path    string
+	Schema  string
+	Table   string
+	Columns []database.Column
+	db      *sql.DB
+}
+
+// CheckDriver checks that the sqlite3 database/sql driver is registered and
+// works, binaries built without cgo only have a stub of it
+func CheckDriver() error {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err == nil {
+		err = db.Ping()
+		db.Close()
+	}
+	if err != nil {
+		return fmt.Errorf("restore needs the sqlite3 driver, which needs a binary built with cgo: %s", err)
+	}
+	return nil
+}
+
+// CreateStore creates the table in the SQLite database at path, replacing
+// the table if it exists. The sqlite3 database/sql driver must be registered.
+func CreateStore(path, schema, table string, columns []database.Column) (*Store, error) {
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("table %s.%s has no columns", schema, table)
+	}
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, err
+	}
+	s := &Store{Path: path, Schema: schema, Table: table, Columns: columns, db: db}
+
+	definitions := make([]string, len(columns))
+	var keys []string
+	for i, column := range columns {
+		definitions[i] = quote(column.Name) + " " + affinity(column)
+		if column.PrimaryKey {
+			keys = append(keys, quote(column.Name))
+		}
+	}
+	if len(keys) > 0 {
+		definitions = append(definitions, "PRIMARY KEY ("+strings.Join(keys, ", ")+")")
+	}
+	for _, query := range []string{
+		"DROP TABLE IF EXISTS " + quote(table),
+		fmt.Sprintf("CREATE TABLE %s (%s)", quote(table), strings.Join(definitions, ", ")),
+	} {
+		if _, err := db.Exec(query); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// Sink creates a sink applying the row changes of the table to the store,
+// through a connection of its own that is closed with the sink
+func (s *Store) Sink() (*sink.Apply, error) {
+	db, err := sql.Open("sqlite3", s.Path)
+	if err != nil {
+		return nil, err
+	}
+	apply := sink.NewApply(db, sink.DialectSQLite)
+	apply.Schemas = map[string]string{s.Schema: ""}
+	return apply, nil
+}
+
+// Load reads the rows of a snapshot of the table into the store and returns
+// their number
+func (s *Store) Load(r io.Reader, format string) (int, error) {
+	names := make([]string, len(s.Columns))
+	placeholders := make([]string, len(s.Columns))
+	for i, column := range s.Columns {
+		names[i] = quote(column.Name)
+		placeholders[i] = "?"
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	stmt, err := tx.Prepare(fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", quote(s.Table), strings.Join(names, ", "), strings.Join(placeholders, ", ")))
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+
+	count := 0
+	insert := func(row map[string]interface{}) error {
+		values := make([]interface{}, len(s.Columns))
+		for i, column := range s.Columns {
+			value, ok := row[column.Name]
+			if ok {
+				delete(row, column.Name)
+			}
+			values[i] = storeValue(column, value)
+		}
+		for name := range row {
+			return fmt.Errorf("row %d of the snapshot has unknown column %q", count+1, name)
+		}
+		if _, err := stmt.Exec(values...); err != nil {
+			return fmt.Errorf("row %d of the snapshot: %s", count+1, err)
+		}
+		count++
+		return nil
+	}
+
+	switch format {
+	case FormatCSV:
+		err = readCSV(r, s.Columns, insert)
+	case FormatNDJSON:
+		err = readNDJSON(r, insert)
+	case FormatSQL:
+		err = readDump(r, s.Table, s.Columns, insert)
+	default:
+		err = fmt.Errorf("unknown snapshot format %q", format)
+	}
+	if err != nil {
+		return count, err
+	}
+	return count, tx.Commit()
+}
+
+// Export writes the rows of the table ordered by primary key
+func (s *Store) Export(w io.Writer, format string) error {
+	if format != FormatCSV && format != FormatNDJSON {
+		return fmt.Errorf("unknown restore format %q", format)
+	}
+	names := make([]string, len(s.Columns))
+	var keys []string
+	for i, column := range s.Columns {
+		names[i] = quote(column.Name)
+		if column.PrimaryKey {
+			keys = append(keys, quote(column.Name))
+		}
+	}
+	if len(keys) == 0 {
+		keys = []string{"rowid"}
+	}
+	rows, err := s.db.Query(fmt.Sprintf("SELECT %s FROM %s ORDER BY %s", strings.Join(names, ", "), quote(s.Table), strings.Join(keys, ", ")))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	out := csv.NewWriter(w)
+	if format == FormatCSV {
+		header := make([]string, len(s.Columns))
+		for i, column := range s.Columns {
+			header[i] = column.Name
+		}
+		out.Write(header)
+	}
+	values := make([]interface{}, len(s.Columns))
+	pointers := make([]interface{}, len(s.Columns))
+	for i := range values {
+		pointers[i] = &values[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(pointers...); err != nil {
+			return err
+		}
+		if format == FormatCSV {
+			record := make([]string, len(values))
+			for i, value := range values {
+				record[i] = csvValue(value)
+			}
+			if err := out.Write(record); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := writeJSONRow(w, s.Columns, values); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	out.Flush()
+	return out.Error()
+}
+
+// Close closes the store, the database file is kept
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// writeJSONRow writes the row as JSON object with the columns in table order
+func writeJSONRow(w io.Writer, columns []database.Column, values []interface{}) error {
+	var line strings.Builder
+	line.WriteByte('{')
+	for i, column := range columns {
+		if i > 0 {
+			line.WriteByte(',')
+		}
+		name, err := json.Marshal(column.Name)
+		if err != nil {
+			return err
+		}
+		value := values[i]
+		if data, ok := value.([]byte); ok && affinity(column) != "BLOB" {
+			value = string(data)
+		}
+		data, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		line.Write(name)
+		line.WriteByte(':')
+		line.Write(data)
+	}
+	line.WriteString("}\n")
+	_, err := io.WriteString(w, line.String())
+	return err
+}
+
+func csvValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return `\N`
+	case []byte:
+		return string(v)
+	}
+	return fmt.Sprint(value)
+}
+
+// storeValue converts a snapshot value for the column, text of binary columns
+// is stored as bytes
+func storeValue(column database.Column, value interface{}) interface{} {
+	if text, ok := value.(string); ok && affinity(column) == "BLOB" {
+		return []byte(text)
+	}
+	return value
+}
+
+// affinity is the SQLite type of the column, so values convert to the same
+// storage class whether they come as text from a snapshot or typed from a
+// binlog
+func affinity(column database.Column) string {
+	switch column.DataType {
+	case "tinyint", "smallint", "mediumint", "int", "integer", "bigint", "year", "bit":
+		return "INTEGER"
+	case "float", "double", "real":
+		return "REAL"
+	case "decimal", "numeric":
+		return "NUMERIC"
+	case "binary", "varbinary", "tinyblob", "blob", "mediumblob", "longblob":
+		return "BLOB"
+	}
+	return "TEXT"
+}
+
+func quote(name string) string {
+	return `"` + strings.Replace(name, `"`, `""`, -1) + `"`
+}
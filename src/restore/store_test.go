@@ -0,0 +1,216 @@
+package restore
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/tanema/binlog-parser/src/database"
+	"github.com/tanema/binlog-parser/src/parser"
+)
+
+var storeColumns = []database.Column{
+	{Name: "id", DataType: "int", ColumnType: "int(11)", PrimaryKey: true},
+	{Name: "status", DataType: "enum", ColumnType: "enum('new','paid')"},
+	{Name: "total", DataType: "decimal", ColumnType: "decimal(10,2)", Nullable: true},
+}
+
+func TestStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "restore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	snapshots := []struct {
+		format string
+		input  string
+	}{
+		{FormatCSV, "id,status,total\n1,new,10.50\n2,new,\\N\n"},
+		{FormatNDJSON, `{"id":1,"status":"new","total":10.50}` + "\n" + `{"id":2,"status":"new","total":null}` + "\n"},
+		{FormatSQL, "-- MySQL dump\nINSERT INTO `orders` VALUES (1,'new',10.50),(2,'new',NULL);\nINSERT INTO `customers` VALUES (1,'x');\n"},
+	}
+	for _, snapshot := range snapshots {
+		t.Run(snapshot.format, func(t *testing.T) {
+			store, err := CreateStore(filepath.Join(dir, "store.db"), "shop", "orders", storeColumns)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer store.Close()
+			count, err := store.Load(strings.NewReader(snapshot.input), snapshot.format)
+			if err != nil || count != 2 {
+				t.Fatal(fmt.Sprintf("Expected to load 2 rows, got %d, %v", count, err))
+			}
+
+			apply, err := store.Sink()
+			if err != nil {
+				t.Fatal(err)
+			}
+			header := parser.NewMessageHeader("shop", "orders", time.Unix(1492070524, 0), 635, 8)
+			header.Columns = storeColumns
+			row := func(id int32, status int64, total interface{}) parser.MessageRowData {
+				return parser.MessageRowData{Row: parser.MessageRow{"id": id, "status": status, "total": total}}
+			}
+			for _, message := range []parser.Message{
+				parser.NewUpdateMessage(header, row(1, 1, "10.50"), row(1, 2, "10.50")),
+				parser.NewDeleteMessage(header, row(2, 1, nil)),
+				parser.NewInsertMessage(header, row(3, 1, "7.00")),
+			} {
+				if err := apply.Write(message); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := apply.Commit(8); err != nil {
+				t.Fatal(err)
+			}
+			apply.Close()
+
+			var out bytes.Buffer
+			if err := store.Export(&out, FormatCSV); err != nil {
+				t.Fatal(err)
+			}
+			if expected := "id,status,total\n1,paid,10.5\n3,new,7\n"; out.String() != expected {
+				t.Fatal(fmt.Sprintf("Expected %q, got %q", expected, out.String()))
+			}
+			out.Reset()
+			if err := store.Export(&out, FormatNDJSON); err != nil {
+				t.Fatal(err)
+			}
+			if expected := `{"id":1,"status":"paid","total":10.5}` + "\n" + `{"id":3,"status":"new","total":7}` + "\n"; out.String() != expected {
+				t.Fatal(fmt.Sprintf("Expected %q, got %q", expected, out.String()))
+			}
+		})
+	}
+}
+
+func TestStoreLoadUnknownColumn(t *testing.T) {
+	dir, err := ioutil.TempDir("", "restore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store, err := CreateStore(filepath.Join(dir, "store.db"), "shop", "orders", storeColumns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if _, err := store.Load(strings.NewReader(`{"id":1,"customer":2}`), FormatNDJSON); err == nil || !strings.Contains(err.Error(), "customer") {
+		t.Fatal(fmt.Sprintf("Expected unknown column error, got %v", err))
+	}
+}
+
+var roomColumns = []database.Column{
+	{Name: "id", DataType: "int", ColumnType: "int(11)", PrimaryKey: true},
+	{Name: "name", DataType: "varchar", ColumnType: "varchar(255)"},
+	{Name: "building", DataType: "int", ColumnType: "int(11)"},
+}
+
+// splitBinlog splits the transactions of mysql-bin.01 into two binlogs, the
+// second one starts with the transaction at offset 723 after the header of
+// the first one
+func splitBinlog(t *testing.T) (first, second []byte) {
+	data, err := ioutil.ReadFile("../../test/data/fixtures/mysql-bin.01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	const header, split = 120, 723
+	second = append(append([]byte{}, data[:header]...), data[split:]...)
+	for offset := header; offset+19 <= len(second); {
+		logPos := binary.LittleEndian.Uint32(second[offset+13:])
+		binary.LittleEndian.PutUint32(second[offset+13:], logPos-(split-header))
+		offset += int(binary.LittleEndian.Uint32(second[offset+9:]))
+	}
+	return data[:split], second
+}
+
+// namedRow names the columns of a row parsed without database connection
+func namedRow(data parser.MessageRowData) parser.MessageRowData {
+	row := parser.MessageRow{}
+	for i, column := range roomColumns {
+		row[column.Name] = data.Row[fmt.Sprintf("(unknown_%d)", i)]
+	}
+	return parser.MessageRowData{Row: row}
+}
+
+func TestStoreBinlogs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "restore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	first, second := splitBinlog(t)
+
+	tests := []struct {
+		name       string
+		binlogFile string
+		position   uint32
+		stop       time.Time
+		expected   string
+	}{
+		{"no stop", "", 0, time.Time{}, "id,name,building\n1,Amazon,1\n2,War Room,1\n3,Office of CEO,1\n4,MARKETING,2\n5,SHOWROOM,2\n"},
+		// the transaction of the update starts at 120 of the second binlog
+		{"second binlog before the update", "mysql-bin.000002", 120, time.Time{}, "id,name,building\n1,Amazon,1\n2,War Room,1\n3,Office of CEO,1\n4,Marketing,2\n5,Showroom,2\n"},
+		{"second binlog within the update", "mysql-bin.000002", 250, time.Time{}, "id,name,building\n1,Amazon,1\n2,War Room,1\n3,Office of CEO,1\n4,MARKETING,2\n5,SHOWROOM,2\n"},
+		// the same position in the first binlog stops within the inserts
+		{"first binlog within the inserts", "mysql-bin.000001", 600, time.Time{}, "id,name,building\n1,Amazon,1\n2,War Room,1\n3,Office of CEO,1\n4,Marketing,2\n5,Showroom,2\n"},
+		{"first binlog before the inserts", "mysql-bin.000001", 428, time.Time{}, "id,name,building\n"},
+		{"time of the update", "", 0, time.Date(2017, 4, 13, 6, 34, 58, 0, time.UTC), "id,name,building\n1,Amazon,1\n2,War Room,1\n3,Office of CEO,1\n4,Marketing,2\n5,Showroom,2\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			store, err := CreateStore(filepath.Join(dir, "store.db"), "test_db", "rooms", roomColumns)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer store.Close()
+			apply, err := store.Sink()
+			if err != nil {
+				t.Fatal(err)
+			}
+			p := parser.New(database.Offline(), func(message parser.Message) error {
+				header := message.GetHeader()
+				header.Columns = roomColumns
+				switch message := message.(type) {
+				case parser.InsertMessage:
+					return apply.Write(parser.NewInsertMessage(header, namedRow(message.Data)))
+				case parser.UpdateMessage:
+					return apply.Write(parser.NewUpdateMessage(header, namedRow(message.OldData), namedRow(message.NewData)))
+				case parser.DeleteMessage:
+					return apply.Write(parser.NewDeleteMessage(header, namedRow(message.Data)))
+				}
+				return nil
+			})
+			p.OnCommit(apply.Commit)
+			p.IncludeTables([]string{"rooms"})
+			p.StopAt(test.binlogFile, test.position, test.stop)
+			if err := p.ParseReader("mysql-bin.000001", bytes.NewReader(first)); err != nil {
+				t.Fatal(err)
+			}
+			if err := p.ParseReader("mysql-bin.000002", bytes.NewReader(second)); err != nil {
+				t.Fatal(err)
+			}
+			apply.Close()
+
+			var out bytes.Buffer
+			if err := store.Export(&out, FormatCSV); err != nil {
+				t.Fatal(err)
+			}
+			if out.String() != test.expected {
+				t.Fatal(fmt.Sprintf("Expected %q, got %q", test.expected, out.String()))
+			}
+		})
+	}
+}
+
+func TestCheckDriver(t *testing.T) {
+	if err := CheckDriver(); err != nil {
+		t.Fatal(fmt.Sprintf("Expected the sqlite3 driver to work - %s", err))
+	}
+}
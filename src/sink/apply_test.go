@@ -0,0 +1,271 @@
+package sink
+
+import (
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/tanema/binlog-parser/src/database"
+	"github.com/tanema/binlog-parser/src/parser"
+)
+
+var applyColumns = []database.Column{
+	{Name: "emp_no", DataType: "int", ColumnType: "int(11)", PrimaryKey: true},
+	{Name: "first_name", DataType: "varchar", ColumnType: "varchar(14)", Nullable: true},
+}
+
+func openTestApply(t *testing.T, dir string) *Apply {
+	db, err := sql.Open("sqlite3", filepath.Join(dir, "replica.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("CREATE TABLE staff (emp_no INTEGER PRIMARY KEY, first_name TEXT)"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("INSERT INTO staff VALUES (1, 'Georgi')"); err != nil {
+		t.Fatal(err)
+	}
+	apply := NewApply(db, DialectSQLite)
+	apply.Schemas = map[string]string{"test_db": ""}
+	apply.Tables = map[string]string{"employees": "staff"}
+	return apply
+}
+
+func applyRows(t *testing.T, apply *Apply) []string {
+	rows, err := apply.db.Query("SELECT emp_no, first_name FROM staff ORDER BY emp_no")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var result []string
+	for rows.Next() {
+		var empNo int
+		var firstName sql.NullString
+		rows.Scan(&empNo, &firstName)
+		result = append(result, fmt.Sprintf("%d:%s", empNo, firstName.String))
+	}
+	return result
+}
+
+func applyMessages(empNo int32) []parser.Message {
+	header := parser.NewMessageHeader("test_db", "employees", time.Unix(1492070524, 0), 635, 8)
+	header.Columns = applyColumns
+	row := func(empNo int32, name string) parser.MessageRowData {
+		return parser.MessageRowData{Row: parser.MessageRow{"emp_no": empNo, "first_name": name}}
+	}
+	return []parser.Message{
+		parser.NewInsertMessage(header, row(empNo, "Bezalel")),
+		parser.NewUpdateMessage(header, row(empNo+1, "Parto"), row(empNo+1, "Chirstian")),
+		parser.NewDeleteMessage(header, row(empNo+2, "Kyoichi")),
+	}
+}
+
+func TestApply(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "apply")
+	defer os.RemoveAll(dir)
+	apply := openTestApply(t, dir)
+	defer apply.Close()
+	apply.BatchSize = 2
+
+	header := parser.NewMessageHeader("test_db", "employees", time.Unix(1492070524, 0), 635, 8)
+	header.Columns = applyColumns
+	messages := []parser.Message{
+		parser.NewInsertMessage(header, parser.MessageRowData{Row: parser.MessageRow{"emp_no": int32(2), "first_name": "Bezalel"}}),
+		parser.NewInsertMessage(header, parser.MessageRowData{Row: parser.MessageRow{"emp_no": int32(3), "first_name": nil}}),
+		parser.NewInsertMessage(header, parser.MessageRowData{Row: parser.MessageRow{"emp_no": int32(4), "first_name": "Parto"}}),
+		parser.NewQueryMessage(header, "DROP TABLE employees"),
+		parser.NewUpdateMessage(header, parser.MessageRowData{Row: parser.MessageRow{"emp_no": int32(1), "first_name": "Georgi"}}, parser.MessageRowData{Row: parser.MessageRow{"emp_no": int32(1), "first_name": "Max"}}),
+		parser.NewDeleteMessage(header, parser.MessageRowData{Row: parser.MessageRow{"emp_no": int32(3), "first_name": nil}}),
+	}
+	for _, message := range messages {
+		if err := apply.Write(message); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := apply.Commit(8); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"1:Max", "2:Bezalel", "4:Parto"}
+	if actual := applyRows(t, apply); !reflect.DeepEqual(actual, expected) {
+		t.Fatal(fmt.Sprintf("Expected rows %v, got %v", expected, actual))
+	}
+}
+
+func TestApplyConflicts(t *testing.T) {
+	testCases := []struct {
+		conflict ConflictPolicy
+		empNo    int32
+		expected []string
+	}{
+		{ConflictError, 1, nil},
+		{ConflictError, 5, []string{"1:Georgi", "5:Bezalel"}},
+		{ConflictSkip, 1, []string{"1:Georgi"}},
+		{ConflictOverwrite, 1, []string{"1:Bezalel", "2:Chirstian"}},
+	}
+	for _, tc := range testCases {
+		dir, _ := ioutil.TempDir("", "apply")
+		apply := openTestApply(t, dir)
+		apply.Conflict = tc.conflict
+
+		messages := applyMessages(tc.empNo)
+		if tc.empNo != 1 {
+			messages = messages[:1]
+		}
+		var err error
+		for _, message := range messages {
+			if err = apply.Write(message); err != nil {
+				break
+			}
+		}
+		if err == nil {
+			err = apply.Commit(8)
+		}
+		if tc.expected == nil {
+			if err == nil {
+				t.Fatal(fmt.Sprintf("Expected conflict error for %s", tc.conflict))
+			}
+		} else if err != nil {
+			t.Fatal(err)
+		} else if actual := applyRows(t, apply); !reflect.DeepEqual(actual, tc.expected) {
+			t.Fatal(fmt.Sprintf("Expected rows %v for %s, got %v", tc.expected, tc.conflict, actual))
+		}
+		apply.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestApplyRollback(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "apply")
+	defer os.RemoveAll(dir)
+	apply := openTestApply(t, dir)
+
+	for _, message := range applyMessages(5) {
+		if err := apply.Write(message); err != nil {
+			break
+		}
+	}
+	apply.Flush()
+	apply.Close()
+
+	db, _ := sql.Open("sqlite3", filepath.Join(dir, "replica.db"))
+	defer db.Close()
+	var count int
+	db.QueryRow("SELECT COUNT(*) FROM staff").Scan(&count)
+	if count != 1 {
+		t.Fatal(fmt.Sprintf("Expected the transaction to be rolled back, got %d rows", count))
+	}
+}
+
+func TestApplyText(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "apply")
+	defer os.RemoveAll(dir)
+	db, err := sql.Open("sqlite3", filepath.Join(dir, "replica.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("CREATE TABLE notes (body TEXT, doc JSON, photo BLOB)"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`INSERT INTO notes VALUES ('snapshot', '{"a":0}', x'cafe')`); err != nil {
+		t.Fatal(err)
+	}
+	apply := NewApply(db, DialectSQLite)
+	apply.Schemas = map[string]string{"test_db": ""}
+	defer apply.Close()
+
+	header := parser.NewMessageHeader("test_db", "notes", time.Unix(1492070524, 0), 635, 8)
+	header.Columns = []database.Column{
+		{Name: "body", DataType: "text", ColumnType: "text"},
+		{Name: "doc", DataType: "json", ColumnType: "json"},
+		{Name: "photo", DataType: "blob", ColumnType: "blob"},
+	}
+	row := func(body, doc string) parser.MessageRowData {
+		return parser.MessageRowData{Row: parser.MessageRow{"body": []byte(body), "doc": []byte(doc), "photo": []byte{0xca, 0xfe}}}
+	}
+	messages := []parser.Message{
+		parser.NewInsertMessage(header, row("first", `{"a":1}`)),
+		parser.NewInsertMessage(header, row("second", `{"a":2}`)),
+		parser.NewUpdateMessage(header, row("first", `{"a":1}`), row("changed", `{"a":3}`)),
+		parser.NewDeleteMessage(header, row("second", `{"a":2}`)),
+		parser.NewDeleteMessage(header, row("snapshot", `{"a":0}`)),
+	}
+	for _, message := range messages {
+		if err := apply.Write(message); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := apply.Commit(8); err != nil {
+		t.Fatal(err)
+	}
+
+	var body, doc, bodyType, photoType string
+	if err := db.QueryRow("SELECT body, doc, typeof(body), typeof(photo) FROM notes").Scan(&body, &doc, &bodyType, &photoType); err != nil {
+		t.Fatal(err)
+	}
+	if body != "changed" || doc != `{"a":3}` || bodyType != "text" || photoType != "blob" {
+		t.Fatal(fmt.Sprintf("Wrong row %s %s %s %s", body, doc, bodyType, photoType))
+	}
+}
+
+func TestApplyStatements(t *testing.T) {
+	testCases := []struct {
+		dialect  Dialect
+		conflict ConflictPolicy
+		keys     []string
+		expected string
+	}{
+		{DialectMySQL, ConflictError, nil, "INSERT INTO t (`a`, `b`) VALUES (?, ?), (?, ?)"},
+		{DialectMySQL, ConflictSkip, nil, "INSERT IGNORE INTO t (`a`, `b`) VALUES (?, ?), (?, ?)"},
+		{DialectMySQL, ConflictOverwrite, nil, "INSERT INTO t (`a`, `b`) VALUES (?, ?), (?, ?) ON DUPLICATE KEY UPDATE `a`=VALUES(`a`), `b`=VALUES(`b`)"},
+		{DialectPostgres, ConflictSkip, nil, `INSERT INTO t ("a", "b") VALUES ($1, $2), ($3, $4) ON CONFLICT DO NOTHING`},
+		{DialectPostgres, ConflictOverwrite, []string{"a"}, `INSERT INTO t ("a", "b") VALUES ($1, $2), ($3, $4) ON CONFLICT ("a") DO UPDATE SET "a"=EXCLUDED."a", "b"=EXCLUDED."b"`},
+		{DialectSQLite, ConflictSkip, nil, `INSERT OR IGNORE INTO t ("a", "b") VALUES (?, ?), (?, ?)`},
+		{DialectSQLite, ConflictOverwrite, nil, `INSERT OR REPLACE INTO t ("a", "b") VALUES (?, ?), (?, ?)`},
+	}
+	for _, tc := range testCases {
+		actual, err := tc.dialect.insert("t", []string{"a", "b"}, tc.keys, 2, tc.conflict)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual != tc.expected {
+			t.Fatal(fmt.Sprintf("Expected %s, got %s", tc.expected, actual))
+		}
+	}
+	if _, err := DialectPostgres.insert("t", []string{"a"}, nil, 1, ConflictOverwrite); err == nil {
+		t.Fatal("Expected error for overwrite without primary key in PostgreSQL")
+	}
+
+	apply := NewApply(nil, DialectPostgres)
+	header := parser.NewMessageHeader("test_db", "employees", time.Unix(1492070524, 0), 635, 8)
+	where, args, _ := apply.where(header, `"t"`, parser.MessageRowData{Row: parser.MessageRow{"a": 1, "b": nil}}, 2)
+	if expected := `ctid IN (SELECT ctid FROM "t" WHERE "a"=$3 AND "b" IS NULL LIMIT 1)`; where != expected || len(args) != 1 {
+		t.Fatal(fmt.Sprintf("Expected %s, got %s %v", expected, where, args))
+	}
+}
+
+func TestApplyTable(t *testing.T) {
+	apply := NewApply(nil, DialectMySQL)
+	apply.Schemas = map[string]string{"test_db": "replica"}
+	apply.Tables = map[string]string{"test_db.employees": "archive.staff", "titles": "roles"}
+	testCases := []struct {
+		schema   string
+		table    string
+		expected string
+	}{
+		{"test_db", "employees", "`archive`.`staff`"},
+		{"test_db", "titles", "`replica`.`roles`"},
+		{"other_db", "employees", "`other_db`.`employees`"},
+	}
+	for _, tc := range testCases {
+		header := parser.NewMessageHeader(tc.schema, tc.table, time.Unix(1492070524, 0), 635, 8)
+		if actual := apply.table(header); actual != tc.expected {
+			t.Fatal(fmt.Sprintf("Expected %s, got %s", tc.expected, actual))
+		}
+	}
+}
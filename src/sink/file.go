@@ -0,0 +1,210 @@
+package sink
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/tanema/binlog-parser/src/format"
+	"github.com/tanema/binlog-parser/src/parser"
+)
+
+// RotatingFile writes the formatted messages to a file named by a pattern with
+// the strftime verbs %Y, %m, %d, %H, %M and %S, expanded with the binlog time
+// of the messages. The file is rotated between transactions once it reached
+// MaxSize or the pattern expands to another name. Closed files are
+// compressed with gzip if Compress is set. A file name that is already taken
+// gets a sequence number before its extension, changes-20170413.1.ndjson.
+type RotatingFile struct {
+	Pattern  string
+	MaxSize  int64
+	Compress bool
+	formatted
+	file   *os.File
+	w      *bufio.Writer
+	name   string
+	size   int64
+	period string
+	last   time.Time
+	// inTransaction is set from the first write after a flush
+	inTransaction bool
+}
+
+// NewRotatingFile creates a sink writing to files named by the pattern
+func NewRotatingFile(pattern string, formatter format.Formatter) *RotatingFile {
+	return &RotatingFile{Pattern: pattern, formatted: formatted{formatter}}
+}
+
+// Write writes the formatted message to the current file
+func (r *RotatingFile) Write(message parser.Message) error {
+	data, err := r.format(message)
+	if err != nil {
+		return err
+	}
+	return r.write(data, messageTime(message))
+}
+
+// Commit writes the output held back for the transaction, flushes and
+// rotates the file if needed
+func (r *RotatingFile) Commit(xid uint64) error {
+	data, err := r.commit(xid)
+	if err != nil {
+		return err
+	}
+	if err := r.write(data, r.last); err != nil {
+		return err
+	}
+	return r.Flush()
+}
+
+// Flush writes the buffered output and rotates the file if needed, it must
+// only be called at the end of a transaction
+func (r *RotatingFile) Flush() error {
+	if r.file == nil {
+		return nil
+	}
+	if err := r.w.Flush(); err != nil {
+		return err
+	}
+	r.inTransaction = false
+	if (r.MaxSize > 0 && r.size >= r.MaxSize) || expandPattern(r.Pattern, r.last) != r.period {
+		return r.closeFile()
+	}
+	return nil
+}
+
+// Close flushes and closes the current file and the formatter
+func (r *RotatingFile) Close() error {
+	err := r.closeFile()
+	if closeErr := r.close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func (r *RotatingFile) write(data []byte, t time.Time) error {
+	if data == nil {
+		return nil
+	}
+	if r.file != nil && !r.inTransaction && expandPattern(r.Pattern, t) != r.period {
+		if err := r.closeFile(); err != nil {
+			return err
+		}
+	}
+	if r.file == nil {
+		if err := r.open(t); err != nil {
+			return err
+		}
+	}
+	r.last = t
+	r.inTransaction = true
+	n, err := r.w.Write(data)
+	r.size += int64(n)
+	return err
+}
+
+func (r *RotatingFile) open(t time.Time) error {
+	r.period = expandPattern(r.Pattern, t)
+	if dir := filepath.Dir(r.period); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	name := unusedFileName(r.period)
+	file, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	r.file, r.w, r.name, r.size = file, bufio.NewWriter(file), name, 0
+	return nil
+}
+
+// closeFile flushes, closes and compresses the current file
+func (r *RotatingFile) closeFile() error {
+	if r.file == nil {
+		return nil
+	}
+	err := r.w.Flush()
+	if closeErr := r.file.Close(); err == nil {
+		err = closeErr
+	}
+	r.file, r.w = nil, nil
+	if err == nil && r.Compress {
+		err = gzipFile(r.name)
+	}
+	return err
+}
+
+// unusedFileName returns the name, or the name with the first sequence number
+// that neither exists as it is nor compressed
+func unusedFileName(name string) string {
+	extension := filepath.Ext(name)
+	base := strings.TrimSuffix(name, extension)
+	for i := 0; ; i++ {
+		candidate := name
+		if i > 0 {
+			candidate = fmt.Sprintf("%s.%d%s", base, i, extension)
+		}
+		if !fileExists(candidate) && !fileExists(candidate+".gz") {
+			return candidate
+		}
+	}
+}
+
+func fileExists(name string) bool {
+	_, err := os.Stat(name)
+	return !os.IsNotExist(err)
+}
+
+// gzipFile replaces the file with its gzip compressed version
+func gzipFile(name string) error {
+	in, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(name + ".gz")
+	if err != nil {
+		return err
+	}
+	writer := gzip.NewWriter(out)
+	_, err = io.Copy(writer, in)
+	if closeErr := writer.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Remove(name)
+}
+
+var patternLayouts = map[byte]string{'Y': "2006", 'm': "01", 'd': "02", 'H': "15", 'M': "04", 'S': "05"}
+
+// expandPattern replaces the strftime verbs of the pattern with the time
+func expandPattern(pattern string, t time.Time) string {
+	var expanded strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		if pattern[i] != '%' || i+1 == len(pattern) {
+			expanded.WriteByte(pattern[i])
+			continue
+		}
+		i++
+		if layout, ok := patternLayouts[pattern[i]]; ok {
+			expanded.WriteString(t.Format(layout))
+		} else if pattern[i] == '%' {
+			expanded.WriteByte('%')
+		} else {
+			expanded.WriteByte('%')
+			expanded.WriteByte(pattern[i])
+		}
+	}
+	return expanded.String()
+}
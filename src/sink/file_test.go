@@ -0,0 +1,114 @@
+package sink
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/tanema/binlog-parser/src/format"
+	"github.com/tanema/binlog-parser/src/parser"
+)
+
+func TestRotatingFile(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "sink")
+	defer os.RemoveAll(dir)
+
+	day := time.Unix(1492070524, 0)
+	data := parser.MessageRowData{Row: parser.MessageRow{"emp_no": int32(1)}}
+	insert := func(t time.Time, xid uint64) parser.Message {
+		return parser.NewInsertMessage(parser.NewMessageHeader("test_db", "employees", t, 635, xid), data)
+	}
+
+	file := NewRotatingFile(filepath.Join(dir, "changes-%Y%m%d.sql"), format.NewSQL())
+	file.MaxSize = 100
+	transactions := [][]parser.Message{
+		{insert(day, 1), insert(day, 1)},
+		{insert(day, 2)},
+		{insert(day.Add(24*time.Hour), 3)},
+	}
+	for i, transaction := range transactions {
+		for _, message := range transaction {
+			if err := file.Write(message); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := file.Commit(uint64(i + 1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := file.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	statement := "INSERT INTO `test_db`.`employees` (`emp_no`) VALUES (1);\n"
+	expected := map[string]string{
+		"changes-20170413.sql":   "BEGIN; /* xid 1 */\n" + statement + statement + "COMMIT;\n",
+		"changes-20170413.1.sql": "BEGIN; /* xid 2 */\n" + statement + "COMMIT;\n",
+		"changes-20170414.sql":   "BEGIN; /* xid 3 */\n" + statement + "COMMIT;\n",
+	}
+	if actual := readFiles(t, dir); !reflect.DeepEqual(actual, expected) {
+		t.Fatal(fmt.Sprintf("Expected files %v, got %v", expected, actual))
+	}
+}
+
+func TestRotatingFileCompress(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "sink")
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "changes.ndjson.gz"), nil, 0644)
+
+	file := NewRotatingFile(filepath.Join(dir, "changes.ndjson"), format.JSON{})
+	file.Compress = true
+	file.Write(parser.NewQueryMessage(parser.NewMessageHeader("test_db", "employees", time.Unix(1492070524, 0), 635, 0), "DROP TABLE employees"))
+	if err := file.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*"))
+	sort.Strings(files)
+	expected := []string{filepath.Join(dir, "changes.1.ndjson.gz"), filepath.Join(dir, "changes.ndjson.gz")}
+	if !reflect.DeepEqual(files, expected) {
+		t.Fatal(fmt.Sprintf("Expected files %v, got %v", expected, files))
+	}
+	compressed, _ := os.Open(expected[0])
+	defer compressed.Close()
+	reader, err := gzip.NewReader(compressed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, _ := ioutil.ReadAll(reader)
+	if len(content) == 0 || content[len(content)-1] != '\n' {
+		t.Fatal(fmt.Sprintf("Wrong compressed content %q", content))
+	}
+}
+
+func TestExpandPattern(t *testing.T) {
+	actual := expandPattern("out/%Y/%m/%d/%H%M%S-%%-%x.ndjson%", time.Date(2017, 4, 13, 8, 2, 4, 0, time.UTC))
+	if actual != "out/2017/04/13/080204-%-%x.ndjson%" {
+		t.Fatal(fmt.Sprintf("Wrong expansion %s", actual))
+	}
+}
+
+// readFiles returns the content of all files below the directory by their
+// relative path
+func readFiles(t *testing.T, dir string) map[string]string {
+	files := map[string]string{}
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		name, _ := filepath.Rel(dir, path)
+		files[filepath.ToSlash(name)] = string(content)
+		return nil
+	})
+	return files
+}
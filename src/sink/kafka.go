@@ -0,0 +1,260 @@
+package sink
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Shopify/sarama"
+	"github.com/tanema/binlog-parser/src/format"
+	"github.com/tanema/binlog-parser/src/parser"
+)
+
+// KafkaDefaultTopic is the topic template of a Kafka sink
+const KafkaDefaultTopic = "{schema}.{table}"
+
+// Kafka produces the formatted messages to the topic of their table, named by
+// the Topic template with the placeholders {schema} and {table}. Messages are
+// keyed by the primary key values of their row as JSON array, or by the table
+// name if the key is unknown, so the changes of a row keep their order on one
+// partition. The messages of a transaction are sent when it ends and the
+// Checkpoint file, if set, is only written once the broker acknowledged them.
+// With a transaction formatter the rows of a transaction are formatted when
+// it ends, table by table, and output that renders several rows is keyed by
+// the table name.
+type Kafka struct {
+	Topic      string
+	Checkpoint string
+	formatted
+	producer    sarama.SyncProducer
+	pending     []*sarama.ProducerMessage
+	position    *Checkpoint
+	transaction tableTransaction
+}
+
+// Checkpoint is the binlog position up to which the output was delivered
+type Checkpoint struct {
+	BinlogFile     string
+	BinlogPosition uint32
+	XID            uint64
+}
+
+// NewKafka creates a sink producing to the brokers
+func NewKafka(brokers []string, config *sarama.Config, formatter format.Formatter) (*Kafka, error) {
+	producer, err := sarama.NewSyncProducer(brokers, config)
+	if err != nil {
+		return nil, err
+	}
+	return &Kafka{Topic: KafkaDefaultTopic, formatted: formatted{formatter}, producer: producer}, nil
+}
+
+// NewKafkaConfig creates the producer configuration of a Kafka sink. acks is
+// all, local or none, compression is none, gzip, snappy, lz4 or zstd. At most
+// one request is in flight per broker, so retries can't reorder messages.
+func NewKafkaConfig(acks, compression string, idempotent bool) (*sarama.Config, error) {
+	config := sarama.NewConfig()
+	config.ClientID = "binlog-parser"
+	config.Version = sarama.V0_11_0_0
+	config.Net.MaxOpenRequests = 1
+	config.Producer.Return.Successes = true
+
+	switch acks {
+	case "all", "-1":
+		config.Producer.RequiredAcks = sarama.WaitForAll
+	case "local", "1", "":
+		config.Producer.RequiredAcks = sarama.WaitForLocal
+	case "none", "0":
+		config.Producer.RequiredAcks = sarama.NoResponse
+	default:
+		return nil, fmt.Errorf("unknown acks %q", acks)
+	}
+
+	switch compression {
+	case "none", "":
+		config.Producer.Compression = sarama.CompressionNone
+	case "gzip":
+		config.Producer.Compression = sarama.CompressionGZIP
+	case "snappy":
+		config.Producer.Compression = sarama.CompressionSnappy
+	case "lz4":
+		config.Producer.Compression = sarama.CompressionLZ4
+	case "zstd":
+		config.Producer.Compression = sarama.CompressionZSTD
+		config.Version = sarama.V2_1_0_0
+	default:
+		return nil, fmt.Errorf("unknown compression %q", compression)
+	}
+
+	if idempotent {
+		if acks == "" {
+			config.Producer.RequiredAcks = sarama.WaitForAll
+		}
+		config.Producer.Idempotent = true
+	}
+	return config, config.Validate()
+}
+
+// Write queues the formatted message for the end of the transaction, or
+// holds it back until then
+func (k *Kafka) Write(message parser.Message) error {
+	if k.holds(message) {
+		k.transaction.add(message)
+		return nil
+	}
+	data, err := k.formatter.Format(message)
+	if err != nil {
+		return err
+	}
+	header := message.GetHeader()
+	if header.XID == 0 {
+		position := Checkpoint{BinlogPosition: header.BinlogPosition}
+		if header.Event != nil {
+			position.BinlogFile = header.Event.BinlogFile
+		}
+		k.position = &position
+	}
+	if data != nil {
+		k.queue(header, messageKey(message), data)
+	}
+	return nil
+}
+
+// Transaction sets the checkpoint to the end of the XID event of the
+// transaction, it's called before the commit
+func (k *Kafka) Transaction(transaction parser.Transaction) error {
+	k.position = &Checkpoint{BinlogFile: transaction.BinlogFile, BinlogPosition: transaction.EndPosition, XID: transaction.XID}
+	return nil
+}
+
+// Commit queues the messages held back for the transaction, sends the
+// transaction and writes the checkpoint
+func (k *Kafka) Commit(xid uint64) error {
+	output, err := k.render(&k.transaction, xid)
+	if err != nil {
+		return err
+	}
+	for _, out := range output {
+		header := out.messages[0].GetHeader()
+		key := messageKey(out.messages[0])
+		if len(out.messages) > 1 {
+			key = tableKey(header)
+		}
+		k.queue(header, key, out.data)
+	}
+	return k.Flush()
+}
+
+func (k *Kafka) queue(header parser.MessageHeader, key, data []byte) {
+	k.pending = append(k.pending, &sarama.ProducerMessage{
+		Topic: k.topic(header),
+		Key:   sarama.ByteEncoder(key),
+		Value: sarama.ByteEncoder(data),
+	})
+}
+
+// Flush sends the queued messages, waits until the broker acknowledged them
+// and writes the checkpoint
+func (k *Kafka) Flush() error {
+	if len(k.pending) > 0 {
+		if err := k.producer.SendMessages(k.pending); err != nil {
+			return err
+		}
+		k.pending = nil
+	}
+	if k.position == nil || k.Checkpoint == "" {
+		return nil
+	}
+	if err := k.position.write(k.Checkpoint); err != nil {
+		return err
+	}
+	k.position = nil
+	return nil
+}
+
+// Close sends the queued messages and closes the producer and the formatter
+func (k *Kafka) Close() error {
+	err := k.Flush()
+	if closeErr := k.producer.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := k.close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// topic expands the topic template for the table of the message, characters
+// Kafka doesn't allow in topic names are replaced by underscores
+func (k *Kafka) topic(header parser.MessageHeader) string {
+	topic := strings.NewReplacer("{schema}", header.Schema, "{table}", header.Table).Replace(k.Topic)
+	return strings.Map(func(r rune) rune {
+		if r == '.' || r == '_' || r == '-' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, topic)
+}
+
+// messageKey is the JSON array of the primary key values of the row, the new
+// row for updates, or the table name if the message has no row or the
+// primary key is unknown
+func messageKey(message parser.Message) []byte {
+	header := message.GetHeader()
+	var row parser.MessageRow
+	switch m := message.(type) {
+	case parser.InsertMessage:
+		row = m.Data.Row
+	case parser.UpdateMessage:
+		row = m.NewData.Row
+	case parser.DeleteMessage:
+		row = m.Data.Row
+	}
+
+	if values := header.PrimaryKey(row); row != nil && len(values) > 0 {
+		if key, err := json.Marshal(values); err == nil {
+			return key
+		}
+	}
+	return tableKey(header)
+}
+
+// tableKey is the key of messages of the table that aren't of a single row
+func tableKey(header parser.MessageHeader) []byte {
+	return []byte(header.Schema + "." + header.Table)
+}
+
+// ReadCheckpoint reads a checkpoint file
+func ReadCheckpoint(path string) (Checkpoint, error) {
+	var checkpoint Checkpoint
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return checkpoint, err
+	}
+	return checkpoint, json.Unmarshal(data, &checkpoint)
+}
+
+// write replaces the checkpoint file, through a rename so it's never left
+// half written
+func (c Checkpoint) write(path string) error {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,229 @@
+package sink
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/tanema/binlog-parser/src/database"
+	"github.com/tanema/binlog-parser/src/format"
+	"github.com/tanema/binlog-parser/src/parser"
+)
+
+// recordingProducer records the messages sent to the fake broker
+type recordingProducer struct {
+	sarama.SyncProducer
+	sent []*sarama.ProducerMessage
+}
+
+func (p *recordingProducer) SendMessages(messages []*sarama.ProducerMessage) error {
+	p.sent = append(p.sent, messages...)
+	return p.SyncProducer.SendMessages(messages)
+}
+
+func newTestKafka(t *testing.T, produceError sarama.KError, formatter format.Formatter) (*Kafka, *recordingProducer, func()) {
+	broker := sarama.NewMockBroker(t, 1)
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetLeader("test_db.employees", 0, broker.BrokerID()).
+			SetLeader("test_db.titles", 0, broker.BrokerID()),
+		"ProduceRequest": sarama.NewMockProduceResponse(t).
+			SetVersion(3).
+			SetError("test_db.employees", 0, produceError).
+			SetError("test_db.titles", 0, produceError),
+	})
+	config, err := NewKafkaConfig("all", "gzip", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Producer.Retry.Max = 0
+	kafka, err := NewKafka([]string{broker.Addr()}, config, formatter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	producer := &recordingProducer{SyncProducer: kafka.producer}
+	kafka.producer = producer
+	return kafka, producer, broker.Close
+}
+
+func testKafkaMessages() []parser.Message {
+	header := parser.NewMessageHeader("test_db", "employees", time.Unix(1492070524, 0), 635, 8)
+	header.Event = &parser.EventMetadata{BinlogFile: "mysql-bin.000001"}
+	header.Columns = []database.Column{{Name: "emp_no", PrimaryKey: true}, {Name: "first_name"}}
+	return []parser.Message{
+		parser.NewInsertMessage(header, parser.MessageRowData{Row: parser.MessageRow{"emp_no": int32(1), "first_name": "Max"}}),
+		parser.NewUpdateMessage(header, parser.MessageRowData{Row: parser.MessageRow{"emp_no": int32(1)}}, parser.MessageRowData{Row: parser.MessageRow{"emp_no": int32(2)}}),
+	}
+}
+
+func TestKafka(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "kafka")
+	defer os.RemoveAll(dir)
+	kafka, producer, stop := newTestKafka(t, sarama.ErrNoError, format.JSON{})
+	defer stop()
+	kafka.Checkpoint = filepath.Join(dir, "checkpoint")
+
+	if err := kafka.Transaction(parser.Transaction{XID: 8, BinlogFile: "mysql-bin.000001", StartPosition: 500, EndPosition: 700}); err != nil {
+		t.Fatal(err)
+	}
+	for _, message := range testKafkaMessages() {
+		if err := kafka.Write(message); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(producer.sent) != 0 {
+		t.Fatal("Expected messages to be held back until the commit")
+	}
+	if err := kafka.Commit(8); err != nil {
+		t.Fatal(err)
+	}
+	if err := kafka.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var keys []string
+	for _, message := range producer.sent {
+		if message.Topic != "test_db.employees" {
+			t.Fatal(fmt.Sprintf("Wrong topic %s", message.Topic))
+		}
+		key, _ := message.Key.Encode()
+		keys = append(keys, string(key))
+	}
+	if expected := []string{"[1]", "[2]"}; !reflect.DeepEqual(keys, expected) {
+		t.Fatal(fmt.Sprintf("Expected keys %v, got %v", expected, keys))
+	}
+
+	checkpoint, err := ReadCheckpoint(kafka.Checkpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := Checkpoint{BinlogFile: "mysql-bin.000001", BinlogPosition: 700, XID: 8}
+	if checkpoint != expected {
+		t.Fatal(fmt.Sprintf("Expected checkpoint %+v, got %+v", expected, checkpoint))
+	}
+}
+
+func TestKafkaTransactionFormatter(t *testing.T) {
+	kafka, producer, stop := newTestKafka(t, sarama.ErrNoError, format.NewMaxwell())
+	defer stop()
+
+	messages := testKafkaMessages()
+	titles := messages[0].GetHeader()
+	titles.Table = "titles"
+	titles.Columns = []database.Column{{Name: "title", PrimaryKey: true}}
+	messages = append(messages[:1], parser.NewInsertMessage(titles, parser.MessageRowData{Row: parser.MessageRow{"title": "Engineer"}}), messages[1])
+	for _, message := range messages {
+		if err := kafka.Write(message); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := kafka.Commit(8); err != nil {
+		t.Fatal(err)
+	}
+	if err := kafka.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var sent []string
+	for _, message := range producer.sent {
+		key, _ := message.Key.Encode()
+		value, _ := message.Value.Encode()
+		var row struct {
+			Table  string
+			Type   string
+			Commit bool
+		}
+		json.Unmarshal(value, &row)
+		sent = append(sent, fmt.Sprintf("%s %s %s %s %v", message.Topic, key, row.Table, row.Type, row.Commit))
+	}
+	expected := []string{
+		"test_db.employees [1] employees insert false",
+		"test_db.employees [2] employees update false",
+		"test_db.titles [\"Engineer\"] titles insert true",
+	}
+	if !reflect.DeepEqual(sent, expected) {
+		t.Fatal(fmt.Sprintf("Expected messages %v, got %v", expected, sent))
+	}
+}
+
+func TestKafkaNotAcknowledged(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "kafka")
+	defer os.RemoveAll(dir)
+	kafka, _, stop := newTestKafka(t, sarama.ErrMessageSizeTooLarge, format.JSON{})
+	defer stop()
+	kafka.Checkpoint = filepath.Join(dir, "checkpoint")
+
+	for _, message := range testKafkaMessages() {
+		kafka.Write(message)
+	}
+	if err := kafka.Commit(8); err == nil {
+		t.Fatal("Expected error of the broker")
+	}
+	if _, err := os.Stat(kafka.Checkpoint); !os.IsNotExist(err) {
+		t.Fatal("Expected no checkpoint without acknowledgement")
+	}
+	kafka.pending = nil
+	kafka.Close()
+}
+
+func TestKafkaConfig(t *testing.T) {
+	config, err := NewKafkaConfig("", "snappy", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Producer.RequiredAcks != sarama.WaitForAll || config.Producer.Compression != sarama.CompressionSnappy || !config.Producer.Idempotent {
+		t.Fatal(fmt.Sprintf("Wrong producer config %+v", config.Producer))
+	}
+
+	testCases := []struct {
+		acks        string
+		compression string
+		idempotent  bool
+	}{
+		{"some", "", false},
+		{"all", "brotli", false},
+		{"local", "", true},
+	}
+	for _, tc := range testCases {
+		if _, err := NewKafkaConfig(tc.acks, tc.compression, tc.idempotent); err == nil {
+			t.Fatal(fmt.Sprintf("Expected error for %+v", tc))
+		}
+	}
+}
+
+func TestKafkaTopic(t *testing.T) {
+	header := parser.NewMessageHeader("test db", "employees", time.Unix(1492070524, 0), 635, 8)
+	k := &Kafka{Topic: "binlog.{schema}-{table}"}
+	if topic := k.topic(header); topic != "binlog.test_db-employees" {
+		t.Fatal(fmt.Sprintf("Wrong topic %s", topic))
+	}
+}
+
+func TestMessageKey(t *testing.T) {
+	header := parser.NewMessageHeader("test_db", "employees", time.Unix(1492070524, 0), 635, 8)
+	keyed := header
+	keyed.Columns = []database.Column{{Name: "dept_no", PrimaryKey: true}, {Name: "emp_no", PrimaryKey: true}, {Name: "title"}}
+	row := parser.MessageRowData{Row: parser.MessageRow{"dept_no": "d001", "emp_no": int32(1), "title": "Engineer"}}
+
+	testCases := []struct {
+		message  parser.Message
+		expected string
+	}{
+		{parser.NewInsertMessage(keyed, row), `["d001",1]`},
+		{parser.NewDeleteMessage(keyed, row), `["d001",1]`},
+		{parser.NewInsertMessage(header, row), "test_db.employees"},
+		{parser.NewQueryMessage(keyed, "TRUNCATE employees"), "test_db.employees"},
+	}
+	for _, tc := range testCases {
+		if key := string(messageKey(tc.message)); key != tc.expected {
+			t.Fatal(fmt.Sprintf("Expected key %s, got %s", tc.expected, key))
+		}
+	}
+}
@@ -0,0 +1,379 @@
+package sink
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/tanema/binlog-parser/src/format"
+	"github.com/tanema/binlog-parser/src/parser"
+)
+
+// Sink receives the messages of the parser and writes them in the output
+// format to its destination
+type Sink interface {
+	Write(message parser.Message) error
+	Flush() error
+	Close() error
+}
+
+// TransactionSink is a Sink that is told where transactions end. Commit writes
+// the output the formatter held back for the transaction and flushes, so the
+// destination never ends in the middle of a transaction.
+type TransactionSink interface {
+	Sink
+	Commit(xid uint64) error
+}
+
+// Open creates the sink of an output URI:
+//
+//	stdout://                                   standard output, also "" and "-"
+//	file:///var/out/changes-%Y%m%d.ndjson       a rotating file
+//	tables:///var/out/changes-%Y%m%d.ndjson     a rotating file per table in
+//	                                            /var/out/<schema>/<table>/
+//	kafka://broker1:9092,broker2:9092           a Kafka topic per table
+//	https://hooks.example.com/binlog            a webhook
+//
+// Files accept the parameters max_size, the size in bytes with an optional
+// K, M or G suffix at which the file is rotated, and gzip=true to compress
+// rotated files. The pattern is expanded with the binlog time of the messages.
+// Kafka accepts the parameters topic, the topic template, acks, compression,
+// idempotent=true and checkpoint, the file the delivered position is written
+// to. Webhooks accept the parameters header=name:value, repeatable,
+// hmac_secret, encoding=array or ndjson, batch_size, batch_ms, retries,
+// timeout and dead_letter, so the URL itself can't have a query. Parameter
+// values are percent-encoded.
+func Open(uri string, formatter format.Formatter) (Sink, error) {
+	if uri == "" || uri == "-" {
+		return NewStdout(formatter), nil
+	}
+	parts := strings.SplitN(uri, "://", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("invalid output %q, expected scheme://path", uri)
+	}
+	scheme, path := parts[0], parts[1]
+	params := outputParams{}
+	if i := strings.IndexByte(path, '?'); i >= 0 {
+		values, err := url.ParseQuery(path[i+1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid output %q: %s", uri, err)
+		}
+		params = outputParams(values)
+		path = path[:i]
+	}
+
+	switch scheme {
+	case "stdout":
+		if err := params.rest(); err != nil {
+			return nil, fmt.Errorf("invalid output %q: %s", uri, err)
+		}
+		return NewStdout(formatter), nil
+	case "file":
+		maxSize, compress, err := params.file()
+		if err != nil {
+			return nil, fmt.Errorf("invalid output %q: %s", uri, err)
+		}
+		file := NewRotatingFile(path, formatter)
+		file.MaxSize = maxSize
+		file.Compress = compress
+		return file, nil
+	case "tables":
+		maxSize, compress, err := params.file()
+		if err != nil {
+			return nil, fmt.Errorf("invalid output %q: %s", uri, err)
+		}
+		tables := NewTableDirectory(path, formatter)
+		tables.MaxSize = maxSize
+		tables.Compress = compress
+		return tables, nil
+	case "kafka":
+		topic := params.take("topic")
+		checkpoint := params.take("checkpoint")
+		acks, compression := params.take("acks"), params.take("compression")
+		idempotent, err := params.bool("idempotent")
+		if err == nil {
+			err = params.rest()
+		}
+		var config *sarama.Config
+		if err == nil {
+			config, err = NewKafkaConfig(acks, compression, idempotent)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid output %q: %s", uri, err)
+		}
+		kafka, err := NewKafka(strings.Split(path, ","), config, formatter)
+		if err != nil {
+			return nil, err
+		}
+		if topic != "" {
+			kafka.Topic = topic
+		}
+		kafka.Checkpoint = checkpoint
+		return kafka, nil
+	case "http", "https":
+		webhook, err := params.webhook(scheme+"://"+path, formatter)
+		if err != nil {
+			return nil, fmt.Errorf("invalid output %q: %s", uri, err)
+		}
+		return webhook, nil
+	}
+	return nil, fmt.Errorf("unknown output scheme %q", scheme)
+}
+
+// outputParams are the query parameters of an output URI, each output takes
+// the ones it knows
+type outputParams map[string][]string
+
+// take removes the parameter and returns its last value
+func (p outputParams) take(name string) string {
+	values := p.takeAll(name)
+	if len(values) == 0 {
+		return ""
+	}
+	return values[len(values)-1]
+}
+
+// takeAll removes the parameter and returns all of its values
+func (p outputParams) takeAll(name string) []string {
+	values := p[name]
+	delete(p, name)
+	return values
+}
+
+func (p outputParams) bool(name string) (bool, error) {
+	if _, ok := p[name]; !ok {
+		return false, nil
+	}
+	return strconv.ParseBool(p.take(name))
+}
+
+func (p outputParams) int(name string, value int) (int, error) {
+	if _, ok := p[name]; !ok {
+		return value, nil
+	}
+	return strconv.Atoi(p.take(name))
+}
+
+func (p outputParams) duration(name string, value time.Duration) (time.Duration, error) {
+	if _, ok := p[name]; !ok {
+		return value, nil
+	}
+	return time.ParseDuration(p.take(name))
+}
+
+// file takes the parameters of file outputs
+func (p outputParams) file() (maxSize int64, compress bool, err error) {
+	if _, ok := p["max_size"]; ok {
+		if maxSize, err = parseSize(p.take("max_size")); err != nil {
+			return
+		}
+	}
+	if compress, err = p.bool("gzip"); err != nil {
+		return
+	}
+	return maxSize, compress, p.rest()
+}
+
+// webhook takes the parameters of webhook outputs
+func (p outputParams) webhook(webhookURL string, formatter format.Formatter) (*Webhook, error) {
+	w := NewWebhook(webhookURL, formatter)
+	for _, header := range p.takeAll("header") {
+		kv := strings.SplitN(header, ":", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid header %q, expected name:value", header)
+		}
+		w.Header.Add(strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1]))
+	}
+	if secret := p.take("hmac_secret"); secret != "" {
+		w.Secret = []byte(secret)
+	}
+	switch encoding := p.take("encoding"); encoding {
+	case "ndjson":
+		w.NDJSON = true
+	case "array", "":
+	default:
+		return nil, fmt.Errorf("unknown encoding %q", encoding)
+	}
+	w.DeadLetter = p.take("dead_letter")
+
+	var err error
+	if w.BatchSize, err = p.int("batch_size", 0); err != nil {
+		return nil, err
+	}
+	var batchMillis int
+	if batchMillis, err = p.int("batch_ms", 0); err != nil {
+		return nil, err
+	}
+	w.BatchTime = time.Duration(batchMillis) * time.Millisecond
+	if w.Retries, err = p.int("retries", w.Retries); err != nil {
+		return nil, err
+	}
+	if w.Timeout, err = p.duration("timeout", w.Timeout); err != nil {
+		return nil, err
+	}
+	return w, p.rest()
+}
+
+// rest fails on a parameter the output didn't take
+func (p outputParams) rest() error {
+	for name := range p {
+		return fmt.Errorf("unknown parameter %q", name)
+	}
+	return nil
+}
+
+// parseSize parses a size in bytes with an optional K, M or G suffix
+func parseSize(value string) (int64, error) {
+	multiplier := int64(1)
+	if value != "" {
+		switch value[len(value)-1] {
+		case 'K', 'k':
+			multiplier = 1 << 10
+		case 'M', 'm':
+			multiplier = 1 << 20
+		case 'G', 'g':
+			multiplier = 1 << 30
+		}
+	}
+	if multiplier > 1 {
+		value = value[:len(value)-1]
+	}
+	size, err := strconv.ParseInt(value, 10, 64)
+	return size * multiplier, err
+}
+
+// formatted renders messages for a sink, text output is written one message
+// per line
+type formatted struct {
+	formatter format.Formatter
+}
+
+func (f formatted) format(message parser.Message) ([]byte, error) {
+	data, err := f.formatter.Format(message)
+	return f.frame(data), err
+}
+
+// commit returns the output the formatter held back for the transaction
+func (f formatted) commit(xid uint64) ([]byte, error) {
+	transactionFormatter, ok := f.formatter.(format.TransactionFormatter)
+	if !ok {
+		return nil, nil
+	}
+	data, err := transactionFormatter.Commit(xid)
+	return f.frame(data), err
+}
+
+// holds tells if the message is held back until its transaction ends, row
+// messages are for transaction formatters since their output may render
+// earlier messages
+func (f formatted) holds(message parser.Message) bool {
+	if _, ok := f.formatter.(format.TransactionFormatter); !ok {
+		return false
+	}
+	switch message.(type) {
+	case parser.InsertMessage, parser.UpdateMessage, parser.DeleteMessage:
+		return true
+	}
+	return false
+}
+
+// render formats the held back messages of the transaction table by table
+// and ends the rows of each table, so output only renders messages of its
+// table. Only the last table commits the transaction if the formatter is a
+// TableTransactionFormatter, else each table does. Output is attributed to
+// the oldest message not rendered yet, the output held back until the end to
+// all messages left. The output isn't framed.
+func (f formatted) render(t *tableTransaction, xid uint64) ([]rendered, error) {
+	transactionFormatter, ok := f.formatter.(format.TransactionFormatter)
+	if !ok {
+		return nil, nil
+	}
+	var out []rendered
+	tableFormatter, _ := f.formatter.(format.TableTransactionFormatter)
+	for i, name := range t.tables {
+		messages := t.messages[name]
+		var waiting []parser.Message
+		for _, message := range messages {
+			data, err := f.formatter.Format(message)
+			if err != nil {
+				return nil, err
+			}
+			waiting = append(waiting, message)
+			if data != nil {
+				out = append(out, rendered{data: data, messages: waiting[:1]})
+				waiting = waiting[1:]
+			}
+		}
+		end := func() ([]byte, error) { return transactionFormatter.Commit(xid) }
+		if tableFormatter != nil && i < len(t.tables)-1 {
+			end = tableFormatter.EndTable
+		}
+		data, err := end()
+		if err != nil {
+			return nil, err
+		}
+		if data != nil {
+			if len(waiting) == 0 {
+				waiting = messages[len(messages)-1:]
+			}
+			out = append(out, rendered{data: data, messages: waiting})
+		}
+	}
+	t.tables, t.messages = nil, nil
+	return out, nil
+}
+
+func (f formatted) frame(data []byte) []byte {
+	if data == nil {
+		return nil
+	}
+	if _, ok := f.formatter.(format.BinaryFormatter); ok {
+		return data
+	}
+	return append(data, '\n')
+}
+
+// tableTransaction holds back the row messages of a transaction by table
+type tableTransaction struct {
+	tables   []string
+	messages map[string][]parser.Message
+}
+
+func (t *tableTransaction) add(message parser.Message) {
+	header := message.GetHeader()
+	name := header.Schema + "." + header.Table
+	if t.messages == nil {
+		t.messages = map[string][]parser.Message{}
+	}
+	if _, ok := t.messages[name]; !ok {
+		t.tables = append(t.tables, name)
+	}
+	t.messages[name] = append(t.messages[name], message)
+}
+
+// rendered is formatted output with the messages it renders, all of one table
+type rendered struct {
+	data     []byte
+	messages []parser.Message
+}
+
+// close closes formatters that write their own files
+func (f formatted) close() error {
+	if closer, ok := f.formatter.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// messageTime is the binlog time of the message, or now if it has none
+func messageTime(message parser.Message) time.Time {
+	t, err := time.Parse(time.RFC3339, message.GetHeader().BinlogMessageTime)
+	if err != nil {
+		return time.Now().UTC()
+	}
+	return t
+}
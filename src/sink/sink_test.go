@@ -0,0 +1,68 @@
+package sink
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/tanema/binlog-parser/src/format"
+	"github.com/tanema/binlog-parser/src/parser"
+)
+
+func TestOpen(t *testing.T) {
+	testCases := []struct {
+		uri      string
+		expected Sink
+	}{
+		{"", NewStdout(format.JSON{})},
+		{"stdout://", NewStdout(format.JSON{})},
+		{"file:///var/out/changes-%Y%m%d.ndjson", &RotatingFile{Pattern: "/var/out/changes-%Y%m%d.ndjson", formatted: formatted{format.JSON{}}}},
+		{"file:///var/out/changes.ndjson?max_size=10M&gzip=true", &RotatingFile{Pattern: "/var/out/changes.ndjson", MaxSize: 10 << 20, Compress: true, formatted: formatted{format.JSON{}}}},
+		{"tables:///var/out/%Y.ndjson?max_size=1024", &TableDirectory{Pattern: "/var/out/%Y.ndjson", MaxSize: 1024, formatted: formatted{format.JSON{}}, files: map[string]*RotatingFile{}}},
+		{"https://hooks.example.com/binlog?header=Authorization:Bearer%20token&hmac_secret=s%2Bc%3D%26&encoding=ndjson&batch_size=100&batch_ms=500&timeout=5s&dead_letter=/var/out/dead.ndjson", &Webhook{
+			URL:        "https://hooks.example.com/binlog",
+			Header:     http.Header{"Authorization": []string{"Bearer token"}},
+			Secret:     []byte("s+c=&"),
+			NDJSON:     true,
+			BatchSize:  100,
+			BatchTime:  500 * time.Millisecond,
+			Timeout:    5 * time.Second,
+			Retries:    5,
+			Backoff:    100 * time.Millisecond,
+			DeadLetter: "/var/out/dead.ndjson",
+			formatted:  formatted{format.JSON{}},
+		}},
+	}
+	for _, tc := range testCases {
+		actual, err := Open(tc.uri, format.JSON{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(actual, tc.expected) {
+			t.Fatal(fmt.Sprintf("Wrong sink for %s: %+v", tc.uri, actual))
+		}
+	}
+
+	for _, uri := range []string{"/var/out", "smtp://localhost", "kafka://localhost:9092?linger=5", "kafka://localhost:9092?acks=some", "stdout://?gzip=true", "http://localhost?encoding=xml", "http://localhost?header=token", "file:///var/out?max_size=big", "file:///var/out?rotate=daily", "file:///var/out?max_size=%zz"} {
+		if _, err := Open(uri, format.JSON{}); err == nil {
+			t.Fatal(fmt.Sprintf("Expected error for %s", uri))
+		}
+	}
+}
+
+func TestFormattedFrame(t *testing.T) {
+	message := parser.NewQueryMessage(parser.NewMessageHeader("test_db", "employees", time.Unix(1492070524, 0), 635, 0), "DROP TABLE employees")
+	text, _ := formatted{format.JSON{}}.format(message)
+	if text[len(text)-1] != '\n' {
+		t.Fatal(fmt.Sprintf("Expected text output to end with a line break, got %q", text))
+	}
+	binary, _ := formatted{format.Protobuf{}}.format(message)
+	if binary[len(binary)-1] == '\n' {
+		t.Fatal("Expected binary output without line break")
+	}
+	if data, _ := (formatted{format.NewCSV(".")}).format(message); data != nil {
+		t.Fatal(fmt.Sprintf("Expected no output, got %q", data))
+	}
+}
@@ -0,0 +1,63 @@
+package sink
+
+import (
+	"bufio"
+	"io"
+	"os"
+
+	"github.com/tanema/binlog-parser/src/format"
+	"github.com/tanema/binlog-parser/src/parser"
+)
+
+// Stream writes the formatted messages to a writer, buffered until the end of
+// each transaction
+type Stream struct {
+	formatted
+	w *bufio.Writer
+}
+
+// NewStream creates a sink writing to w
+func NewStream(w io.Writer, formatter format.Formatter) *Stream {
+	return &Stream{formatted: formatted{formatter}, w: bufio.NewWriter(w)}
+}
+
+// NewStdout creates a sink writing to the standard output
+func NewStdout(formatter format.Formatter) *Stream {
+	return NewStream(os.Stdout, formatter)
+}
+
+// Write writes the formatted message
+func (s *Stream) Write(message parser.Message) error {
+	data, err := s.format(message)
+	if err != nil {
+		return err
+	}
+	_, err = s.w.Write(data)
+	return err
+}
+
+// Commit writes the output held back for the transaction and flushes
+func (s *Stream) Commit(xid uint64) error {
+	data, err := s.commit(xid)
+	if err != nil {
+		return err
+	}
+	if _, err := s.w.Write(data); err != nil {
+		return err
+	}
+	return s.Flush()
+}
+
+// Flush writes the buffered output
+func (s *Stream) Flush() error {
+	return s.w.Flush()
+}
+
+// Close flushes the output and closes the formatter
+func (s *Stream) Close() error {
+	err := s.Flush()
+	if closeErr := s.close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,32 @@
+package sink
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/tanema/binlog-parser/src/format"
+	"github.com/tanema/binlog-parser/src/parser"
+)
+
+func TestStream(t *testing.T) {
+	header := parser.NewMessageHeader("test_db", "employees", time.Unix(1492070524, 0), 635, 8)
+	data := parser.MessageRowData{Row: parser.MessageRow{"emp_no": int32(1)}}
+
+	var out bytes.Buffer
+	stream := NewStream(&out, format.NewSQL())
+	if err := stream.Write(parser.NewInsertMessage(header, data)); err != nil {
+		t.Fatal(err)
+	}
+	if out.Len() != 0 {
+		t.Fatal(fmt.Sprintf("Expected output to be buffered until the commit, got %q", out.String()))
+	}
+	if err := stream.Commit(8); err != nil {
+		t.Fatal(err)
+	}
+	expected := "BEGIN; /* xid 8 */\nINSERT INTO `test_db`.`employees` (`emp_no`) VALUES (1);\nCOMMIT;\n"
+	if out.String() != expected {
+		t.Fatal(fmt.Sprintf("Expected %q, got %q", expected, out.String()))
+	}
+}
@@ -0,0 +1,103 @@
+package sink
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/tanema/binlog-parser/src/format"
+	"github.com/tanema/binlog-parser/src/parser"
+)
+
+// TableDirectory writes the formatted messages of each table to its own
+// rotating file in <dir>/<schema>/<table>/, where dir and the file name
+// pattern are the directory and base name of the pattern. With a transaction
+// formatter the rows of a transaction are formatted when it ends, table by
+// table, so each file gets a transaction of its own rows.
+type TableDirectory struct {
+	Pattern  string
+	MaxSize  int64
+	Compress bool
+	formatted
+	files       map[string]*RotatingFile
+	transaction tableTransaction
+}
+
+// NewTableDirectory creates a sink writing a file per table
+func NewTableDirectory(pattern string, formatter format.Formatter) *TableDirectory {
+	return &TableDirectory{Pattern: pattern, formatted: formatted{formatter}, files: map[string]*RotatingFile{}}
+}
+
+// Write writes the formatted message to the file of its table, or holds it
+// back until the transaction ends
+func (d *TableDirectory) Write(message parser.Message) error {
+	if d.holds(message) {
+		d.transaction.add(message)
+		return nil
+	}
+	data, err := d.format(message)
+	if err != nil {
+		return err
+	}
+	return d.file(message.GetHeader()).write(data, messageTime(message))
+}
+
+// Commit writes the messages held back for the transaction to the files of
+// their tables and flushes all files
+func (d *TableDirectory) Commit(xid uint64) error {
+	output, err := d.render(&d.transaction, xid)
+	if err != nil {
+		return err
+	}
+	for _, out := range output {
+		last := out.messages[len(out.messages)-1]
+		if err := d.file(last.GetHeader()).write(d.frame(out.data), messageTime(last)); err != nil {
+			return err
+		}
+	}
+	return d.Flush()
+}
+
+// file returns the file of the table, it's created on the first write
+func (d *TableDirectory) file(header parser.MessageHeader) *RotatingFile {
+	name := header.Schema + "/" + header.Table
+	file, ok := d.files[name]
+	if !ok {
+		pattern := filepath.Join(filepath.Dir(d.Pattern), pathElement(header.Schema), pathElement(header.Table), filepath.Base(d.Pattern))
+		file = &RotatingFile{Pattern: pattern, MaxSize: d.MaxSize, Compress: d.Compress}
+		d.files[name] = file
+	}
+	return file
+}
+
+// Flush writes the buffered output of all files and rotates them if needed
+func (d *TableDirectory) Flush() error {
+	for _, file := range d.files {
+		if err := file.Flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes all files and the formatter
+func (d *TableDirectory) Close() error {
+	var err error
+	for _, file := range d.files {
+		if closeErr := file.closeFile(); err == nil {
+			err = closeErr
+		}
+	}
+	if closeErr := d.close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// pathElement keeps schema and table names from leaving their directory
+func pathElement(name string) string {
+	name = strings.Replace(name, string(filepath.Separator), "_", -1)
+	if name == "" || name == "." || name == ".." {
+		return "_" + name
+	}
+	return name
+}
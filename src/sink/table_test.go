@@ -0,0 +1,72 @@
+package sink
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/tanema/binlog-parser/src/format"
+	"github.com/tanema/binlog-parser/src/parser"
+)
+
+func TestTableDirectory(t *testing.T) {
+	data := parser.MessageRowData{Row: parser.MessageRow{"id": int32(1)}}
+	messages := []parser.Message{
+		parser.NewInsertMessage(parser.NewMessageHeader("test_db", "employees", time.Unix(1492070524, 0), 635, 8), data),
+		parser.NewDeleteMessage(parser.NewMessageHeader("test_db", "../titles", time.Unix(1492070524, 0), 700, 8), data),
+		parser.NewUpdateMessage(parser.NewMessageHeader("test_db", "employees", time.Unix(1492070524, 0), 765, 8), data, data),
+	}
+
+	testCases := []struct {
+		name      string
+		formatter format.Formatter
+		expected  map[string]string
+	}{
+		// every table file gets a transaction block, so it replays on its own
+		{
+			"sql",
+			format.NewSQL(),
+			map[string]string{
+				"test_db/employees/20170413.out": "BEGIN; /* xid 8 */\nINSERT INTO `test_db`.`employees` (`id`) VALUES (1);\nUPDATE `test_db`.`employees` SET `id`=1 WHERE `id`=1 LIMIT 1;\nCOMMIT;\n",
+				"test_db/.._titles/20170413.out": "BEGIN; /* xid 8 */\nDELETE FROM `test_db`.`../titles` WHERE `id`=1 LIMIT 1;\nCOMMIT;\n",
+			},
+		},
+		// only the last row of the transaction commits
+		{
+			"maxwell",
+			format.NewMaxwell(),
+			map[string]string{
+				"test_db/employees/20170413.out": `{"database":"test_db","table":"employees","type":"insert","ts":1492070524,"xid":8,"data":{"id":1}}` + "\n" +
+					`{"database":"test_db","table":"employees","type":"update","ts":1492070524,"xid":8,"data":{"id":1},"old":{}}` + "\n",
+				"test_db/.._titles/20170413.out": `{"database":"test_db","table":"../titles","type":"delete","ts":1492070524,"xid":8,"commit":true,"data":{"id":1}}` + "\n",
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir, _ := ioutil.TempDir("", "sink")
+			defer os.RemoveAll(dir)
+
+			tables := NewTableDirectory(filepath.Join(dir, "%Y%m%d.out"), tc.formatter)
+			for _, message := range messages {
+				if err := tables.Write(message); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := tables.Commit(8); err != nil {
+				t.Fatal(err)
+			}
+			if err := tables.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			if actual := readFiles(t, dir); !reflect.DeepEqual(actual, tc.expected) {
+				t.Fatal(fmt.Sprintf("Expected files %v, got %v", tc.expected, actual))
+			}
+		})
+	}
+}
@@ -0,0 +1,274 @@
+package sink
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/tanema/binlog-parser/src/format"
+	"github.com/tanema/binlog-parser/src/parser"
+)
+
+const (
+	// WebhookSignatureHeader is the header of the HMAC-SHA256 signature of the
+	// request body, sha256=<hex>
+	WebhookSignatureHeader = "X-Signature-256"
+	// webhookMaxBackoff limits the wait between retries
+	webhookMaxBackoff = 30 * time.Second
+)
+
+// Webhook POSTs batches of formatted messages to a URL, as JSON array or as
+// NDJSON. A batch is sent at the end of every transaction, or once it holds
+// BatchSize messages if set, and by a timer once its first message is older
+// than BatchTime if set. An error of a batch sent by the timer is returned by
+// the next call. Requests failing with a 5xx status, a timeout or another
+// network error are retried with exponential backoff. A batch that still
+// fails is appended to the DeadLetter file, or stops the parsing if there is
+// none.
+type Webhook struct {
+	URL        string
+	Header     http.Header
+	Secret     []byte
+	NDJSON     bool
+	BatchSize  int
+	BatchTime  time.Duration
+	Timeout    time.Duration
+	Retries    int
+	Backoff    time.Duration
+	DeadLetter string
+	formatted
+	mu       sync.Mutex
+	batch    [][]byte
+	batches  int
+	timer    *time.Timer
+	timerErr error
+}
+
+// NewWebhook creates a sink posting to the URL, retrying 5 times within a
+// timeout of 10 seconds per request
+func NewWebhook(url string, formatter format.Formatter) *Webhook {
+	return &Webhook{
+		URL:       url,
+		Header:    http.Header{},
+		Timeout:   10 * time.Second,
+		Retries:   5,
+		Backoff:   100 * time.Millisecond,
+		formatted: formatted{formatter},
+	}
+}
+
+// Write adds the formatted message to the batch and sends it once it's full
+func (w *Webhook) Write(message parser.Message) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.takeTimerErr(); err != nil {
+		return err
+	}
+	data, err := w.formatter.Format(message)
+	if err != nil || data == nil {
+		return err
+	}
+	w.add(data)
+	if w.BatchSize > 0 && len(w.batch) >= w.BatchSize {
+		return w.flush()
+	}
+	return nil
+}
+
+// Commit adds the output held back for the transaction and sends the batch
+// unless it's sent by size
+func (w *Webhook) Commit(xid uint64) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.takeTimerErr(); err != nil {
+		return err
+	}
+	if transactionFormatter, ok := w.formatter.(format.TransactionFormatter); ok {
+		data, err := transactionFormatter.Commit(xid)
+		if err != nil {
+			return err
+		}
+		if data != nil {
+			w.add(data)
+		}
+	}
+	if w.BatchSize == 0 {
+		return w.flush()
+	}
+	return nil
+}
+
+// Flush sends the batch
+func (w *Webhook) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.takeTimerErr(); err != nil {
+		return err
+	}
+	return w.flush()
+}
+
+// Close sends the batch and closes the formatter
+func (w *Webhook) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	err := w.takeTimerErr()
+	if flushErr := w.flush(); err == nil {
+		err = flushErr
+	}
+	if closeErr := w.close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func (w *Webhook) flush() error {
+	if w.timer != nil {
+		w.timer.Stop()
+		w.timer = nil
+	}
+	if len(w.batch) == 0 {
+		return nil
+	}
+	body := w.body()
+	w.batch = nil
+	if err := w.send(body); err != nil {
+		if w.DeadLetter == "" {
+			return err
+		}
+		return w.deadLetter(body)
+	}
+	return nil
+}
+
+// add appends to the batch, the timer is started with the first message
+func (w *Webhook) add(data []byte) {
+	if len(w.batch) == 0 && w.BatchTime > 0 {
+		w.batches++
+		batch := w.batches
+		w.timer = time.AfterFunc(w.BatchTime, func() { w.expire(batch) })
+	}
+	w.batch = append(w.batch, data)
+}
+
+// expire sends the batch the timer was started for, unless it was sent
+// already
+func (w *Webhook) expire(batch int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.timer == nil || w.batches != batch {
+		return
+	}
+	if err := w.flush(); err != nil && w.timerErr == nil {
+		w.timerErr = err
+	}
+}
+
+func (w *Webhook) takeTimerErr() error {
+	err := w.timerErr
+	w.timerErr = nil
+	return err
+}
+
+// body renders the batch, messages that aren't JSON become JSON strings in
+// an array
+func (w *Webhook) body() []byte {
+	var body bytes.Buffer
+	if w.NDJSON {
+		for _, data := range w.batch {
+			body.Write(data)
+			body.WriteByte('\n')
+		}
+		return body.Bytes()
+	}
+	body.WriteByte('[')
+	for i, data := range w.batch {
+		if i > 0 {
+			body.WriteByte(',')
+		}
+		if !json.Valid(data) {
+			data, _ = json.Marshal(string(data))
+		}
+		body.Write(data)
+	}
+	body.WriteByte(']')
+	return body.Bytes()
+}
+
+// send posts the body, retrying with exponential backoff
+func (w *Webhook) send(body []byte) error {
+	backoff := w.Backoff
+	for attempt := 0; ; attempt++ {
+		retry, err := w.post(body)
+		if err == nil || !retry || attempt >= w.Retries {
+			return err
+		}
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > webhookMaxBackoff {
+			backoff = webhookMaxBackoff
+		}
+	}
+}
+
+// post makes a single request and tells if a failure is worth a retry
+func (w *Webhook) post(body []byte) (bool, error) {
+	request, err := http.NewRequest(http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	for name, values := range w.Header {
+		request.Header[name] = values
+	}
+	if w.NDJSON {
+		request.Header.Set("Content-Type", "application/x-ndjson")
+	} else {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	if w.Secret != nil {
+		mac := hmac.New(sha256.New, w.Secret)
+		mac.Write(body)
+		request.Header.Set(WebhookSignatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	client := http.Client{Timeout: w.Timeout}
+	response, err := client.Do(request)
+	if err != nil {
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			return true, fmt.Errorf("webhook %s timed out: %s", w.URL, err)
+		}
+		return true, err
+	}
+	io.Copy(ioutil.Discard, response.Body)
+	response.Body.Close()
+	if response.StatusCode >= 300 {
+		return response.StatusCode >= 500, fmt.Errorf("webhook %s returned %s", w.URL, response.Status)
+	}
+	return false, nil
+}
+
+// deadLetter appends the body of a batch that couldn't be sent to the dead
+// letter file, one line per array batch
+func (w *Webhook) deadLetter(body []byte) error {
+	file, err := os.OpenFile(w.DeadLetter, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if !w.NDJSON {
+		body = append(body, '\n')
+	}
+	if _, err := file.Write(body); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
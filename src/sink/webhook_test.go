@@ -0,0 +1,197 @@
+package sink
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tanema/binlog-parser/src/format"
+	"github.com/tanema/binlog-parser/src/parser"
+)
+
+// webhookServer records the request bodies and answers with the statuses in
+// order, the last one repeated
+type webhookServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	statuses []int
+	delay    time.Duration
+	bodies   []string
+	requests []*http.Request
+}
+
+func newWebhookServer(statuses ...int) *webhookServer {
+	s := &webhookServer{statuses: statuses}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		s.mu.Lock()
+		s.bodies = append(s.bodies, string(body))
+		s.requests = append(s.requests, r)
+		n := len(s.bodies)
+		s.mu.Unlock()
+		if s.delay > 0 && n == 1 {
+			time.Sleep(s.delay)
+		}
+		status := s.statuses[len(s.statuses)-1]
+		if n <= len(s.statuses) {
+			status = s.statuses[n-1]
+		}
+		w.WriteHeader(status)
+	}))
+	return s
+}
+
+func webhookMessage(empNo int) parser.Message {
+	header := parser.NewMessageHeader("test_db", "employees", time.Unix(1492070524, 0), 635, 8)
+	return parser.NewInsertMessage(header, parser.MessageRowData{Row: parser.MessageRow{"emp_no": empNo}})
+}
+
+func TestWebhookTransaction(t *testing.T) {
+	server := newWebhookServer(http.StatusOK)
+	defer server.Close()
+	webhook := NewWebhook(server.URL, format.NewSQL())
+	webhook.Header.Set("Authorization", "Bearer token")
+	webhook.Secret = []byte("secret")
+
+	webhook.Write(webhookMessage(1))
+	webhook.Write(webhookMessage(2))
+	if len(server.bodies) != 0 {
+		t.Fatal("Expected the batch to be held back until the commit")
+	}
+	if err := webhook.Commit(8); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{`["BEGIN; /* xid 8 */\nINSERT INTO ` + "`test_db`.`employees` (`emp_no`) VALUES (1);" + `","INSERT INTO ` + "`test_db`.`employees` (`emp_no`) VALUES (2);" + `","COMMIT;"]`}
+	if !reflect.DeepEqual(server.bodies, expected) {
+		t.Fatal(fmt.Sprintf("Expected bodies %q, got %q", expected, server.bodies))
+	}
+	request := server.requests[0]
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write([]byte(expected[0]))
+	if signature := request.Header.Get(WebhookSignatureHeader); signature != "sha256="+hex.EncodeToString(mac.Sum(nil)) {
+		t.Fatal(fmt.Sprintf("Wrong signature %s", signature))
+	}
+	if request.Header.Get("Authorization") != "Bearer token" || request.Header.Get("Content-Type") != "application/json" {
+		t.Fatal(fmt.Sprintf("Wrong headers %v", request.Header))
+	}
+}
+
+func TestWebhookBatchSize(t *testing.T) {
+	server := newWebhookServer(http.StatusOK)
+	defer server.Close()
+	webhook := NewWebhook(server.URL, format.JSON{})
+	webhook.NDJSON = true
+	webhook.BatchSize = 2
+
+	for i := 1; i <= 3; i++ {
+		if err := webhook.Write(webhookMessage(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	webhook.Commit(8)
+	if len(server.bodies) != 1 {
+		t.Fatal(fmt.Sprintf("Expected a single full batch, got %d", len(server.bodies)))
+	}
+	if err := webhook.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(server.bodies) != 2 {
+		t.Fatal(fmt.Sprintf("Expected the rest to be sent on close, got %d batches", len(server.bodies)))
+	}
+	messages, _ := format.JSON{}.Format(webhookMessage(3))
+	if expected := string(messages) + "\n"; server.bodies[1] != expected {
+		t.Fatal(fmt.Sprintf("Expected %q, got %q", expected, server.bodies[1]))
+	}
+	if contentType := server.requests[0].Header.Get("Content-Type"); contentType != "application/x-ndjson" {
+		t.Fatal(fmt.Sprintf("Wrong content type %s", contentType))
+	}
+}
+
+func TestWebhookBatchTime(t *testing.T) {
+	server := newWebhookServer(http.StatusOK, http.StatusBadRequest)
+	defer server.Close()
+	webhook := NewWebhook(server.URL, format.JSON{})
+	webhook.BatchSize = 10
+	webhook.BatchTime = 20 * time.Millisecond
+	webhook.Retries = 0
+
+	if err := webhook.Write(webhookMessage(1)); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if err := webhook.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if len(server.bodies) != 1 {
+		t.Fatal(fmt.Sprintf("Expected the batch to be sent by the timer, got %d batches", len(server.bodies)))
+	}
+
+	webhook.Write(webhookMessage(2))
+	time.Sleep(200 * time.Millisecond)
+	if err := webhook.Write(webhookMessage(3)); err == nil {
+		t.Fatal("Expected the error of the batch sent by the timer")
+	}
+	webhook.Close()
+}
+
+func TestWebhookRetries(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "webhook")
+	defer os.RemoveAll(dir)
+
+	testCases := []struct {
+		statuses   []int
+		delay      time.Duration
+		requests   int
+		deadLetter bool
+	}{
+		{[]int{http.StatusServiceUnavailable, http.StatusBadGateway, http.StatusOK}, 0, 3, false},
+		{[]int{http.StatusOK}, 200 * time.Millisecond, 2, false},
+		{[]int{http.StatusInternalServerError}, 0, 3, true},
+		{[]int{http.StatusBadRequest}, 0, 1, true},
+	}
+	for i, tc := range testCases {
+		server := newWebhookServer(tc.statuses...)
+		server.delay = tc.delay
+		webhook := NewWebhook(server.URL, format.JSON{})
+		webhook.Retries = 2
+		webhook.Backoff = time.Millisecond
+		webhook.Timeout = 100 * time.Millisecond
+		webhook.DeadLetter = filepath.Join(dir, fmt.Sprintf("dead-%d.json", i))
+
+		webhook.Write(webhookMessage(1))
+		if err := webhook.Commit(8); err != nil {
+			t.Fatal(err)
+		}
+		server.Close()
+		if len(server.bodies) != tc.requests {
+			t.Fatal(fmt.Sprintf("Expected %d requests for %v, got %d", tc.requests, tc.statuses, len(server.bodies)))
+		}
+		deadLetter, _ := ioutil.ReadFile(webhook.DeadLetter)
+		if tc.deadLetter != (string(deadLetter) == server.bodies[0]+"\n") {
+			t.Fatal(fmt.Sprintf("Wrong dead letter file for %v: %q", tc.statuses, deadLetter))
+		}
+	}
+}
+
+func TestWebhookError(t *testing.T) {
+	server := newWebhookServer(http.StatusInternalServerError)
+	defer server.Close()
+	webhook := NewWebhook(server.URL, format.JSON{})
+	webhook.Retries = 0
+
+	webhook.Write(webhookMessage(1))
+	if err := webhook.Commit(8); err == nil {
+		t.Fatal("Expected error without dead letter file")
+	}
+}
@@ -0,0 +1,240 @@
+package stats
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/tanema/binlog-parser/src/parser"
+)
+
+const (
+	// DiffByGTID aligns transactions by GTID and those without by content
+	DiffByGTID = "gtid"
+	// DiffByXID aligns transactions by XID, for copies of the same binlogs
+	DiffByXID = "xid"
+	// DiffByHash aligns transactions by content, so changed transactions show
+	// up as missing and extra
+	DiffByHash = "hash"
+)
+
+// TransactionSet collects the row changes of the transactions of a binlog
+// set for a diff. It's a sink, the rows are kept as text in memory.
+type TransactionSet struct {
+	Transactions []DiffTransaction
+	current      DiffTransaction
+}
+
+// DiffTransaction is a transaction with its row changes as text and the
+// hash of them
+type DiffTransaction struct {
+	GTID          string `json:",omitempty"`
+	XID           uint64
+	BinlogFile    string
+	StartPosition uint32
+	Hash          string
+	Rows          []string
+}
+
+// DiffReport lists the transactions of the left binlogs that are missing from
+// the right ones, the transactions only in the right binlogs and the aligned
+// transactions whose rows differ
+type DiffReport struct {
+	By        string
+	Matched   int
+	Missing   []DiffTransaction
+	Extra     []DiffTransaction
+	Divergent []DivergentTransaction
+}
+
+// DivergentTransaction is a pair of aligned transactions with the rows only
+// in the left one as Missing and only in the right one as Extra
+type DivergentTransaction struct {
+	Left    DiffTransaction
+	Right   DiffTransaction
+	Missing []string
+	Extra   []string
+}
+
+// NewTransactionSet creates an empty transaction set
+func NewTransactionSet() *TransactionSet {
+	return &TransactionSet{}
+}
+
+// Transaction starts a transaction, the parser calls it before writing its
+// rows
+func (s *TransactionSet) Transaction(transaction parser.Transaction) error {
+	s.current = DiffTransaction{GTID: transaction.GTID, XID: transaction.XID, BinlogFile: transaction.BinlogFile, StartPosition: transaction.StartPosition}
+	return nil
+}
+
+// Write adds the row change to the transaction, query messages are not
+// compared
+func (s *TransactionSet) Write(message parser.Message) error {
+	header := message.GetHeader()
+	var row []interface{}
+	switch m := message.(type) {
+	case parser.InsertMessage:
+		row = []interface{}{m.Data.Row}
+	case parser.UpdateMessage:
+		row = []interface{}{m.OldData.Row, m.NewData.Row}
+	case parser.DeleteMessage:
+		row = []interface{}{m.Data.Row}
+	default:
+		return nil
+	}
+	data, err := json.Marshal(row)
+	if err != nil {
+		return err
+	}
+	s.current.Rows = append(s.current.Rows, fmt.Sprintf("%s %s.%s %s", message.GetType(), header.Schema, header.Table, data))
+	return nil
+}
+
+// Commit ends the transaction
+func (s *TransactionSet) Commit(xid uint64) error {
+	s.current.XID = xid
+	hash := sha256.Sum256([]byte(strings.Join(s.current.Rows, "\n")))
+	s.current.Hash = hex.EncodeToString(hash[:8])
+	s.Transactions = append(s.Transactions, s.current)
+	s.current = DiffTransaction{}
+	return nil
+}
+
+// Flush does nothing, the transactions are kept in memory
+func (s *TransactionSet) Flush() error {
+	return nil
+}
+
+// Close does nothing, the transactions are kept in memory
+func (s *TransactionSet) Close() error {
+	return nil
+}
+
+// key aligns the transaction with the other set
+func (t DiffTransaction) key(by string) string {
+	switch {
+	case by == DiffByXID:
+		return fmt.Sprintf("xid:%d", t.XID)
+	case by == DiffByGTID && t.GTID != "":
+		return "gtid:" + t.GTID
+	}
+	return "hash:" + t.Hash
+}
+
+// Compare aligns the transactions of the sets by GTID, XID or content hash
+// and reports the differences, the left set is the reference
+func Compare(left, right *TransactionSet, by string) (DiffReport, error) {
+	report := DiffReport{By: by, Missing: []DiffTransaction{}, Extra: []DiffTransaction{}, Divergent: []DivergentTransaction{}}
+	switch by {
+	case DiffByGTID, DiffByXID, DiffByHash:
+	default:
+		return report, fmt.Errorf("unknown diff alignment %q", by)
+	}
+	unmatched := map[string][]int{}
+	for i, transaction := range right.Transactions {
+		key := transaction.key(by)
+		unmatched[key] = append(unmatched[key], i)
+	}
+	matched := make([]bool, len(right.Transactions))
+	for _, transaction := range left.Transactions {
+		key := transaction.key(by)
+		candidates := unmatched[key]
+		if len(candidates) == 0 {
+			report.Missing = append(report.Missing, transaction)
+			continue
+		}
+		other := right.Transactions[candidates[0]]
+		matched[candidates[0]] = true
+		unmatched[key] = candidates[1:]
+		report.Matched++
+		if other.Hash != transaction.Hash {
+			missing, extra := diffRowSets(transaction.Rows, other.Rows)
+			report.Divergent = append(report.Divergent, DivergentTransaction{Left: transaction, Right: other, Missing: missing, Extra: extra})
+		}
+	}
+	for i, transaction := range right.Transactions {
+		if !matched[i] {
+			report.Extra = append(report.Extra, transaction)
+		}
+	}
+	return report, nil
+}
+
+// Differs tells if the binlog sets have any differences
+func (r DiffReport) Differs() bool {
+	return len(r.Missing) > 0 || len(r.Extra) > 0 || len(r.Divergent) > 0
+}
+
+// WriteTable prints the report as text tables followed by the row
+// differences of the divergent transactions
+func (r DiffReport) WriteTable(out io.Writer) error {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "Aligned by\t%s\n", r.By)
+	fmt.Fprintf(w, "Matched\t%d\n", r.Matched)
+	fmt.Fprintf(w, "Missing\t%d\n", len(r.Missing))
+	fmt.Fprintf(w, "Extra\t%d\n", len(r.Extra))
+	fmt.Fprintf(w, "Divergent\t%d\n", len(r.Divergent))
+	for _, list := range []struct {
+		title        string
+		transactions []DiffTransaction
+	}{{"Missing", r.Missing}, {"Extra", r.Extra}} {
+		if len(list.transactions) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "\n%s transactions\tXID\tPosition\tRows\tHash\n", list.title)
+		for _, transaction := range list.transactions {
+			fmt.Fprintf(w, "%s\t%d\t%s:%d\t%d\t%s\n", transaction.name(), transaction.XID, transaction.BinlogFile, transaction.StartPosition, len(transaction.Rows), transaction.Hash)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	for _, divergent := range r.Divergent {
+		fmt.Fprintf(out, "\nDivergent transaction %s: %s:%d XID %d, %s:%d XID %d\n", divergent.Left.name(), divergent.Left.BinlogFile, divergent.Left.StartPosition, divergent.Left.XID, divergent.Right.BinlogFile, divergent.Right.StartPosition, divergent.Right.XID)
+		for _, row := range divergent.Missing {
+			fmt.Fprintf(out, "- %s\n", row)
+		}
+		for _, row := range divergent.Extra {
+			fmt.Fprintf(out, "+ %s\n", row)
+		}
+	}
+	return nil
+}
+
+// name is the GTID of the transaction, or its hash without GTID
+func (t DiffTransaction) name() string {
+	if t.GTID != "" {
+		return t.GTID
+	}
+	return t.Hash
+}
+
+// diffRowSets returns the rows only in left and only in right, in their
+// order, rows that appear several times are matched by count
+func diffRowSets(left, right []string) ([]string, []string) {
+	counts := map[string]int{}
+	for _, row := range right {
+		counts[row]++
+	}
+	var missing []string
+	for _, row := range left {
+		if counts[row] > 0 {
+			counts[row]--
+		} else {
+			missing = append(missing, row)
+		}
+	}
+	var extra []string
+	for _, row := range right {
+		if counts[row] > 0 {
+			counts[row]--
+			extra = append(extra, row)
+		}
+	}
+	return missing, extra
+}
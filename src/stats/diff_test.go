@@ -0,0 +1,81 @@
+package stats
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tanema/binlog-parser/src/parser"
+)
+
+func diffSet(t *testing.T, transactions ...[]interface{}) *TransactionSet {
+	set := NewTransactionSet()
+	header := parser.NewMessageHeader("test_db", "rooms", time.Unix(1492070524, 0), 635, 8)
+	for i, transaction := range transactions {
+		set.Transaction(parser.Transaction{GTID: transaction[0].(string), BinlogFile: "mysql-bin.01", StartPosition: uint32(100 * (i + 1))})
+		for _, name := range transaction[1:] {
+			row := parser.MessageRowData{Row: parser.MessageRow{"id": i, "name": name}}
+			if err := set.Write(parser.NewInsertMessage(header, row)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		set.Write(parser.NewQueryMessage(header, "CREATE TABLE x (id int)"))
+		set.Commit(uint64(10 + i))
+	}
+	return set
+}
+
+func TestCompare(t *testing.T) {
+	left := diffSet(t,
+		[]interface{}{"uuid:1", "a"},
+		[]interface{}{"uuid:2", "b", "c"},
+		[]interface{}{"uuid:3", "d"},
+		[]interface{}{"", "e"},
+	)
+	right := diffSet(t,
+		[]interface{}{"uuid:1", "a"},
+		[]interface{}{"uuid:2", "b", "x"},
+		[]interface{}{"uuid:4", "f"},
+	)
+
+	report, err := Compare(left, right, DiffByGTID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Matched != 2 || len(report.Missing) != 2 || len(report.Extra) != 1 || len(report.Divergent) != 1 || !report.Differs() {
+		t.Fatal(fmt.Sprintf("Wrong report %+v", report))
+	}
+	if report.Missing[0].GTID != "uuid:3" || report.Missing[1].GTID != "" || report.Extra[0].GTID != "uuid:4" {
+		t.Fatal(fmt.Sprintf("Wrong missing or extra transactions %+v %+v", report.Missing, report.Extra))
+	}
+	divergent := report.Divergent[0]
+	if !reflect.DeepEqual(divergent.Missing, []string{`Insert test_db.rooms [{"id":1,"name":"c"}]`}) || !reflect.DeepEqual(divergent.Extra, []string{`Insert test_db.rooms [{"id":1,"name":"x"}]`}) {
+		t.Fatal(fmt.Sprintf("Wrong row differences %+v", divergent))
+	}
+
+	var out bytes.Buffer
+	report.WriteTable(&out)
+	if !strings.Contains(out.String(), "Divergent transaction uuid:2") || !strings.Contains(out.String(), `+ Insert test_db.rooms [{"id":1,"name":"x"}]`) {
+		t.Fatal(fmt.Sprintf("Expected row differences in output, got %s", out.String()))
+	}
+
+	if report, _ := Compare(left, right, DiffByHash); report.Matched != 1 || len(report.Divergent) != 0 || len(report.Missing) != 3 {
+		t.Fatal(fmt.Sprintf("Wrong report aligned by hash %+v", report))
+	}
+	if report, _ := Compare(left, left, DiffByXID); report.Matched != 4 || report.Differs() {
+		t.Fatal(fmt.Sprintf("Expected no differences, got %+v", report))
+	}
+	if _, err := Compare(left, right, "position"); err == nil {
+		t.Fatal("Expected error for unknown alignment")
+	}
+}
+
+func TestDiffRowSets(t *testing.T) {
+	missing, extra := diffRowSets([]string{"a", "b", "b", "c"}, []string{"b", "c", "d", "c"})
+	if !reflect.DeepEqual(missing, []string{"a", "b"}) || !reflect.DeepEqual(extra, []string{"c", "d"}) {
+		t.Fatal(fmt.Sprintf("Wrong row differences %v %v", missing, extra))
+	}
+}
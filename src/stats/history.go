@@ -0,0 +1,206 @@
+package stats
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/tanema/binlog-parser/src/database"
+	"github.com/tanema/binlog-parser/src/format"
+	"github.com/tanema/binlog-parser/src/parser"
+)
+
+// History collects the changes to a single row, identified by its table and
+// primary key values, or its first column if the key is unknown. Table is
+// schema.table, or a table name matching every schema. An update that
+// changes the key is followed, so the later changes to the row are found
+// under its new key.
+type History struct {
+	Table   string
+	Key     []string
+	Changes []Change
+}
+
+// Change is an insert, update or delete of the row. Row is the inserted or
+// deleted row or the row after the update, Diff the columns changed by the
+// update.
+type Change struct {
+	Type       parser.MessageType
+	Time       time.Time
+	BinlogFile string
+	Position   uint32
+	GTID       string `json:",omitempty"`
+	XID        uint64
+	Query      parser.SQLQuery `json:",omitempty"`
+	Row        parser.MessageRow
+	Diff       []ColumnChange `json:",omitempty"`
+}
+
+// ColumnChange is a column with its value before and after an update
+type ColumnChange struct {
+	Column string
+	Old    interface{}
+	New    interface{}
+}
+
+// NewHistory creates the history of the row of the table with the key
+// values, in the order of the primary key columns
+func NewHistory(table string, key []string) *History {
+	return &History{Table: table, Key: key, Changes: []Change{}}
+}
+
+// Write adds the message to the history if it changes the row
+func (h *History) Write(message parser.Message) error {
+	header := message.GetHeader()
+	if header.Table != h.Table && header.Schema+"."+header.Table != h.Table {
+		return nil
+	}
+	switch m := message.(type) {
+	case parser.InsertMessage:
+		if h.matches(header, m.Data.Row) {
+			h.add(message, m.Data.Row, nil)
+		}
+	case parser.DeleteMessage:
+		if h.matches(header, m.Data.Row) {
+			h.add(message, m.Data.Row, nil)
+		}
+	case parser.UpdateMessage:
+		if h.matches(header, m.OldData.Row) {
+			h.add(message, m.NewData.Row, diffRows(header, m.OldData.Row, m.NewData.Row))
+			h.Key = keyStrings(header, m.NewData.Row)
+		}
+	}
+	return nil
+}
+
+// add appends the change of the message to the history
+func (h *History) add(message parser.Message, row parser.MessageRow, diff []ColumnChange) {
+	header := message.GetHeader()
+	change := Change{Type: message.GetType(), XID: header.XID, Query: header.RowsQuery, Row: row, Diff: diff}
+	change.Time, _ = time.Parse(time.RFC3339, header.BinlogMessageTime)
+	if header.Event != nil {
+		change.BinlogFile = header.Event.BinlogFile
+		change.Position = header.Event.StartPosition
+		change.GTID = header.Event.GTID
+	}
+	h.Changes = append(h.Changes, change)
+}
+
+// matches tells if the row has the key of the history
+func (h *History) matches(header parser.MessageHeader, row parser.MessageRow) bool {
+	key := keyStrings(header, row)
+	if len(key) != len(h.Key) {
+		return false
+	}
+	for i := range key {
+		if key[i] != h.Key[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// diffRows lists the columns with different values before and after an update,
+// in table order
+func diffRows(header parser.MessageHeader, before, after parser.MessageRow) []ColumnChange {
+	var names []string
+	for _, column := range header.Columns {
+		names = append(names, column.Name)
+	}
+	if len(names) == 0 {
+		for name := range after {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+	var diff []ColumnChange
+	for _, name := range names {
+		if valueString(before[name]) != valueString(after[name]) {
+			diff = append(diff, ColumnChange{Column: name, Old: before[name], New: after[name]})
+		}
+	}
+	return diff
+}
+
+// Flush does nothing, the history is kept in memory
+func (h *History) Flush() error {
+	return nil
+}
+
+// Close does nothing, the history is kept in memory
+func (h *History) Close() error {
+	return nil
+}
+
+// WriteTable prints the changes as a timeline
+func (h *History) WriteTable(out io.Writer) error {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "Time\tPosition\tGTID\tXID\tChange\n")
+	for _, change := range h.Changes {
+		gtid := change.GTID
+		if gtid == "" {
+			gtid = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s:%d\t%s\t%d\t%s\n", change.Time.Format(time.RFC3339), change.BinlogFile, change.Position, gtid, change.XID, change.describe())
+		if change.Query != "" {
+			fmt.Fprintf(w, "\t\t\t\t  %s\n", strings.Join(strings.Fields(string(change.Query)), " "))
+		}
+	}
+	return w.Flush()
+}
+
+// describe prints the type of the change with the changed columns of an
+// update or the columns of an inserted or deleted row
+func (c Change) describe() string {
+	var columns []string
+	if c.Type == parser.MessageTypeUpdate {
+		for _, column := range c.Diff {
+			columns = append(columns, fmt.Sprintf("%s: %s -> %s", column.Column, valueString(column.Old), valueString(column.New)))
+		}
+	} else {
+		names := make([]string, 0, len(c.Row))
+		for name := range c.Row {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			columns = append(columns, fmt.Sprintf("%s=%s", name, valueString(c.Row[name])))
+		}
+	}
+	return strings.ToUpper(string(c.Type)) + " " + strings.Join(columns, ", ")
+}
+
+// keyStrings is the key of the row as strings, so it can be compared with the
+// command line
+func keyStrings(header parser.MessageHeader, row parser.MessageRow) []string {
+	var columns []database.Column
+	for _, column := range header.Columns {
+		if column.PrimaryKey {
+			columns = append(columns, column)
+		}
+	}
+	key := rowKey(header, row)
+	strs := make([]string, len(key))
+	for i, value := range key {
+		if i < len(columns) {
+			value = format.NormalizeValue(columns[i], value)
+		}
+		strs[i] = valueString(value)
+	}
+	return strs
+}
+
+func valueString(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "NULL"
+	case []byte:
+		return string(v)
+	case string:
+		return v
+	}
+	return fmt.Sprint(value)
+}
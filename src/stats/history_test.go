@@ -0,0 +1,89 @@
+package stats
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tanema/binlog-parser/src/database"
+	"github.com/tanema/binlog-parser/src/parser"
+)
+
+func TestHistory(t *testing.T) {
+	begin := time.Date(2017, 4, 13, 6, 34, 37, 0, time.UTC)
+	columns := []database.Column{{Name: "id", PrimaryKey: true, DataType: "int", ColumnType: "int(10) unsigned"}, {Name: "status"}}
+	header := func(table string, seconds int) parser.MessageHeader {
+		header := parser.NewMessageHeader("shop", table, begin.Add(time.Duration(seconds)*time.Second), 1, uint64(seconds))
+		header.Columns = columns
+		header.RowsQuery = "UPDATE orders SET status = 'paid'"
+		header.Event = &parser.EventMetadata{BinlogFile: "mysql-bin.000042", StartPosition: uint32(100 * seconds), GTID: "3e11fa47-71ca-11e1-9e33-c80aa9429562:23"}
+		return header
+	}
+	row := func(id int32, status string) parser.MessageRowData {
+		return parser.MessageRowData{Row: parser.MessageRow{"id": id, "status": status}}
+	}
+
+	history := NewHistory("shop.orders", []string{"123"})
+	for _, message := range []parser.Message{
+		parser.NewInsertMessage(header("orders", 1), row(123, "new")),
+		parser.NewInsertMessage(header("orders", 2), row(124, "new")),
+		parser.NewInsertMessage(header("customers", 3), row(123, "new")),
+		parser.NewUpdateMessage(header("orders", 4), row(123, "new"), row(123, "paid")),
+		parser.NewUpdateMessage(header("orders", 5), row(123, "paid"), row(125, "paid")),
+		parser.NewDeleteMessage(header("orders", 6), row(123, "paid")),
+		parser.NewDeleteMessage(header("orders", 7), row(125, "paid")),
+	} {
+		history.Write(message)
+	}
+
+	expected := []struct {
+		typ      parser.MessageType
+		position uint32
+		diff     string
+	}{
+		{parser.MessageTypeInsert, 100, ""},
+		{parser.MessageTypeUpdate, 400, "[{status new paid}]"},
+		{parser.MessageTypeUpdate, 500, "[{id 123 125}]"},
+		{parser.MessageTypeDelete, 700, ""},
+	}
+	if len(history.Changes) != len(expected) {
+		t.Fatal(fmt.Sprintf("Expected %d changes, got %+v", len(expected), history.Changes))
+	}
+	for i, e := range expected {
+		change := history.Changes[i]
+		diff := ""
+		if change.Diff != nil {
+			diff = fmt.Sprint(change.Diff)
+		}
+		if change.Type != e.typ || change.Position != e.position || diff != e.diff || change.GTID == "" || change.Query == "" {
+			t.Fatal(fmt.Sprintf("Expected %s at %d with diff %q, got %+v", e.typ, e.position, e.diff, change))
+		}
+	}
+
+	var out bytes.Buffer
+	history.WriteTable(&out)
+	if !strings.Contains(out.String(), "mysql-bin.000042:400") || !strings.Contains(out.String(), "UPDATE status: new -> paid") {
+		t.Fatal(fmt.Sprintf("Expected timeline, got %s", out.String()))
+	}
+}
+
+func TestKeyStrings(t *testing.T) {
+	testCases := []struct {
+		columns  []database.Column
+		row      parser.MessageRow
+		expected []string
+	}{
+		{[]database.Column{{Name: "id", PrimaryKey: true, DataType: "int", ColumnType: "int(10) unsigned"}}, parser.MessageRow{"id": int32(-1)}, []string{"4294967295"}},
+		{[]database.Column{{Name: "a", PrimaryKey: true}, {Name: "b", PrimaryKey: true}}, parser.MessageRow{"a": []byte("x"), "b": nil}, []string{"x", "NULL"}},
+		{[]database.Column{{Name: "code"}, {Name: "name"}}, parser.MessageRow{"code": "c1", "name": "n"}, []string{"c1"}},
+		{nil, parser.MessageRow{"(unknown_0)": int64(7)}, []string{"7"}},
+	}
+	for _, tc := range testCases {
+		header := parser.MessageHeader{Columns: tc.columns}
+		if actual := keyStrings(header, tc.row); fmt.Sprint(actual) != fmt.Sprint(tc.expected) {
+			t.Fatal(fmt.Sprintf("Expected %v for %v, got %v", tc.expected, tc.row, actual))
+		}
+	}
+}
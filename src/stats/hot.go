@@ -0,0 +1,217 @@
+package stats
+
+import (
+	"container/heap"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/tanema/binlog-parser/src/parser"
+)
+
+const (
+	// DefaultHotTop is the number of rows reported by HotRows
+	DefaultHotTop = 20
+	// DefaultHotCounters is the number of rows HotRows counts at a time
+	DefaultHotCounters = 10000
+)
+
+// HotRows finds the rows that are updated and deleted most often, identified
+// by table and primary key, or by the first column if the key is unknown. It
+// uses the Space-Saving algorithm to keep memory bounded: at most Counters
+// rows are counted, and a row without counter takes over the counter with the
+// lowest count. The count of a row that took over a counter is overestimated
+// by at most its Error, every row changed more often than the number of
+// changes divided by Counters is guaranteed to have a counter. The changes
+// per table are counted exactly.
+type HotRows struct {
+	Top      int
+	Counters int
+	rows     map[string]*HotRow
+	heap     hotHeap
+	tables   map[string]*HotTable
+}
+
+// HotRow is a row with the number of times it was changed, the time range
+// covers the changes since the row got its counter
+type HotRow struct {
+	Table string
+	Key   json.RawMessage
+	Count int
+	Error int
+	First time.Time
+	Last  time.Time
+	id    string
+	index int
+}
+
+// HotTable counts the changes to a table
+type HotTable struct {
+	Table   string
+	Updates int
+	Deletes int
+	First   time.Time
+	Last    time.Time
+}
+
+// HotReport lists the hottest rows and all changed tables, by number of
+// changes
+type HotReport struct {
+	Rows   []HotRow
+	Tables []HotTable
+}
+
+// NewHotRows creates an empty hot row counter
+func NewHotRows() *HotRows {
+	return &HotRows{Top: DefaultHotTop, Counters: DefaultHotCounters, rows: map[string]*HotRow{}, tables: map[string]*HotTable{}}
+}
+
+// Write counts the row of an update, the row before the change, or a delete
+func (h *HotRows) Write(message parser.Message) error {
+	var row parser.MessageRow
+	switch m := message.(type) {
+	case parser.UpdateMessage:
+		row = m.OldData.Row
+	case parser.DeleteMessage:
+		row = m.Data.Row
+	default:
+		return nil
+	}
+	header := message.GetHeader()
+	t, _ := time.Parse(time.RFC3339, header.BinlogMessageTime)
+	name := header.Schema + "." + header.Table
+
+	table, ok := h.tables[name]
+	if !ok {
+		table = &HotTable{Table: name, First: t}
+		h.tables[name] = table
+	}
+	if message.GetType() == parser.MessageTypeUpdate {
+		table.Updates++
+	} else {
+		table.Deletes++
+	}
+	table.Last = t
+
+	key, err := json.Marshal(rowKey(header, row))
+	if err != nil {
+		return err
+	}
+	h.count(name, key, t)
+	return nil
+}
+
+// count increments the counter of the row, taking over the lowest counter if
+// all are used
+func (h *HotRows) count(table string, key []byte, t time.Time) {
+	id := table + "\x00" + string(key)
+	if row, ok := h.rows[id]; ok {
+		row.Count++
+		row.Last = t
+		heap.Fix(&h.heap, row.index)
+		return
+	}
+	if len(h.heap) < h.Counters || h.Counters <= 0 {
+		row := &HotRow{Table: table, Key: key, Count: 1, First: t, Last: t, id: id}
+		h.rows[id] = row
+		heap.Push(&h.heap, row)
+		return
+	}
+	row := h.heap[0]
+	delete(h.rows, row.id)
+	*row = HotRow{Table: table, Key: key, Count: row.Count + 1, Error: row.Count, First: t, Last: t, id: id, index: row.index}
+	h.rows[id] = row
+	heap.Fix(&h.heap, 0)
+}
+
+// Flush does nothing, the counters are kept in memory
+func (h *HotRows) Flush() error {
+	return nil
+}
+
+// Close does nothing, the counters are kept in memory
+func (h *HotRows) Close() error {
+	return nil
+}
+
+// Report returns the Top hottest rows and all changed tables
+func (h *HotRows) Report() HotReport {
+	report := HotReport{Rows: make([]HotRow, 0, len(h.heap)), Tables: make([]HotTable, 0, len(h.tables))}
+	for _, row := range h.heap {
+		report.Rows = append(report.Rows, *row)
+	}
+	sort.Slice(report.Rows, func(i, j int) bool {
+		if report.Rows[i].Count != report.Rows[j].Count {
+			return report.Rows[i].Count > report.Rows[j].Count
+		}
+		return report.Rows[i].id < report.Rows[j].id
+	})
+	if h.Top > 0 && len(report.Rows) > h.Top {
+		report.Rows = report.Rows[:h.Top]
+	}
+	for _, table := range h.tables {
+		report.Tables = append(report.Tables, *table)
+	}
+	sort.Slice(report.Tables, func(i, j int) bool {
+		a, b := report.Tables[i], report.Tables[j]
+		if a.Updates+a.Deletes != b.Updates+b.Deletes {
+			return a.Updates+a.Deletes > b.Updates+b.Deletes
+		}
+		return a.Table < b.Table
+	})
+	return report
+}
+
+// WriteTable prints the report as text tables
+func (r HotReport) WriteTable(out io.Writer) error {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "Table\tKey\tChanges\tError\tFirst\tLast\n")
+	for _, row := range r.Rows {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%s\t%s\n", row.Table, row.Key, row.Count, row.Error, row.First.Format(time.RFC3339), row.Last.Format(time.RFC3339))
+	}
+	fmt.Fprintf(w, "\nTable\tUpdates\tDeletes\tFirst\tLast\n")
+	for _, table := range r.Tables {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%s\n", table.Table, table.Updates, table.Deletes, table.First.Format(time.RFC3339), table.Last.Format(time.RFC3339))
+	}
+	return w.Flush()
+}
+
+// rowKey is the primary key of the row, or its first column if the key is
+// unknown
+func rowKey(header parser.MessageHeader, row parser.MessageRow) []interface{} {
+	if key := header.PrimaryKey(row); len(key) > 0 {
+		return key
+	}
+	if len(header.Columns) > 0 {
+		return []interface{}{row[header.Columns[0].Name]}
+	}
+	// rows of tables without known columns are mapped as (unknown_N)
+	return []interface{}{row["(unknown_0)"]}
+}
+
+// hotHeap is a min-heap of the counters by count
+type hotHeap []*HotRow
+
+func (h hotHeap) Len() int           { return len(h) }
+func (h hotHeap) Less(i, j int) bool { return h[i].Count < h[j].Count }
+func (h hotHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *hotHeap) Push(x interface{}) {
+	row := x.(*HotRow)
+	row.index = len(*h)
+	*h = append(*h, row)
+}
+
+func (h *hotHeap) Pop() interface{} {
+	old := *h
+	row := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return row
+}
@@ -0,0 +1,90 @@
+package stats
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tanema/binlog-parser/src/database"
+	"github.com/tanema/binlog-parser/src/parser"
+)
+
+func TestHotRows(t *testing.T) {
+	begin := time.Date(2017, 4, 13, 6, 34, 37, 0, time.UTC)
+	columns := []database.Column{{Name: "name"}, {Name: "id", PrimaryKey: true}}
+	message := func(table string, id int, seconds int, update bool) parser.Message {
+		header := parser.NewMessageHeader("test_db", table, begin.Add(time.Duration(seconds)*time.Second), 1, 2)
+		header.Columns = columns
+		data := parser.MessageRowData{Row: parser.MessageRow{"id": id, "name": "x"}}
+		if update {
+			return parser.NewUpdateMessage(header, data, parser.MessageRowData{Row: parser.MessageRow{"id": id + 100, "name": "y"}})
+		}
+		return parser.NewDeleteMessage(header, data)
+	}
+
+	hot := NewHotRows()
+	hot.Top = 2
+	for i, m := range []parser.Message{
+		message("rooms", 1, 0, true),
+		message("rooms", 2, 1, true),
+		message("rooms", 1, 2, true),
+		message("rooms", 1, 3, false),
+		message("buildings", 1, 4, true),
+		message("buildings", 1, 5, false),
+		parser.NewInsertMessage(parser.NewMessageHeader("test_db", "rooms", begin, 1, 2), parser.MessageRowData{Row: parser.MessageRow{"id": 1}}),
+	} {
+		if err := hot.Write(m); err != nil {
+			t.Fatal(fmt.Sprintf("Expected to write message %d, got %s", i, err))
+		}
+	}
+
+	report := hot.Report()
+	if len(report.Rows) != 2 {
+		t.Fatal(fmt.Sprintf("Expected the top 2 rows, got %+v", report.Rows))
+	}
+	top := report.Rows[0]
+	if top.Table != "test_db.rooms" || string(top.Key) != "[1]" || top.Count != 3 || top.Error != 0 || !top.First.Equal(begin) || !top.Last.Equal(begin.Add(3*time.Second)) {
+		t.Fatal(fmt.Sprintf("Wrong hottest row %+v", top))
+	}
+	if second := report.Rows[1]; second.Table != "test_db.buildings" || second.Count != 2 {
+		t.Fatal(fmt.Sprintf("Wrong second hottest row %+v", second))
+	}
+	if len(report.Tables) != 2 || report.Tables[0] != (HotTable{Table: "test_db.rooms", Updates: 3, Deletes: 1, First: begin, Last: begin.Add(3 * time.Second)}) {
+		t.Fatal(fmt.Sprintf("Wrong tables %+v", report.Tables))
+	}
+
+	var out bytes.Buffer
+	report.WriteTable(&out)
+	if !strings.Contains(out.String(), "test_db.rooms      [1]  3") {
+		t.Fatal(fmt.Sprintf("Expected hottest row in table, got %s", out.String()))
+	}
+}
+
+func TestHotRowsBoundedCounters(t *testing.T) {
+	hot := NewHotRows()
+	hot.Counters = 2
+	at := time.Date(2017, 4, 13, 6, 34, 37, 0, time.UTC)
+	for i, key := range []string{"a", "a", "a", "b", "c", "a", "d"} {
+		hot.count("t", []byte(`["`+key+`"]`), at.Add(time.Duration(i)*time.Second))
+	}
+
+	if len(hot.rows) != 2 || len(hot.heap) != 2 {
+		t.Fatal(fmt.Sprintf("Expected 2 counters, got %d rows and %d in heap", len(hot.rows), len(hot.heap)))
+	}
+	report := hot.Report()
+	expected := []struct {
+		key          string
+		count, error int
+	}{{`["a"]`, 4, 0}, {`["d"]`, 3, 2}}
+	for i, e := range expected {
+		row := report.Rows[i]
+		if string(row.Key) != e.key || row.Count != e.count || row.Error != e.error {
+			t.Fatal(fmt.Sprintf("Expected %s with count %d and error %d, got %+v", e.key, e.count, e.error, row))
+		}
+	}
+	if d := report.Rows[1]; !d.First.Equal(at.Add(6*time.Second)) || !d.Last.Equal(d.First) {
+		t.Fatal(fmt.Sprintf("Expected the time range to restart on replacement, got %+v", d))
+	}
+}
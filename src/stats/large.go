@@ -0,0 +1,116 @@
+package stats
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/tanema/binlog-parser/src/parser"
+)
+
+// Thresholds above which a transaction is large, a zero threshold is off
+type Thresholds struct {
+	Rows     int
+	Bytes    uint64
+	Duration time.Duration
+	Tables   int
+}
+
+// Exceeded returns the names of the thresholds the transaction is above
+func (t Thresholds) Exceeded(transaction parser.Transaction) []string {
+	var exceeded []string
+	if t.Rows > 0 && transaction.Rows > t.Rows {
+		exceeded = append(exceeded, "rows")
+	}
+	if t.Bytes > 0 && transaction.Bytes > t.Bytes {
+		exceeded = append(exceeded, "bytes")
+	}
+	if t.Duration > 0 && transaction.End.Sub(transaction.Begin) > t.Duration {
+		exceeded = append(exceeded, "duration")
+	}
+	if t.Tables > 0 && len(transaction.Tables) > t.Tables {
+		exceeded = append(exceeded, "tables")
+	}
+	return exceeded
+}
+
+// LargeTransaction is a transaction above some of the thresholds
+type LargeTransaction struct {
+	parser.Transaction
+	Duration time.Duration
+	Exceeded []string
+}
+
+// LargeTransactions collects the transactions above the thresholds, it's a
+// sink that ignores the messages and gets the transactions of the parser
+type LargeTransactions struct {
+	Thresholds
+	Found []LargeTransaction
+}
+
+// NewLargeTransactions creates a detector for the thresholds
+func NewLargeTransactions(thresholds Thresholds) *LargeTransactions {
+	return &LargeTransactions{Thresholds: thresholds, Found: []LargeTransaction{}}
+}
+
+// Transaction keeps the transaction if it's large, it's a
+// parser.TransactionFunc
+func (l *LargeTransactions) Transaction(transaction parser.Transaction) error {
+	if exceeded := l.Exceeded(transaction); len(exceeded) > 0 {
+		l.Found = append(l.Found, LargeTransaction{
+			Transaction: transaction,
+			Duration:    transaction.End.Sub(transaction.Begin),
+			Exceeded:    exceeded,
+		})
+	}
+	return nil
+}
+
+// Write ignores the message
+func (l *LargeTransactions) Write(message parser.Message) error {
+	return nil
+}
+
+// Flush does nothing
+func (l *LargeTransactions) Flush() error {
+	return nil
+}
+
+// Close does nothing
+func (l *LargeTransactions) Close() error {
+	return nil
+}
+
+// WriteTable prints the large transactions as text table
+func (l *LargeTransactions) WriteTable(out io.Writer) error {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "XID\tGTID\tPositions\tBegin\tDuration\tRows\tBytes\tTables\tExceeded\tFirst query\n")
+	for _, large := range l.Found {
+		tables := make([]string, len(large.Tables))
+		for i, table := range large.Tables {
+			tables[i] = table.Schema + "." + table.Table
+		}
+		gtid := large.GTID
+		if gtid == "" {
+			gtid = "-"
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s:%d-%d\t%s\t%s\t%d\t%d\t%s\t%s\t%s\n",
+			large.XID, gtid, large.BinlogFile, large.StartPosition, large.EndPosition,
+			large.Begin.Format(time.RFC3339), large.Duration, large.Rows, large.Bytes,
+			strings.Join(tables, ","), strings.Join(large.Exceeded, ","), firstLine(string(large.FirstQuery), 80))
+	}
+	return w.Flush()
+}
+
+// firstLine shortens a query to its first line of at most n characters
+func firstLine(query string, n int) string {
+	if i := strings.IndexAny(query, "\r\n"); i >= 0 {
+		query = query[:i] + " ..."
+	}
+	if len(query) > n {
+		query = query[:n-4] + " ..."
+	}
+	return query
+}
@@ -0,0 +1,65 @@
+package stats
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tanema/binlog-parser/src/parser"
+)
+
+func TestThresholds(t *testing.T) {
+	begin := time.Date(2017, 4, 13, 6, 34, 37, 0, time.UTC)
+	transaction := parser.Transaction{
+		Begin:  begin,
+		End:    begin.Add(2 * time.Minute),
+		Rows:   20000,
+		Bytes:  1 << 20,
+		Tables: []parser.TableName{{Schema: "test_db", Table: "rooms"}, {Schema: "test_db", Table: "buildings"}},
+	}
+	testCases := []struct {
+		thresholds Thresholds
+		expected   []string
+	}{
+		{Thresholds{}, nil},
+		{Thresholds{Rows: 20000, Bytes: 1 << 20, Duration: 2 * time.Minute, Tables: 2}, nil},
+		{Thresholds{Rows: 10000, Bytes: 100 << 20, Duration: time.Minute, Tables: 1}, []string{"rows", "duration", "tables"}},
+		{Thresholds{Bytes: 1 << 10}, []string{"bytes"}},
+	}
+	for _, tc := range testCases {
+		if actual := tc.thresholds.Exceeded(transaction); !reflect.DeepEqual(actual, tc.expected) {
+			t.Fatal(fmt.Sprintf("Expected %v for %+v, got %v", tc.expected, tc.thresholds, actual))
+		}
+	}
+}
+
+func TestLargeTransactions(t *testing.T) {
+	detector := NewLargeTransactions(Thresholds{Rows: 1})
+	begin := time.Date(2017, 4, 13, 6, 34, 37, 0, time.UTC)
+	detector.Transaction(parser.Transaction{XID: 9, Rows: 1, Begin: begin, End: begin})
+	detector.Transaction(parser.Transaction{
+		XID:           10,
+		GTID:          "3e11fa47-71ca-11e1-9e33-c80aa9429562:23",
+		BinlogFile:    "mysql-bin.01",
+		StartPosition: 428,
+		EndPosition:   723,
+		Begin:         begin,
+		End:           begin.Add(time.Second),
+		Rows:          5,
+		Tables:        []parser.TableName{{Schema: "test_db", Table: "rooms"}},
+		FirstQuery:    "UPDATE rooms\nSET name = 'x'",
+	})
+	if len(detector.Found) != 1 || detector.Found[0].XID != 10 || detector.Found[0].Duration != time.Second {
+		t.Fatal(fmt.Sprintf("Wrong large transactions %+v", detector.Found))
+	}
+
+	var out bytes.Buffer
+	detector.WriteTable(&out)
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[1], "mysql-bin.01:428-723") || !strings.HasSuffix(lines[1], "UPDATE rooms ...") {
+		t.Fatal(fmt.Sprintf("Unexpected table output %s", out.String()))
+	}
+}
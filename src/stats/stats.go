@@ -0,0 +1,263 @@
+package stats
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/tanema/binlog-parser/src/parser"
+)
+
+// DefaultTop is the number of largest transactions kept by Stats
+const DefaultTop = 10
+
+// Stats summarizes a binlog from its events and the row messages of the
+// parser: events by type, rows per table, transactions, the largest
+// transactions and the rows written per minute. It's a sink, so it can be
+// fed by the parser like any output.
+type Stats struct {
+	Top     int
+	events  map[string]int
+	tables  map[string]*TableStats
+	minutes map[time.Time]int
+	report  Report
+	current Transaction
+}
+
+// Report is the summary of a binlog
+type Report struct {
+	Start           time.Time
+	End             time.Time
+	Events          map[string]int
+	Tables          map[string]*TableStats
+	Transactions    int
+	Compression     Compression
+	LargestByRows   []Transaction
+	LargestByBytes  []Transaction
+	WritesPerMinute []MinuteWrites
+}
+
+// Compression sums the sizes of the transactions compressed with
+// binlog_transaction_compression=ON, Ratio is their uncompressed size divided
+// by their compressed size
+type Compression struct {
+	Transactions      int
+	Bytes             uint64
+	UncompressedBytes uint64
+	Ratio             float64
+}
+
+// TableStats counts the rows changed in a table
+type TableStats struct {
+	Inserted int
+	Updated  int
+	Deleted  int
+}
+
+// Transaction describes a transaction by its size, Bytes is the size of the
+// events since the previous transaction or GTID event, compressed
+// transactions count with their compressed size
+type Transaction struct {
+	XID           uint64
+	BinlogFile    string
+	StartPosition uint32
+	Time          time.Time
+	Rows          int
+	Bytes         uint64
+}
+
+// MinuteWrites is the number of rows changed in a minute
+type MinuteWrites struct {
+	Minute time.Time
+	Rows   int
+}
+
+// New creates empty stats
+func New() *Stats {
+	return &Stats{
+		Top:     DefaultTop,
+		events:  map[string]int{},
+		tables:  map[string]*TableStats{},
+		minutes: map[time.Time]int{},
+	}
+}
+
+// Event counts a binlog event, it's a parser.EventFunc
+func (s *Stats) Event(event parser.EventMetadata, timestamp time.Time) error {
+	s.events[event.EventType]++
+	// the format description and rotate events at the start have no time
+	if timestamp.Unix() > 0 {
+		if s.report.Start.IsZero() || timestamp.Before(s.report.Start) {
+			s.report.Start = timestamp
+		}
+		if timestamp.After(s.report.End) {
+			s.report.End = timestamp
+		}
+	}
+	switch event.EventType {
+	case "GTIDEvent", "AnonymousGTIDEvent", "MariadbGTIDEvent":
+		s.current = Transaction{}
+	case "TransactionPayloadEvent":
+		s.report.Compression.Transactions++
+		s.report.Compression.Bytes += uint64(event.EventSize)
+		s.report.Compression.UncompressedBytes += uint64(event.UncompressedSize)
+	}
+	if event.Compressed {
+		// counted with the size of the payload event
+		return nil
+	}
+	if s.current.Bytes == 0 {
+		s.current.BinlogFile = event.BinlogFile
+		s.current.StartPosition = event.StartPosition
+		s.current.Time = timestamp
+	}
+	s.current.Bytes += uint64(event.EventSize)
+	return nil
+}
+
+// Write counts the row of a message
+func (s *Stats) Write(message parser.Message) error {
+	if message.GetType() == parser.MessageTypeQuery {
+		return nil
+	}
+	header := message.GetHeader()
+	name := header.Schema + "." + header.Table
+	table, ok := s.tables[name]
+	if !ok {
+		table = &TableStats{}
+		s.tables[name] = table
+	}
+	switch message.GetType() {
+	case parser.MessageTypeInsert:
+		table.Inserted++
+	case parser.MessageTypeUpdate:
+		table.Updated++
+	case parser.MessageTypeDelete:
+		table.Deleted++
+	}
+	s.current.Rows++
+	if t, err := time.Parse(time.RFC3339, header.BinlogMessageTime); err == nil {
+		s.minutes[t.Truncate(time.Minute)]++
+	}
+	return nil
+}
+
+// Commit ends the current transaction
+func (s *Stats) Commit(xid uint64) error {
+	s.current.XID = xid
+	s.report.Transactions++
+	s.report.LargestByRows = largest(s.report.LargestByRows, s.current, s.Top, func(a, b Transaction) bool { return a.Rows > b.Rows })
+	s.report.LargestByBytes = largest(s.report.LargestByBytes, s.current, s.Top, func(a, b Transaction) bool { return a.Bytes > b.Bytes })
+	s.current = Transaction{}
+	return nil
+}
+
+// Flush does nothing, the stats are kept in memory
+func (s *Stats) Flush() error {
+	return nil
+}
+
+// Close does nothing, the stats are kept in memory
+func (s *Stats) Close() error {
+	return nil
+}
+
+// Report returns the summary of everything seen so far
+func (s *Stats) Report() Report {
+	report := s.report
+	report.Events = s.events
+	report.Tables = s.tables
+	if report.Compression.Bytes > 0 {
+		report.Compression.Ratio = float64(report.Compression.UncompressedBytes) / float64(report.Compression.Bytes)
+	}
+	if report.LargestByRows == nil {
+		report.LargestByRows, report.LargestByBytes = []Transaction{}, []Transaction{}
+	}
+	report.WritesPerMinute = make([]MinuteWrites, 0, len(s.minutes))
+	for minute, rows := range s.minutes {
+		report.WritesPerMinute = append(report.WritesPerMinute, MinuteWrites{Minute: minute, Rows: rows})
+	}
+	sort.Slice(report.WritesPerMinute, func(i, j int) bool {
+		return report.WritesPerMinute[i].Minute.Before(report.WritesPerMinute[j].Minute)
+	})
+	return report
+}
+
+// WriteTable prints the report as text tables
+func (r Report) WriteTable(out io.Writer) error {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	if r.Start.IsZero() {
+		fmt.Fprintf(w, "Time span\t-\n")
+	} else {
+		fmt.Fprintf(w, "Time span\t%s - %s (%s)\n", r.Start.Format(time.RFC3339), r.End.Format(time.RFC3339), r.End.Sub(r.Start))
+	}
+	fmt.Fprintf(w, "Transactions\t%d\n", r.Transactions)
+	if r.Compression.Transactions > 0 {
+		fmt.Fprintf(w, "Compressed\t%d transactions, %d bytes from %d (ratio %.2f)\n", r.Compression.Transactions, r.Compression.Bytes, r.Compression.UncompressedBytes, r.Compression.Ratio)
+	}
+
+	fmt.Fprintf(w, "\nEvent type\tCount\n")
+	for _, name := range sortedKeys(r.Events) {
+		fmt.Fprintf(w, "%s\t%d\n", name, r.Events[name])
+	}
+
+	fmt.Fprintf(w, "\nTable\tInserted\tUpdated\tDeleted\n")
+	tables := make([]string, 0, len(r.Tables))
+	for name := range r.Tables {
+		tables = append(tables, name)
+	}
+	sort.Strings(tables)
+	for _, name := range tables {
+		table := r.Tables[name]
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\n", name, table.Inserted, table.Updated, table.Deleted)
+	}
+
+	for _, largest := range []struct {
+		title        string
+		transactions []Transaction
+	}{{"rows", r.LargestByRows}, {"bytes", r.LargestByBytes}} {
+		fmt.Fprintf(w, "\nLargest transactions by %s\tRows\tBytes\tPosition\tTime\n", largest.title)
+		for _, transaction := range largest.transactions {
+			fmt.Fprintf(w, "XID %d\t%d\t%d\t%s:%d\t%s\n", transaction.XID, transaction.Rows, transaction.Bytes, transaction.BinlogFile, transaction.StartPosition, transaction.Time.Format(time.RFC3339))
+		}
+	}
+
+	fmt.Fprintf(w, "\nMinute\tRows\n")
+	max := 0
+	for _, minute := range r.WritesPerMinute {
+		if minute.Rows > max {
+			max = minute.Rows
+		}
+	}
+	for _, minute := range r.WritesPerMinute {
+		fmt.Fprintf(w, "%s\t%d\t%s\n", minute.Minute.Format("2006-01-02 15:04"), minute.Rows, strings.Repeat("#", (minute.Rows*40+max-1)/max))
+	}
+	return w.Flush()
+}
+
+// largest adds the transaction to the list sorted by size, keeping the top n
+func largest(transactions []Transaction, transaction Transaction, n int, larger func(a, b Transaction) bool) []Transaction {
+	i := sort.Search(len(transactions), func(i int) bool { return larger(transaction, transactions[i]) })
+	if i >= n {
+		return transactions
+	}
+	transactions = append(transactions, Transaction{})
+	copy(transactions[i+1:], transactions[i:])
+	transactions[i] = transaction
+	if len(transactions) > n {
+		transactions = transactions[:n]
+	}
+	return transactions
+}
+
+func sortedKeys(m map[string]int) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
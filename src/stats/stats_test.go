@@ -0,0 +1,97 @@
+package stats
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tanema/binlog-parser/src/database"
+	"github.com/tanema/binlog-parser/src/parser"
+)
+
+func TestStats(t *testing.T) {
+	s := New()
+	p := parser.New(database.Offline(), s.Write)
+	p.OnCommit(s.Commit)
+	p.OnEvent(s.Event)
+	if err := p.ParseFile("../../test/data/fixtures/mysql-bin.01", 0); err != nil {
+		t.Fatal(err)
+	}
+	report := s.Report()
+
+	if report.Transactions != 4 || report.Events["XIDEvent"] != 4 || report.Events["WriteRowsEventV2"] != 2 {
+		t.Fatal(fmt.Sprintf("Wrong counts %d, %v", report.Transactions, report.Events))
+	}
+	expectedTables := map[string]*TableStats{
+		"test_db.buildings": {Inserted: 2, Deleted: 1},
+		"test_db.rooms":     {Inserted: 5, Updated: 2},
+	}
+	if !reflect.DeepEqual(report.Tables, expectedTables) {
+		t.Fatal(fmt.Sprintf("Expected tables %v, got %v", expectedTables, report.Tables))
+	}
+	if start := time.Date(2017, 4, 13, 6, 34, 12, 0, time.UTC); !report.Start.Equal(start) {
+		t.Fatal(fmt.Sprintf("Expected start %s, got %s", start, report.Start))
+	}
+	if largest := report.LargestByRows[0]; largest.XID != 10 || largest.Rows != 5 || largest.StartPosition != 428 || largest.BinlogFile != "mysql-bin.01" {
+		t.Fatal(fmt.Sprintf("Wrong largest transaction %+v", largest))
+	}
+	if len(report.WritesPerMinute) != 2 || report.WritesPerMinute[0].Rows != 9 {
+		t.Fatal(fmt.Sprintf("Wrong writes per minute %v", report.WritesPerMinute))
+	}
+
+	var out bytes.Buffer
+	if err := report.WriteTable(&out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "test_db.rooms      5         2        0") {
+		t.Fatal(fmt.Sprintf("Unexpected table output %s", out.String()))
+	}
+}
+
+func TestStatsCompression(t *testing.T) {
+	s := New()
+	p := parser.New(database.Offline(), s.Write)
+	p.OnCommit(s.Commit)
+	p.OnEvent(s.Event)
+	if err := p.ParseFile("../../test/data/fixtures/mysql-bin.08", 0); err != nil {
+		t.Fatal(err)
+	}
+	report := s.Report()
+
+	expected := Compression{Transactions: 4, Bytes: 999, UncompressedBytes: 1089, Ratio: 1089.0 / 999}
+	if report.Compression != expected {
+		t.Fatal(fmt.Sprintf("Expected compression %+v, got %+v", expected, report.Compression))
+	}
+	if report.Transactions != 4 || report.Events["TransactionPayloadEvent"] != 4 || report.Events["WriteRowsEventV2"] != 2 {
+		t.Fatal(fmt.Sprintf("Wrong counts %d, %v", report.Transactions, report.Events))
+	}
+	if largest := report.LargestByBytes[0]; largest.XID != 9 || largest.Bytes != 396 {
+		t.Fatal(fmt.Sprintf("Expected the compressed size of the largest transaction, got %+v", largest))
+	}
+
+	var out bytes.Buffer
+	if err := report.WriteTable(&out); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "Compressed    4 transactions, 999 bytes from 1089 (ratio 1.09)") {
+		t.Fatal(fmt.Sprintf("Unexpected table output %s", out.String()))
+	}
+}
+
+func TestLargest(t *testing.T) {
+	byRows := func(a, b Transaction) bool { return a.Rows > b.Rows }
+	var transactions []Transaction
+	for i, rows := range []int{3, 1, 5, 2, 4} {
+		transactions = largest(transactions, Transaction{XID: uint64(i), Rows: rows}, 3, byRows)
+	}
+	var rows []int
+	for _, transaction := range transactions {
+		rows = append(rows, transaction.Rows)
+	}
+	if expected := []int{5, 4, 3}; !reflect.DeepEqual(rows, expected) {
+		t.Fatal(fmt.Sprintf("Expected %v, got %v", expected, rows))
+	}
+}